@@ -9,6 +9,15 @@ type ExpressionVisitor interface {
 	VisitAssignment(expr *AssignmentExpression) interface{}
 	VisitCall(expr *CallExpression) interface{}
 	VisitTypeCast(expr *TypeCastExpression) interface{}
+	VisitSubscript(expr *SubscriptExpression) interface{}
+	VisitSubscriptAssignment(expr *SubscriptAssignmentExpression) interface{}
+	VisitSliceLiteral(expr *SliceLiteralExpression) interface{}
+	VisitField(expr *FieldExpression) interface{}
+	VisitFieldAssignment(expr *FieldAssignmentExpression) interface{}
+	VisitStructLiteral(expr *StructLiteralExpression) interface{}
+	VisitMapLiteral(expr *MapLiteralExpression) interface{}
+	VisitTuple(expr *TupleExpression) interface{}
+	VisitFunctionLiteral(expr *FunctionLiteralExpression) interface{}
 }
 type Expression interface {
 	Accept(visitor ExpressionVisitor) interface{}
@@ -70,8 +79,11 @@ func (expr *GroupingExpression) String() string {
 }
 
 type IdentifierExpression struct {
-	name  Token
-	depth int
+	name      Token
+	depth     int
+	narrowed  bool
+	binding   Binding
+	generated bool
 }
 
 func (expr *IdentifierExpression) Accept(visitor ExpressionVisitor) interface{} {
@@ -84,9 +96,10 @@ func (expr *IdentifierExpression) String() string {
 }
 
 type AssignmentExpression struct {
-	name  Token
-	value Expression
-	depth int
+	name    Token
+	value   Expression
+	depth   int
+	binding Binding
 }
 
 func (expr *AssignmentExpression) Accept(visitor ExpressionVisitor) interface{} {
@@ -102,6 +115,7 @@ type CallExpression struct {
 	callee    Expression
 	arguments []Expression
 	loc       Token
+	tail      bool
 }
 
 func (expr *CallExpression) Accept(visitor ExpressionVisitor) interface{} {
@@ -129,6 +143,137 @@ func (expr *TypeCastExpression) String() string {
 	return printer.builder.String()
 }
 
+type SubscriptExpression struct {
+	object Expression
+	index  Expression
+	loc    Token
+}
+
+func (expr *SubscriptExpression) Accept(visitor ExpressionVisitor) interface{} {
+	return visitor.VisitSubscript(expr)
+}
+func (expr *SubscriptExpression) String() string {
+	printer := AstPrinter{}
+	printer.VisitExpressionNode(expr)
+	return printer.builder.String()
+}
+
+type SubscriptAssignmentExpression struct {
+	target *SubscriptExpression
+	value  Expression
+}
+
+func (expr *SubscriptAssignmentExpression) Accept(visitor ExpressionVisitor) interface{} {
+	return visitor.VisitSubscriptAssignment(expr)
+}
+func (expr *SubscriptAssignmentExpression) String() string {
+	printer := AstPrinter{}
+	printer.VisitExpressionNode(expr)
+	return printer.builder.String()
+}
+
+type SliceLiteralExpression struct {
+	atype    *Type
+	elements []Expression
+	loc      Token
+}
+
+func (expr *SliceLiteralExpression) Accept(visitor ExpressionVisitor) interface{} {
+	return visitor.VisitSliceLiteral(expr)
+}
+func (expr *SliceLiteralExpression) String() string {
+	printer := AstPrinter{}
+	printer.VisitExpressionNode(expr)
+	return printer.builder.String()
+}
+
+type FieldExpression struct {
+	object Expression
+	name   Token
+}
+
+func (expr *FieldExpression) Accept(visitor ExpressionVisitor) interface{} {
+	return visitor.VisitField(expr)
+}
+func (expr *FieldExpression) String() string {
+	printer := AstPrinter{}
+	printer.VisitExpressionNode(expr)
+	return printer.builder.String()
+}
+
+type FieldAssignmentExpression struct {
+	target *FieldExpression
+	value  Expression
+}
+
+func (expr *FieldAssignmentExpression) Accept(visitor ExpressionVisitor) interface{} {
+	return visitor.VisitFieldAssignment(expr)
+}
+func (expr *FieldAssignmentExpression) String() string {
+	printer := AstPrinter{}
+	printer.VisitExpressionNode(expr)
+	return printer.builder.String()
+}
+
+type StructLiteralExpression struct {
+	atype  *Type
+	fields []Token
+	values []Expression
+	loc    Token
+}
+
+func (expr *StructLiteralExpression) Accept(visitor ExpressionVisitor) interface{} {
+	return visitor.VisitStructLiteral(expr)
+}
+func (expr *StructLiteralExpression) String() string {
+	printer := AstPrinter{}
+	printer.VisitExpressionNode(expr)
+	return printer.builder.String()
+}
+
+type MapLiteralExpression struct {
+	atype  *Type
+	keys   []Expression
+	values []Expression
+	loc    Token
+}
+
+func (expr *MapLiteralExpression) Accept(visitor ExpressionVisitor) interface{} {
+	return visitor.VisitMapLiteral(expr)
+}
+func (expr *MapLiteralExpression) String() string {
+	printer := AstPrinter{}
+	printer.VisitExpressionNode(expr)
+	return printer.builder.String()
+}
+
+type TupleExpression struct {
+	elements []Expression
+	loc      Token
+}
+
+func (expr *TupleExpression) Accept(visitor ExpressionVisitor) interface{} {
+	return visitor.VisitTuple(expr)
+}
+func (expr *TupleExpression) String() string {
+	printer := AstPrinter{}
+	printer.VisitExpressionNode(expr)
+	return printer.builder.String()
+}
+
+type FunctionLiteralExpression struct {
+	function *FunctionStatement
+}
+
+func (expr *FunctionLiteralExpression) Accept(visitor ExpressionVisitor) interface{} {
+	return visitor.VisitFunctionLiteral(expr)
+}
+func (expr *FunctionLiteralExpression) String() string {
+	printer := AstPrinter{}
+	printer.VisitExpressionNode(expr)
+	return printer.builder.String()
+}
+
 type StatementVisitor interface {
 	VisitExpression(stmt *ExpressionStatement) interface{}
 	VisitPrint(stmt *PrintStatement) interface{}
@@ -138,6 +283,9 @@ type StatementVisitor interface {
 	VisitWhile(stmt *WhileStatement) interface{}
 	VisitFunction(stmt *FunctionStatement) interface{}
 	VisitReturn(stmt *ReturnStatement) interface{}
+	VisitBreak(stmt *BreakStatement) interface{}
+	VisitContinue(stmt *ContinueStatement) interface{}
+	VisitStruct(stmt *StructStatement) interface{}
 }
 type Statement interface {
 	Accept(visitor StatementVisitor) interface{}
@@ -172,8 +320,11 @@ func (stmt *PrintStatement) String() string {
 
 type LetStatement struct {
 	name        Token
+	names       []Token
 	initializer Expression
 	atype       *Type
+	inferred    bool
+	variables   []*Variable
 }
 
 func (stmt *LetStatement) Accept(visitor StatementVisitor) interface{} {
@@ -217,6 +368,8 @@ func (stmt *IfStatement) String() string {
 type WhileStatement struct {
 	condition Expression
 	body      Statement
+	increment Expression
+	label     *Token
 	loc       Token
 }
 
@@ -234,6 +387,8 @@ type FunctionStatement struct {
 	parameters []Token
 	body       *BlockStatement
 	atype      FunctionType
+	variable   *Variable
+	layout     *FunctionLayout
 }
 
 func (stmt *FunctionStatement) Accept(visitor StatementVisitor) interface{} {
@@ -258,3 +413,49 @@ func (stmt *ReturnStatement) String() string {
 	printer.VisitStatementNode(stmt)
 	return printer.builder.String()
 }
+
+type BreakStatement struct {
+	label  *Token
+	target *WhileStatement
+	loc    Token
+}
+
+func (stmt *BreakStatement) Accept(visitor StatementVisitor) interface{} {
+	return visitor.VisitBreak(stmt)
+}
+func (stmt *BreakStatement) String() string {
+	printer := AstPrinter{}
+	printer.VisitStatementNode(stmt)
+	return printer.builder.String()
+}
+
+type ContinueStatement struct {
+	label  *Token
+	target *WhileStatement
+	loc    Token
+}
+
+func (stmt *ContinueStatement) Accept(visitor StatementVisitor) interface{} {
+	return visitor.VisitContinue(stmt)
+}
+func (stmt *ContinueStatement) String() string {
+	printer := AstPrinter{}
+	printer.VisitStatementNode(stmt)
+	return printer.builder.String()
+}
+
+type StructStatement struct {
+	name    Token
+	fields  []Token
+	methods []*FunctionStatement
+	atype   *Type
+}
+
+func (stmt *StructStatement) Accept(visitor StatementVisitor) interface{} {
+	return visitor.VisitStruct(stmt)
+}
+func (stmt *StructStatement) String() string {
+	printer := AstPrinter{}
+	printer.VisitStatementNode(stmt)
+	return printer.builder.String()
+}
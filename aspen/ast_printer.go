@@ -84,6 +84,77 @@ func (p *AstPrinter) VisitTypeCast(expr *TypeCastExpression) interface{} {
 	return nil
 }
 
+func (p *AstPrinter) VisitSubscript(expr *SubscriptExpression) interface{} {
+	p.parenthesize("subscript", expr.object, expr.index)
+	return nil
+}
+
+func (p *AstPrinter) VisitSubscriptAssignment(expr *SubscriptAssignmentExpression) interface{} {
+	p.parenthesize("=", expr.target, expr.value)
+	return nil
+}
+
+func (p *AstPrinter) VisitSliceLiteral(expr *SliceLiteralExpression) interface{} {
+	in := make([]interface{}, len(expr.elements))
+	for i, element := range expr.elements {
+		in[i] = element
+	}
+
+	p.parenthesize(fmt.Sprintf("slice %v", expr.atype), in...)
+	return nil
+}
+
+func (p *AstPrinter) VisitMapLiteral(expr *MapLiteralExpression) interface{} {
+	builder := strings.Builder{}
+
+	fmt.Fprintf(&builder, "map %v", expr.atype)
+
+	for i := range expr.keys {
+		fmt.Fprintf(&builder, " (%v %v)", expr.keys[i], expr.values[i])
+	}
+
+	p.parenthesize(builder.String())
+	return nil
+}
+
+func (p *AstPrinter) VisitTuple(expr *TupleExpression) interface{} {
+	in := make([]interface{}, len(expr.elements))
+	for i, element := range expr.elements {
+		in[i] = element
+	}
+
+	p.parenthesize("tuple", in...)
+	return nil
+}
+
+func (p *AstPrinter) VisitField(expr *FieldExpression) interface{} {
+	p.parenthesize(fmt.Sprintf("field %v", expr.name), expr.object)
+	return nil
+}
+
+func (p *AstPrinter) VisitFieldAssignment(expr *FieldAssignmentExpression) interface{} {
+	p.parenthesize("=", expr.target, expr.value)
+	return nil
+}
+
+func (p *AstPrinter) VisitStructLiteral(expr *StructLiteralExpression) interface{} {
+	builder := strings.Builder{}
+
+	fmt.Fprintf(&builder, "struct %v", expr.atype)
+
+	for i := range expr.fields {
+		fmt.Fprintf(&builder, " (%v %v)", expr.fields[i], expr.values[i])
+	}
+
+	p.parenthesize(builder.String())
+	return nil
+}
+
+func (p *AstPrinter) VisitFunctionLiteral(expr *FunctionLiteralExpression) interface{} {
+	p.function("fn ", expr.function)
+	return nil
+}
+
 func (p *AstPrinter) VisitExpression(stmt *ExpressionStatement) interface{} {
 	p.parenthesize("expr", stmt.expr)
 	return nil
@@ -95,7 +166,23 @@ func (p *AstPrinter) VisitPrint(stmt *PrintStatement) interface{} {
 }
 
 func (p *AstPrinter) VisitLet(stmt *LetStatement) interface{} {
-	p.parenthesize(fmt.Sprintf("let %s %s", stmt.name.value, stmt.atype), stmt.initializer)
+	name := fmt.Sprint(stmt.name.value)
+	if stmt.names != nil {
+		names := make([]string, len(stmt.names))
+		for i, token := range stmt.names {
+			names[i] = token.String()
+		}
+		name = "(" + strings.Join(names, " ") + ")"
+	}
+
+	if !stmt.inferred {
+		p.parenthesize(fmt.Sprintf("let %s %s", name, stmt.atype), stmt.initializer)
+	} else if stmt.atype == nil {
+		// the type has not been inferred by the type checker yet
+		p.parenthesize(fmt.Sprintf("let %s inferred", name), stmt.initializer)
+	} else {
+		p.parenthesize(fmt.Sprintf("let %s (inferred %s)", name, stmt.atype), stmt.initializer)
+	}
 	return nil
 }
 
@@ -110,14 +197,42 @@ func (p *AstPrinter) VisitIf(stmt *IfStatement) interface{} {
 }
 
 func (p *AstPrinter) VisitWhile(stmt *WhileStatement) interface{} {
-	p.parenthesize("while", stmt.condition, stmt.body)
+	name := "while"
+	if stmt.label != nil {
+		name = fmt.Sprintf("while (label %v)", stmt.label)
+	}
+
+	p.parenthesize(name, stmt.condition, stmt.body, stmt.increment)
+	return nil
+}
+
+func (p *AstPrinter) VisitBreak(stmt *BreakStatement) interface{} {
+	if stmt.label != nil {
+		p.parenthesize(fmt.Sprintf("break %v", stmt.label))
+	} else {
+		p.parenthesize("break")
+	}
+	return nil
+}
+
+func (p *AstPrinter) VisitContinue(stmt *ContinueStatement) interface{} {
+	if stmt.label != nil {
+		p.parenthesize(fmt.Sprintf("continue %v", stmt.label))
+	} else {
+		p.parenthesize("continue")
+	}
 	return nil
 }
 
 func (p *AstPrinter) VisitFunction(stmt *FunctionStatement) interface{} {
+	p.function(fmt.Sprintf("fn %s ", stmt.name), stmt)
+	return nil
+}
+
+func (p *AstPrinter) function(name string, stmt *FunctionStatement) {
 	builder := strings.Builder{}
 
-	fmt.Fprintf(&builder, "fn %s ", stmt.name)
+	builder.WriteString(name)
 
 	fmt.Fprintf(&builder, "(return %v)", stmt.atype.returnType)
 
@@ -127,6 +242,24 @@ func (p *AstPrinter) VisitFunction(stmt *FunctionStatement) interface{} {
 	}
 
 	p.parenthesize(builder.String(), ConvertStatementList(stmt.body.statements)...)
+}
+
+func (p *AstPrinter) VisitStruct(stmt *StructStatement) interface{} {
+	builder := strings.Builder{}
+
+	fmt.Fprintf(&builder, "struct %s", stmt.name)
+
+	other := stmt.atype.other.(*StructType)
+	for i := range other.fields {
+		fmt.Fprintf(&builder, " (field %s %v)", other.fields[i].name, other.fields[i].atype)
+	}
+
+	in := make([]interface{}, len(stmt.methods))
+	for i, method := range stmt.methods {
+		in[i] = method
+	}
+
+	p.parenthesize(builder.String(), in...)
 	return nil
 }
 
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// the errors a runtime error wraps when the program exceeded one of the limits of its RuntimeOptions, a
+// program that ran past its deadline wraps the error of its context instead
+var (
+	ErrStepLimit       = errors.New("step limit exceeded")
+	ErrAllocationLimit = errors.New("allocation limit exceeded")
+)
+
+// the approximate sizes in bytes of the elements of strings, slices and maps, an entry of a map holds its key, its
+// value and its position in the index of the map
+const (
+	STRING_CHAR_SIZE   = 4
+	SLICE_ELEMENT_SIZE = 16
+	MAP_ENTRY_SIZE     = 48
+)
+
+// DEADLINE_CHECK_INTERVAL is the number of steps between two checks of the context of a program
+const DEADLINE_CHECK_INTERVAL = 1024
+
+// Budget enforces the limits of RuntimeOptions on a running program. A step is an iteration of a loop or a call,
+// so that the interpreter and the bytecode vm count the same steps for a program.
+type Budget struct {
+	steps         int64
+	maxSteps      int64
+	allocated     int64
+	maxAllocation int64
+	context       context.Context
+
+	// the constants of the program that were counted, see AllocateConstant
+	constants map[interface{}]bool
+}
+
+func NewBudget(options RuntimeOptions) Budget {
+	return Budget{maxSteps: options.maxSteps, maxAllocation: options.maxAllocation, context: options.context}
+}
+
+// Step counts a step, it returns an error once the program has exceeded its step limit or its deadline
+func (b *Budget) Step() error {
+	b.steps++
+
+	if b.maxSteps != 0 && b.steps > b.maxSteps {
+		return ErrStepLimit
+	}
+
+	if b.context != nil && b.steps%DEADLINE_CHECK_INTERVAL == 0 {
+		return b.context.Err()
+	}
+
+	return nil
+}
+
+// Allocate counts `bytes` allocated by the program, it returns an error once the program has exceeded its
+// allocation limit
+func (b *Budget) Allocate(bytes int64) error {
+	b.allocated += bytes
+
+	if b.maxAllocation != 0 && b.allocated > b.maxAllocation {
+		return ErrAllocationLimit
+	}
+
+	return nil
+}
+
+// AllocateConstant counts the bytes of a constant string, such as a string literal or a string folded by the
+// optimizer, the first time the program uses the constant identified by `key`. Constants are only counted against
+// an allocation limit.
+func (b *Budget) AllocateConstant(key interface{}, value interface{}) error {
+	if b.maxAllocation == 0 || b.constants[key] {
+		return nil
+	}
+
+	if b.constants == nil {
+		b.constants = make(map[interface{}]bool)
+	}
+	b.constants[key] = true
+	return b.Allocate(ValueSize(value))
+}
+
+// Message returns the message of the runtime error reporting that the program exceeded a limit
+func (b *Budget) Message(err error) string {
+	switch {
+	case errors.Is(err, ErrStepLimit):
+		return fmt.Sprintf("exceeded the limit of %d steps.", b.maxSteps)
+	case errors.Is(err, ErrAllocationLimit):
+		return fmt.Sprintf("exceeded the allocation limit of %d bytes.", b.maxAllocation)
+	case errors.Is(err, context.DeadlineExceeded):
+		return "exceeded the time limit."
+	}
+	return "execution was cancelled."
+}
+
+// ValueSize approximates the bytes allocated for a string, a slice or a map, other values are not counted
+func ValueSize(value interface{}) int64 {
+	switch v := value.(type) {
+	case []rune:
+		return int64(len(v)) * STRING_CHAR_SIZE
+	case []interface{}:
+		return int64(len(v)) * SLICE_ELEMENT_SIZE
+	case *MapValue:
+		return int64(v.Len()) * MAP_ENTRY_SIZE
+	}
+	return 0
+}
+
+// ResultSize approximates the bytes allocated by a call to a native or builtin function
+func ResultSize(callee interface{}, arguments []interface{}, result interface{}) int64 {
+	if builtin, ok := callee.(*BuiltinFunction); ok && builtin.name == "append" {
+		// appending grows the slice in place most of the time, only count the appended elements
+		return int64(len(arguments)-1) * SLICE_ELEMENT_SIZE
+	}
+	return ValueSize(result)
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestBudgetSteps(t *testing.T) {
+	budget := NewBudget(RuntimeOptions{maxSteps: 3})
+
+	for i := 0; i < 3; i++ {
+		if err := budget.Step(); err != nil {
+			t.Fatalf("step %d: unexpected error %v", i+1, err)
+		}
+	}
+
+	if err := budget.Step(); !errors.Is(err, ErrStepLimit) {
+		t.Errorf("expected the step limit to be exceeded, got %v", err)
+	}
+}
+
+func TestBudgetAllocation(t *testing.T) {
+	budget := NewBudget(RuntimeOptions{maxAllocation: 64})
+
+	if err := budget.Allocate(ValueSize([]rune("hello"))); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	if err := budget.Allocate(ValueSize(make([]interface{}, 3))); !errors.Is(err, ErrAllocationLimit) {
+		t.Errorf("expected the allocation limit to be exceeded, got %v", err)
+	}
+}
+
+func TestBudgetConstants(t *testing.T) {
+	budget := NewBudget(RuntimeOptions{maxAllocation: 64})
+	constant := []rune("0123456789")
+
+	// a constant is counted the first time it is used only
+	for i := 0; i < 3; i++ {
+		if err := budget.AllocateConstant("a", constant); err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+	}
+
+	if err := budget.AllocateConstant("b", constant); !errors.Is(err, ErrAllocationLimit) {
+		t.Errorf("expected the allocation limit to be exceeded, got %v", err)
+	}
+}
+
+func TestBudgetCancelled(t *testing.T) {
+	Initialize()
+
+	source := []rune("let i = 0;\nwhile (true) {\n    i = i + 1;\n}\n")
+	ast, err := TypeCheckSource(source)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	program, err := CompileSource(source)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	options := DefaultRuntimeOptions()
+	options.context = ctx
+
+	for _, err := range []error{Interpret(ast, source, options), RunBytecode(program, source, options)} {
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("expected the program to be cancelled, got %v", err)
+		}
+	}
+}
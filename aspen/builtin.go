@@ -0,0 +1,134 @@
+package main
+
+import "fmt"
+
+func CheckArity(tc *TypeChecker, expr *CallExpression, arity int) {
+	if len(expr.arguments) < arity {
+		tc.FatalError(expr.loc, "not enough arguments in call to function.")
+	} else if len(expr.arguments) > arity {
+		tc.FatalError(expr.loc, "too many arguments in call to function.")
+	}
+}
+
+func InitializeBuiltins() {
+	DefineBuiltinFunction("len", func(tc *TypeChecker, expr *CallExpression, arguments []*Type) *Type {
+		CheckArity(tc, expr, 1)
+
+		if arguments[0].kind != TYPE_SLICE && arguments[0].kind != TYPE_STRING && arguments[0].kind != TYPE_MAP {
+			tc.FatalError(expr.loc, fmt.Sprintf("invalid argument of type %v for len.", arguments[0]))
+		}
+
+		return SimpleType(TYPE_I64)
+	}, func(args []interface{}) interface{} {
+		switch v := args[0].(type) {
+		case []interface{}:
+			return int64(len(v))
+		case []rune:
+			return int64(len(v))
+		case *MapValue:
+			return int64(v.Len())
+		}
+
+		Unreachable("builtin.go: len")
+		return nil
+	})
+
+	DefineBuiltinFunction("append", func(tc *TypeChecker, expr *CallExpression, arguments []*Type) *Type {
+		if len(arguments) == 0 {
+			tc.FatalError(expr.loc, "not enough arguments in call to function.")
+		}
+
+		if arguments[0].kind != TYPE_SLICE {
+			tc.FatalError(expr.loc, fmt.Sprintf("first argument to append must be a slice, got %v.", arguments[0]))
+		}
+
+		elementType := arguments[0].other.(SliceType).of
+
+		for i := 1; i < len(arguments); i++ {
+			if !TypesEqual(arguments[i], elementType) {
+				tc.Error(expr.loc,
+					fmt.Sprintf("cannot use argument of type %v as the %s parameter to append (expected %v).",
+						arguments[i],
+						OrdinalSuffixOf(i+1),
+						elementType))
+			}
+		}
+
+		return arguments[0]
+	}, func(args []interface{}) interface{} {
+		slice := args[0].([]interface{})
+		return append(slice, args[1:]...)
+	})
+
+	DefineBuiltinFunction("copy", func(tc *TypeChecker, expr *CallExpression, arguments []*Type) *Type {
+		CheckArity(tc, expr, 2)
+
+		if arguments[0].kind != TYPE_SLICE || !TypesEqual(arguments[0], arguments[1]) {
+			tc.FatalError(expr.loc, fmt.Sprintf("arguments to copy must be slices of the same type, got %v and %v.", arguments[0], arguments[1]))
+		}
+
+		return SimpleType(TYPE_I64)
+	}, func(args []interface{}) interface{} {
+		dst := args[0].([]interface{})
+		src := args[1].([]interface{})
+		return int64(copy(dst, src))
+	})
+
+	// str converts a value of any type to the string that print would output for it
+	DefineBuiltinFunction("str", func(tc *TypeChecker, expr *CallExpression, arguments []*Type) *Type {
+		CheckArity(tc, expr, 1)
+
+		if arguments[0].IsVoid() {
+			tc.FatalError(expr.loc, "cannot convert an expression of type void to a string.")
+		}
+
+		return SimpleType(TYPE_STRING)
+	}, func(args []interface{}) interface{} {
+		if s, ok := args[0].([]rune); ok {
+			return s
+		}
+		return []rune(FormatValue(args[0]))
+	})
+
+	// map related functions
+
+	// checkMapKey checks that the arguments are a map followed by one of its keys
+	checkMapKey := func(tc *TypeChecker, expr *CallExpression, arguments []*Type, name string) {
+		CheckArity(tc, expr, 2)
+
+		if arguments[0].kind != TYPE_MAP {
+			tc.FatalError(expr.loc, fmt.Sprintf("first argument to %s must be a map, got %v.", name, arguments[0]))
+		}
+
+		if key := arguments[0].other.(MapType).key; !IsAssignable(key, arguments[1]) {
+			tc.FatalError(expr.loc, fmt.Sprintf("cannot use argument of type %v as key of %v in %s.", arguments[1], arguments[0], name))
+		}
+	}
+
+	DefineBuiltinFunction("has", func(tc *TypeChecker, expr *CallExpression, arguments []*Type) *Type {
+		checkMapKey(tc, expr, arguments, "has")
+		return SimpleType(TYPE_BOOL)
+	}, func(args []interface{}) interface{} {
+		return args[0].(*MapValue).Has(args[1])
+	})
+
+	DefineBuiltinFunction("delete", func(tc *TypeChecker, expr *CallExpression, arguments []*Type) *Type {
+		checkMapKey(tc, expr, arguments, "delete")
+		return SimpleType(TYPE_VOID)
+	}, func(args []interface{}) interface{} {
+		args[0].(*MapValue).Delete(args[1])
+		return nil
+	})
+
+	DefineBuiltinFunction("keys", func(tc *TypeChecker, expr *CallExpression, arguments []*Type) *Type {
+		CheckArity(tc, expr, 1)
+
+		if arguments[0].kind != TYPE_MAP {
+			tc.FatalError(expr.loc, fmt.Sprintf("first argument to keys must be a map, got %v.", arguments[0]))
+		}
+
+		return &Type{kind: TYPE_SLICE, other: SliceType{of: arguments[0].other.(MapType).key}}
+	}, func(args []interface{}) interface{} {
+		return args[0].(*MapValue).Keys()
+	})
+}
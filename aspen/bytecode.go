@@ -0,0 +1,274 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+type OpCode byte
+
+const (
+	OP_CONSTANT OpCode = iota
+	OP_NIL
+	OP_TRUE
+	OP_FALSE
+	OP_POP
+
+	// variables
+	OP_DEFINE_GLOBAL
+	OP_GET_GLOBAL
+	OP_SET_GLOBAL
+	OP_GET_LOCAL
+	OP_SET_LOCAL
+	OP_GET_UPVALUE
+	OP_SET_UPVALUE
+	OP_CLOSE_UPVALUE
+	OP_CHECK_NIL
+
+	// operators
+	OP_EQUAL
+	OP_NOT_EQUAL
+	OP_GREATER
+	OP_GREATER_EQUAL
+	OP_LESS
+	OP_LESS_EQUAL
+	OP_BIT_OR
+	OP_BIT_XOR
+	OP_BIT_AND
+	OP_ADD
+	OP_SUBTRACT
+	OP_MULTIPLY
+	OP_DIVIDE
+	OP_MODULUS
+	OP_NOT
+	OP_NEGATE
+	OP_CAST
+
+	// control flow
+	OP_JUMP
+	OP_JUMP_IF_FALSE
+	OP_LOOP
+	OP_CALL
+	OP_TAIL_CALL
+	OP_CLOSURE
+	OP_RETURN
+	OP_PRINT
+
+	// compound values
+	OP_SLICE
+	OP_MAP
+	OP_TUPLE
+	OP_STRUCT
+	OP_INDEX
+	OP_CHECK_INDEX
+	OP_SET_INDEX
+	OP_GET_FIELD
+	OP_SET_FIELD
+	OP_DESTRUCTURE
+)
+
+var opCodeNames = [...]string{
+	OP_CONSTANT:      "OP_CONSTANT",
+	OP_NIL:           "OP_NIL",
+	OP_TRUE:          "OP_TRUE",
+	OP_FALSE:         "OP_FALSE",
+	OP_POP:           "OP_POP",
+	OP_DEFINE_GLOBAL: "OP_DEFINE_GLOBAL",
+	OP_GET_GLOBAL:    "OP_GET_GLOBAL",
+	OP_SET_GLOBAL:    "OP_SET_GLOBAL",
+	OP_GET_LOCAL:     "OP_GET_LOCAL",
+	OP_SET_LOCAL:     "OP_SET_LOCAL",
+	OP_GET_UPVALUE:   "OP_GET_UPVALUE",
+	OP_SET_UPVALUE:   "OP_SET_UPVALUE",
+	OP_CLOSE_UPVALUE: "OP_CLOSE_UPVALUE",
+	OP_CHECK_NIL:     "OP_CHECK_NIL",
+	OP_EQUAL:         "OP_EQUAL",
+	OP_NOT_EQUAL:     "OP_NOT_EQUAL",
+	OP_GREATER:       "OP_GREATER",
+	OP_GREATER_EQUAL: "OP_GREATER_EQUAL",
+	OP_LESS:          "OP_LESS",
+	OP_LESS_EQUAL:    "OP_LESS_EQUAL",
+	OP_BIT_OR:        "OP_BIT_OR",
+	OP_BIT_XOR:       "OP_BIT_XOR",
+	OP_BIT_AND:       "OP_BIT_AND",
+	OP_ADD:           "OP_ADD",
+	OP_SUBTRACT:      "OP_SUBTRACT",
+	OP_MULTIPLY:      "OP_MULTIPLY",
+	OP_DIVIDE:        "OP_DIVIDE",
+	OP_MODULUS:       "OP_MODULUS",
+	OP_NOT:           "OP_NOT",
+	OP_NEGATE:        "OP_NEGATE",
+	OP_CAST:          "OP_CAST",
+	OP_JUMP:          "OP_JUMP",
+	OP_JUMP_IF_FALSE: "OP_JUMP_IF_FALSE",
+	OP_LOOP:          "OP_LOOP",
+	OP_CALL:          "OP_CALL",
+	OP_TAIL_CALL:     "OP_TAIL_CALL",
+	OP_CLOSURE:       "OP_CLOSURE",
+	OP_RETURN:        "OP_RETURN",
+	OP_PRINT:         "OP_PRINT",
+	OP_SLICE:         "OP_SLICE",
+	OP_MAP:           "OP_MAP",
+	OP_TUPLE:         "OP_TUPLE",
+	OP_STRUCT:        "OP_STRUCT",
+	OP_INDEX:         "OP_INDEX",
+	OP_CHECK_INDEX:   "OP_CHECK_INDEX",
+	OP_SET_INDEX:     "OP_SET_INDEX",
+	OP_GET_FIELD:     "OP_GET_FIELD",
+	OP_SET_FIELD:     "OP_SET_FIELD",
+	OP_DESTRUCTURE:   "OP_DESTRUCTURE",
+}
+
+func (op OpCode) String() string {
+	return opCodeNames[op]
+}
+
+// operandCount is the number of 16 bit operands following each instruction, OP_CLOSURE is followed by two
+// more operands for each upvalue of the function it creates
+var operandCount = [...]int{
+	OP_CONSTANT:      1,
+	OP_DEFINE_GLOBAL: 1,
+	OP_GET_GLOBAL:    1,
+	OP_SET_GLOBAL:    1,
+	OP_GET_LOCAL:     1,
+	OP_SET_LOCAL:     1,
+	OP_GET_UPVALUE:   1,
+	OP_SET_UPVALUE:   1,
+	OP_CHECK_NIL:     1,
+	OP_CAST:          1,
+	OP_JUMP:          1,
+	OP_JUMP_IF_FALSE: 1,
+	OP_LOOP:          1,
+	OP_CALL:          1,
+	OP_TAIL_CALL:     1,
+	OP_CLOSURE:       1,
+	OP_SLICE:         1,
+	OP_MAP:           1,
+	OP_TUPLE:         1,
+	OP_STRUCT:        1,
+	OP_GET_FIELD:     1,
+	OP_SET_FIELD:     1,
+	OP_DESTRUCTURE:   1,
+}
+
+// UpvalueDescriptor tells OP_CLOSURE where to find a variable captured by a function: either a local of the
+// enclosing function, or one of the enclosing function's own upvalues
+type UpvalueDescriptor struct {
+	isLocal bool
+	index   int
+}
+
+// Chunk is a function compiled to bytecode. Instructions are one byte long followed by 16 bit operands, and
+// `positions` holds the source position of every byte, for reporting runtime errors.
+type Chunk struct {
+	declaration *FunctionStatement // nil for top level code
+	arity       int
+	code        []byte
+	positions   []Token
+	constants   []interface{}
+	upvalues    []UpvalueDescriptor
+}
+
+func (c *Chunk) Write(b byte, position Token) {
+	c.code = append(c.code, b)
+	c.positions = append(c.positions, position)
+}
+
+func (c *Chunk) ReadOperand(offset int) int {
+	return int(c.code[offset])<<8 | int(c.code[offset+1])
+}
+
+func (c *Chunk) Name() string {
+	if c.declaration == nil {
+		return "<script>"
+	}
+	if c.declaration.name.tokenType == TOKEN_FN {
+		return "<anonymous fn>"
+	}
+	return c.declaration.name.String()
+}
+
+// Disassemble returns a human readable listing of the chunk and of the functions it creates
+func (c *Chunk) Disassemble() string {
+	builder := strings.Builder{}
+	c.disassemble(&builder)
+	return builder.String()
+}
+
+func (c *Chunk) disassemble(builder *strings.Builder) {
+	fmt.Fprintf(builder, "== %s ==\n", c.Name())
+
+	nested := []*Chunk{}
+
+	for offset := 0; offset < len(c.code); {
+		line := strings.Builder{}
+		op := OpCode(c.code[offset])
+		fmt.Fprintf(&line, "%04d %4d:%-3d %-18s", offset, c.positions[offset].line, c.positions[offset].col, op)
+		offset++
+
+		operands := operandCount[op]
+		for i := 0; i < operands; i++ {
+			fmt.Fprintf(&line, " %d", c.ReadOperand(offset))
+			offset += 2
+		}
+
+		switch op {
+		case OP_CONSTANT:
+			fmt.Fprintf(&line, " (%s)", FormatValue(c.constants[c.ReadOperand(offset-2)]))
+		case OP_CLOSURE:
+			function := c.constants[c.ReadOperand(offset-2)].(*Chunk)
+			nested = append(nested, function)
+			for range function.upvalues {
+				fmt.Fprintf(&line, " [%d %d]", c.ReadOperand(offset), c.ReadOperand(offset+2))
+				offset += 4
+			}
+		}
+
+		// instructions without operands are padded, trim the trailing spaces
+		builder.WriteString(strings.TrimRight(line.String(), " "))
+		builder.WriteRune('\n')
+	}
+
+	for _, function := range nested {
+		builder.WriteRune('\n')
+		function.disassemble(builder)
+	}
+}
+
+// Upvalue is a variable captured by a closure. While the variable is still on the stack the upvalue refers
+// to its stack slot, once the variable goes out of scope its value is moved into the upvalue.
+type Upvalue struct {
+	slot   int
+	open   bool
+	closed interface{}
+	next   *Upvalue // the next open upvalue, in decreasing order of stack slot
+}
+
+// FieldAccess is the operand of OP_GET_FIELD and OP_SET_FIELD. Which field or method `name` refers to is
+// looked up the first time the instruction runs and cached.
+type FieldAccess struct {
+	name   string
+	atype  *StructType
+	index  int    // the index of the field, or -1 for a method
+	method *Chunk // the compiled method
+}
+
+// StructLayout is the operand of OP_STRUCT, the values of a struct literal are assigned to the fields at
+// `indices`, the remaining fields are nil
+type StructLayout struct {
+	atype   *StructType
+	indices []int
+}
+
+// Closure is the runtime representation of a function compiled to bytecode
+type Closure struct {
+	chunk    *Chunk
+	upvalues []*Upvalue
+}
+
+func (c *Closure) String() string {
+	if c.chunk.declaration.name.tokenType == TOKEN_FN {
+		return "<fn>"
+	}
+	return fmt.Sprintf("<fn %v>", c.chunk.declaration.name)
+}
@@ -0,0 +1,709 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Local is a variable declared in a block or function, it lives on the stack
+type Local struct {
+	name     string
+	depth    int
+	captured bool
+}
+
+// LoopContext records the jumps out of a loop that are patched once the loop has been compiled
+type LoopContext struct {
+	statement  *WhileStatement
+	localCount int // the number of locals declared outside of the loop
+	breaks     []int
+	continues  []int
+}
+
+// CompiledProgram is the result of compiling a type checked program to bytecode
+type CompiledProgram struct {
+	script  *Chunk
+	globals []string // the names of the global variables, indexed by slot
+	methods map[*FunctionStatement]*Chunk
+
+	// the compiled methods in the order they were declared
+	methodOrder []*Chunk
+}
+
+// Disassemble returns a human readable listing of the program's bytecode
+func (p *CompiledProgram) Disassemble() string {
+	listing := p.script.Disassemble()
+	for _, method := range p.methodOrder {
+		listing += "\n" + method.Disassemble()
+	}
+	return listing
+}
+
+// Compiler compiles the body of one function, the compilers of the enclosing functions are chained through
+// `enclosing` to resolve captured variables
+type Compiler struct {
+	enclosing  *Compiler
+	program    *CompiledProgram
+	chunk      *Chunk
+	locals     []Local
+	scopeDepth int
+	loops      []*LoopContext
+
+	// the source position of the instructions being emitted
+	position Token
+
+	// maps the names of global variables to their slots, shared by all compilers of a program
+	globalSlots map[string]int
+}
+
+func (c *Compiler) CompileError(message string) {
+	panic(ErrorData{c.position.line, c.position.col, message})
+}
+
+func (c *Compiler) Emit(op OpCode, operands ...int) int {
+	offset := len(c.chunk.code)
+	c.chunk.Write(byte(op), c.position)
+	for _, operand := range operands {
+		c.EmitOperand(operand)
+	}
+	return offset
+}
+
+func (c *Compiler) EmitOperand(operand int) {
+	if operand < 0 || operand > 0xffff {
+		c.CompileError("function is too large to compile.")
+	}
+	c.chunk.Write(byte(operand>>8), c.position)
+	c.chunk.Write(byte(operand), c.position)
+}
+
+func (c *Compiler) MakeConstant(value interface{}) int {
+	c.chunk.constants = append(c.chunk.constants, value)
+	return len(c.chunk.constants) - 1
+}
+
+// EmitJump emits a forward jump and returns the offset of its operand, to be patched by PatchJump
+func (c *Compiler) EmitJump(op OpCode) int {
+	return c.Emit(op, 0) + 1
+}
+
+// PatchJump makes the jump whose operand is at `offset` land on the next instruction
+func (c *Compiler) PatchJump(offset int) {
+	jump := len(c.chunk.code) - offset - 2
+	if jump > 0xffff {
+		c.CompileError("too much code to jump over.")
+	}
+	c.chunk.code[offset] = byte(jump >> 8)
+	c.chunk.code[offset+1] = byte(jump)
+}
+
+func (c *Compiler) EmitLoop(start int) {
+	offset := c.Emit(OP_LOOP, 0) + 1
+	jump := offset + 2 - start
+	if jump > 0xffff {
+		c.CompileError("loop body is too large.")
+	}
+	c.chunk.code[offset] = byte(jump >> 8)
+	c.chunk.code[offset+1] = byte(jump)
+}
+
+func (c *Compiler) GlobalSlot(name string) int {
+	if slot, ok := c.globalSlots[name]; ok {
+		return slot
+	}
+	slot := len(c.program.globals)
+	c.globalSlots[name] = slot
+	c.program.globals = append(c.program.globals, name)
+	return slot
+}
+
+func (c *Compiler) ResolveLocal(name string) int {
+	for i := len(c.locals) - 1; i >= 0; i-- {
+		if c.locals[i].name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+func (c *Compiler) AddUpvalue(isLocal bool, index int) int {
+	for i, upvalue := range c.chunk.upvalues {
+		if upvalue.isLocal == isLocal && upvalue.index == index {
+			return i
+		}
+	}
+	c.chunk.upvalues = append(c.chunk.upvalues, UpvalueDescriptor{isLocal: isLocal, index: index})
+	return len(c.chunk.upvalues) - 1
+}
+
+// ResolveUpvalue returns the index of the upvalue through which the function captures `name`, or -1 if
+// `name` is not a local of any enclosing function
+func (c *Compiler) ResolveUpvalue(name string) int {
+	if c.enclosing == nil {
+		return -1
+	}
+
+	if local := c.enclosing.ResolveLocal(name); local != -1 {
+		c.enclosing.locals[local].captured = true
+		return c.AddUpvalue(true, local)
+	}
+
+	if upvalue := c.enclosing.ResolveUpvalue(name); upvalue != -1 {
+		return c.AddUpvalue(false, upvalue)
+	}
+
+	return -1
+}
+
+func (c *Compiler) GetVariable(name string) {
+	if local := c.ResolveLocal(name); local != -1 {
+		c.Emit(OP_GET_LOCAL, local)
+	} else if upvalue := c.ResolveUpvalue(name); upvalue != -1 {
+		c.Emit(OP_GET_UPVALUE, upvalue)
+	} else {
+		c.Emit(OP_GET_GLOBAL, c.GlobalSlot(name))
+	}
+}
+
+func (c *Compiler) SetVariable(name string) {
+	if local := c.ResolveLocal(name); local != -1 {
+		c.Emit(OP_SET_LOCAL, local)
+	} else if upvalue := c.ResolveUpvalue(name); upvalue != -1 {
+		c.Emit(OP_SET_UPVALUE, upvalue)
+	} else {
+		c.Emit(OP_SET_GLOBAL, c.GlobalSlot(name))
+	}
+}
+
+func (c *Compiler) AddLocal(name string) {
+	if len(c.locals) > 0xffff {
+		c.CompileError("too many local variables in function.")
+	}
+	c.locals = append(c.locals, Local{name: name, depth: c.scopeDepth})
+}
+
+// DefineVariable declares a variable whose value is on top of the stack
+func (c *Compiler) DefineVariable(name string) {
+	if c.scopeDepth == 0 {
+		c.Emit(OP_DEFINE_GLOBAL, c.GlobalSlot(name))
+	} else {
+		c.AddLocal(name)
+	}
+}
+
+func (c *Compiler) BeginScope() {
+	c.scopeDepth++
+}
+
+func (c *Compiler) EndScope() {
+	c.scopeDepth--
+
+	end := len(c.locals)
+	for end > 0 && c.locals[end-1].depth > c.scopeDepth {
+		end--
+	}
+
+	c.DiscardLocals(end)
+	c.locals = c.locals[:end]
+}
+
+// DiscardLocals emits the instructions that pop the locals declared after the first `count` off the stack
+func (c *Compiler) DiscardLocals(count int) {
+	for i := len(c.locals) - 1; i >= count; i-- {
+		if c.locals[i].captured {
+			c.Emit(OP_CLOSE_UPVALUE)
+		} else {
+			c.Emit(OP_POP)
+		}
+	}
+}
+
+// CompileFunction compiles the body of `declaration` and emits the instruction that creates a closure of it
+func (c *Compiler) CompileFunction(declaration *FunctionStatement) {
+	chunk := c.NewFunctionCompiler(declaration).CompileBody()
+
+	position := c.position
+	c.position = declaration.name
+	c.Emit(OP_CLOSURE, c.MakeConstant(chunk))
+	for _, upvalue := range chunk.upvalues {
+		isLocal := 0
+		if upvalue.isLocal {
+			isLocal = 1
+		}
+		c.EmitOperand(isLocal)
+		c.EmitOperand(upvalue.index)
+	}
+	c.position = position
+}
+
+func (c *Compiler) NewFunctionCompiler(declaration *FunctionStatement) *Compiler {
+	compiler := &Compiler{
+		enclosing:   c,
+		program:     c.program,
+		chunk:       &Chunk{declaration: declaration, arity: len(declaration.parameters)},
+		scopeDepth:  1,
+		position:    declaration.name,
+		globalSlots: c.globalSlots,
+	}
+
+	// slot 0 holds the function being called
+	compiler.AddLocal("")
+	for _, parameter := range declaration.parameters {
+		compiler.AddLocal(parameter.String())
+	}
+
+	return compiler
+}
+
+func (c *Compiler) CompileBody() *Chunk {
+	for _, stmt := range c.chunk.declaration.body.statements {
+		c.VisitStatementNode(stmt)
+	}
+
+	c.Emit(OP_NIL)
+	c.Emit(OP_RETURN)
+	return c.chunk
+}
+
+// CompileMethod compiles a method of a struct. Methods are compiled as if they were declared in a function
+// whose only local is `self`, so that binding a method to its receiver only has to supply that one upvalue.
+func (c *Compiler) CompileMethod(declaration *FunctionStatement) *Chunk {
+	receiver := &Compiler{
+		program:     c.program,
+		chunk:       &Chunk{},
+		scopeDepth:  1,
+		globalSlots: c.globalSlots,
+	}
+	receiver.AddLocal("")
+	receiver.AddLocal("self")
+
+	return receiver.NewFunctionCompiler(declaration).CompileBody()
+}
+
+// CompileToBytecode compiles a type checked program
+func CompileToBytecode(ast Program, errorReporter ErrorReporter) (program *CompiledProgram, err error) {
+	program = &CompiledProgram{script: &Chunk{}, methods: make(map[*FunctionStatement]*Chunk)}
+	compiler := &Compiler{program: program, chunk: program.script, globalSlots: make(map[string]int)}
+
+	defer func() {
+		if r := recover(); r != nil {
+			if v, ok := r.(ErrorData); ok {
+				errorReporter.Push(v.line, v.col, v.message)
+				program, err = nil, errorReporter
+				return
+			}
+			panic(r)
+		}
+	}()
+
+	// native and builtin functions are the first globals, sorted so that the slots are the same on every run
+	names := []string{}
+	for name := range NativeFunctions {
+		names = append(names, name)
+	}
+	for name := range BuiltinFunctions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		compiler.GlobalSlot(name)
+	}
+
+	// methods are compiled ahead of time, like structs are declared ahead of time by the type checker
+	for _, stmt := range ast {
+		if declaration, ok := stmt.(*StructStatement); ok {
+			for _, method := range declaration.methods {
+				chunk := compiler.CompileMethod(method)
+				program.methods[method] = chunk
+				program.methodOrder = append(program.methodOrder, chunk)
+			}
+		}
+	}
+
+	for _, stmt := range ast {
+		compiler.VisitStatementNode(stmt)
+	}
+
+	compiler.Emit(OP_NIL)
+	compiler.Emit(OP_RETURN)
+
+	return program, nil
+}
+
+func (c *Compiler) VisitExpressionNode(expr Expression) interface{} {
+	return expr.Accept(c)
+}
+
+func (c *Compiler) VisitStatementNode(stmt Statement) interface{} {
+	return stmt.Accept(c)
+}
+
+var binaryOpCodes = map[TokenType]OpCode{
+	TOKEN_EQUAL_EQUAL:   OP_EQUAL,
+	TOKEN_BANG_EQUAL:    OP_NOT_EQUAL,
+	TOKEN_GREATER:       OP_GREATER,
+	TOKEN_GREATER_EQUAL: OP_GREATER_EQUAL,
+	TOKEN_LESS:          OP_LESS,
+	TOKEN_LESS_EQUAL:    OP_LESS_EQUAL,
+	TOKEN_PIPE:          OP_BIT_OR,
+	TOKEN_CARET:         OP_BIT_XOR,
+	TOKEN_AMP:           OP_BIT_AND,
+	TOKEN_PLUS:          OP_ADD,
+	TOKEN_MINUS:         OP_SUBTRACT,
+	TOKEN_STAR:          OP_MULTIPLY,
+	TOKEN_SLASH:         OP_DIVIDE,
+	TOKEN_PERCENT:       OP_MODULUS,
+}
+
+func (c *Compiler) VisitBinary(expr *BinaryExpression) interface{} {
+	c.VisitExpressionNode(expr.left)
+
+	switch expr.operator.tokenType {
+	case TOKEN_AMP_AMP:
+		end := c.EmitJump(OP_JUMP_IF_FALSE)
+		c.Emit(OP_POP)
+		c.VisitExpressionNode(expr.right)
+		c.PatchJump(end)
+		return nil
+	case TOKEN_PIPE_PIPE:
+		right := c.EmitJump(OP_JUMP_IF_FALSE)
+		end := c.EmitJump(OP_JUMP)
+		c.PatchJump(right)
+		c.Emit(OP_POP)
+		c.VisitExpressionNode(expr.right)
+		c.PatchJump(end)
+		return nil
+	}
+
+	c.VisitExpressionNode(expr.right)
+
+	op, ok := binaryOpCodes[expr.operator.tokenType]
+	if !ok {
+		Unreachable("Compiler::VisitBinary")
+	}
+
+	c.position = expr.operator
+	c.Emit(op)
+	return nil
+}
+
+func (c *Compiler) VisitUnary(expr *UnaryExpression) interface{} {
+	c.VisitExpressionNode(expr.operand)
+
+	c.position = expr.operator
+	switch expr.operator.tokenType {
+	case TOKEN_BANG:
+		c.Emit(OP_NOT)
+	case TOKEN_MINUS:
+		c.Emit(OP_NEGATE)
+	default:
+		Unreachable("Compiler::VisitUnary")
+	}
+	return nil
+}
+
+func (c *Compiler) VisitLiteral(expr *LiteralExpression) interface{} {
+	switch expr.value.tokenType {
+	case TOKEN_FALSE:
+		c.Emit(OP_FALSE)
+	case TOKEN_TRUE:
+		c.Emit(OP_TRUE)
+	case TOKEN_NIL:
+		c.Emit(OP_NIL)
+	case TOKEN_INT_LITERAL, TOKEN_FLOAT_LITERAL, TOKEN_STRING_LITERAL:
+		// a string constant is counted against the allocation limit the first time it is used
+		c.position = expr.value
+		c.Emit(OP_CONSTANT, c.MakeConstant(expr.value.value))
+	default:
+		Unreachable("Compiler::VisitLiteral")
+	}
+	return nil
+}
+
+func (c *Compiler) VisitGrouping(expr *GroupingExpression) interface{} {
+	return c.VisitExpressionNode(expr.expr)
+}
+
+func (c *Compiler) VisitIdentifier(expr *IdentifierExpression) interface{} {
+	c.position = expr.name
+	name := expr.name.String()
+	c.GetVariable(name)
+
+	if expr.narrowed {
+		c.Emit(OP_CHECK_NIL, c.MakeConstant(name))
+	}
+	return nil
+}
+
+func (c *Compiler) VisitAssignment(expr *AssignmentExpression) interface{} {
+	c.VisitExpressionNode(expr.value)
+	c.position = expr.name
+	c.SetVariable(expr.name.String())
+	return nil
+}
+
+func (c *Compiler) VisitCall(expr *CallExpression) interface{} {
+	c.VisitExpressionNode(expr.callee)
+	for _, argument := range expr.arguments {
+		c.VisitExpressionNode(argument)
+	}
+
+	c.position = expr.loc
+	if expr.tail {
+		// a tail call to a native function returns like a call, it is followed by OP_RETURN
+		c.Emit(OP_TAIL_CALL, len(expr.arguments))
+	} else {
+		c.Emit(OP_CALL, len(expr.arguments))
+	}
+	return nil
+}
+
+func (c *Compiler) VisitTypeCast(expr *TypeCastExpression) interface{} {
+	c.VisitExpressionNode(expr.value)
+	c.position = expr.loc
+	c.Emit(OP_CAST, c.MakeConstant(GetHandler(expr.from, expr.to)))
+	return nil
+}
+
+func (c *Compiler) VisitSubscript(expr *SubscriptExpression) interface{} {
+	c.VisitExpressionNode(expr.object)
+	c.VisitExpressionNode(expr.index)
+	c.position = expr.loc
+	c.Emit(OP_INDEX)
+	return nil
+}
+
+func (c *Compiler) VisitSubscriptAssignment(expr *SubscriptAssignmentExpression) interface{} {
+	c.VisitExpressionNode(expr.target.object)
+	c.VisitExpressionNode(expr.target.index)
+
+	// the index of a slice is checked before the value is evaluated
+	c.position = expr.target.loc
+	c.Emit(OP_CHECK_INDEX)
+
+	c.VisitExpressionNode(expr.value)
+	c.position = expr.target.loc
+	c.Emit(OP_SET_INDEX)
+	return nil
+}
+
+func (c *Compiler) VisitSliceLiteral(expr *SliceLiteralExpression) interface{} {
+	for _, element := range expr.elements {
+		c.VisitExpressionNode(element)
+	}
+	c.position = expr.loc
+	c.Emit(OP_SLICE, len(expr.elements))
+	return nil
+}
+
+func (c *Compiler) VisitMapLiteral(expr *MapLiteralExpression) interface{} {
+	for i := range expr.keys {
+		c.VisitExpressionNode(expr.keys[i])
+		c.VisitExpressionNode(expr.values[i])
+	}
+	c.position = expr.loc
+	c.Emit(OP_MAP, len(expr.keys))
+	return nil
+}
+
+func (c *Compiler) VisitTuple(expr *TupleExpression) interface{} {
+	for _, element := range expr.elements {
+		c.VisitExpressionNode(element)
+	}
+	c.position = expr.loc
+	c.Emit(OP_TUPLE, len(expr.elements))
+	return nil
+}
+
+func (c *Compiler) VisitField(expr *FieldExpression) interface{} {
+	c.VisitExpressionNode(expr.object)
+	c.position = expr.name
+	c.Emit(OP_GET_FIELD, c.MakeConstant(&FieldAccess{name: expr.name.String()}))
+	return nil
+}
+
+func (c *Compiler) VisitFieldAssignment(expr *FieldAssignmentExpression) interface{} {
+	c.VisitExpressionNode(expr.target.object)
+	c.VisitExpressionNode(expr.value)
+	c.position = expr.target.name
+	c.Emit(OP_SET_FIELD, c.MakeConstant(&FieldAccess{name: expr.target.name.String()}))
+	return nil
+}
+
+func (c *Compiler) VisitStructLiteral(expr *StructLiteralExpression) interface{} {
+	atype := expr.atype.other.(*StructType)
+	layout := &StructLayout{atype: atype, indices: make([]int, len(expr.fields))}
+
+	for i := range expr.fields {
+		layout.indices[i] = atype.FieldIndex(expr.fields[i].String())
+		c.VisitExpressionNode(expr.values[i])
+	}
+
+	c.position = expr.loc
+	c.Emit(OP_STRUCT, c.MakeConstant(layout))
+	return nil
+}
+
+func (c *Compiler) VisitFunctionLiteral(expr *FunctionLiteralExpression) interface{} {
+	c.CompileFunction(expr.function)
+	return nil
+}
+
+func (c *Compiler) VisitExpression(stmt *ExpressionStatement) interface{} {
+	c.VisitExpressionNode(stmt.expr)
+	c.Emit(OP_POP)
+	return nil
+}
+
+func (c *Compiler) VisitPrint(stmt *PrintStatement) interface{} {
+	c.VisitExpressionNode(stmt.expr)
+	c.position = stmt.loc
+	c.Emit(OP_PRINT)
+	return nil
+}
+
+func (c *Compiler) VisitLet(stmt *LetStatement) interface{} {
+	c.VisitExpressionNode(stmt.initializer)
+	c.position = stmt.name
+
+	if stmt.names == nil {
+		c.DefineVariable(stmt.name.String())
+		return nil
+	}
+
+	c.Emit(OP_DESTRUCTURE, len(stmt.names))
+	if c.scopeDepth == 0 {
+		// globals are popped off the stack, starting from the last element
+		for i := len(stmt.names) - 1; i >= 0; i-- {
+			c.DefineVariable(stmt.names[i].String())
+		}
+	} else {
+		for _, name := range stmt.names {
+			c.DefineVariable(name.String())
+		}
+	}
+	return nil
+}
+
+func (c *Compiler) VisitBlock(stmt *BlockStatement) interface{} {
+	c.BeginScope()
+	for _, stmt := range stmt.statements {
+		c.VisitStatementNode(stmt)
+	}
+	c.EndScope()
+	return nil
+}
+
+func (c *Compiler) VisitIf(stmt *IfStatement) interface{} {
+	c.VisitExpressionNode(stmt.condition)
+	c.position = stmt.loc
+
+	elseBranch := c.EmitJump(OP_JUMP_IF_FALSE)
+	c.Emit(OP_POP)
+	c.VisitStatementNode(stmt.thenBranch)
+	end := c.EmitJump(OP_JUMP)
+
+	c.PatchJump(elseBranch)
+	c.Emit(OP_POP)
+	if stmt.elseBranch != nil {
+		c.VisitStatementNode(stmt.elseBranch)
+	}
+	c.PatchJump(end)
+	return nil
+}
+
+func (c *Compiler) VisitWhile(stmt *WhileStatement) interface{} {
+	loop := &LoopContext{statement: stmt, localCount: len(c.locals)}
+	c.loops = append(c.loops, loop)
+
+	start := len(c.chunk.code)
+	c.VisitExpressionNode(stmt.condition)
+	c.position = stmt.loc
+	exit := c.EmitJump(OP_JUMP_IF_FALSE)
+	c.Emit(OP_POP)
+
+	c.VisitStatementNode(stmt.body)
+
+	for _, jump := range loop.continues {
+		c.PatchJump(jump)
+	}
+	if stmt.increment != nil {
+		c.VisitExpressionNode(stmt.increment)
+		c.Emit(OP_POP)
+	}
+	c.position = stmt.loc
+	c.EmitLoop(start)
+
+	c.PatchJump(exit)
+	c.Emit(OP_POP)
+
+	for _, jump := range loop.breaks {
+		c.PatchJump(jump)
+	}
+
+	c.loops = c.loops[:len(c.loops)-1]
+	return nil
+}
+
+func (c *Compiler) FindLoop(target *WhileStatement) *LoopContext {
+	for i := len(c.loops) - 1; i >= 0; i-- {
+		if c.loops[i].statement == target {
+			return c.loops[i]
+		}
+	}
+
+	Unreachable("Compiler::FindLoop")
+	return nil
+}
+
+func (c *Compiler) VisitBreak(stmt *BreakStatement) interface{} {
+	loop := c.FindLoop(stmt.target)
+	c.position = stmt.loc
+	c.DiscardLocals(loop.localCount)
+	loop.breaks = append(loop.breaks, c.EmitJump(OP_JUMP))
+	return nil
+}
+
+func (c *Compiler) VisitContinue(stmt *ContinueStatement) interface{} {
+	loop := c.FindLoop(stmt.target)
+	c.position = stmt.loc
+	c.DiscardLocals(loop.localCount)
+	loop.continues = append(loop.continues, c.EmitJump(OP_JUMP))
+	return nil
+}
+
+func (c *Compiler) VisitFunction(stmt *FunctionStatement) interface{} {
+	name := stmt.name.String()
+	c.position = stmt.name
+
+	if c.scopeDepth == 0 {
+		c.CompileFunction(stmt)
+		c.DefineVariable(name)
+		return nil
+	}
+
+	// declare the local first so that the function can refer to itself
+	c.AddLocal(name)
+	c.CompileFunction(stmt)
+	return nil
+}
+
+func (c *Compiler) VisitReturn(stmt *ReturnStatement) interface{} {
+	if stmt.value == nil {
+		c.Emit(OP_NIL)
+	} else {
+		c.VisitExpressionNode(stmt.value)
+	}
+	c.position = stmt.loc
+	c.Emit(OP_RETURN)
+	return nil
+}
+
+func (c *Compiler) VisitStruct(stmt *StructStatement) interface{} {
+	// structs are declared ahead of time by the type checker, and their methods are compiled ahead of time
+	return nil
+}
+
+func (c *Compiler) String() string {
+	return fmt.Sprintf("<compiler %s>", c.chunk.Name())
+}
@@ -0,0 +1,600 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// BranchCoverage counts how many times the condition of an if or while statement was true and how many times it
+// was false
+type BranchCoverage struct {
+	position Token
+	block    int // the index of the statement among the if and while statements on its line
+	taken    [2]int64
+}
+
+// FunctionCoverage is the coverage of the statements of a function, leaving out the functions declared in it
+type FunctionCoverage struct {
+	name     string
+	line     int // the line the function is declared on, 0 for the top level code
+	calls    int64
+	lines    map[int]int64 // the number of times the statements of the function on each line were executed
+	branches []*BranchCoverage
+}
+
+// Coverage is an observer that records how many times each line was executed, which way the conditions of if and
+// while statements went and how many times each function was called
+type Coverage struct {
+	source []rune
+	file   string
+
+	top       *FunctionCoverage
+	functions map[*FunctionStatement]*FunctionCoverage
+	branches  map[Statement]*BranchCoverage
+
+	// the function each statement belongs to, a line may hold the statements of several functions
+	owners map[Statement]*FunctionCoverage
+
+	// the number of times each line a statement starts on was executed
+	lines map[int]int64
+
+	// whether the program ran, a program that does not compile has no coverage
+	attached bool
+}
+
+// NewCoverage returns a coverage observer for the program `source` read from `file`
+func NewCoverage(source []rune, file string) *Coverage {
+	return &Coverage{
+		source:    source,
+		file:      file,
+		top:       &FunctionCoverage{name: "<top level>", calls: 1, lines: make(map[int]int64)},
+		functions: make(map[*FunctionStatement]*FunctionCoverage),
+		owners:    make(map[Statement]*FunctionCoverage),
+		branches:  make(map[Statement]*BranchCoverage),
+		lines:     make(map[int]int64),
+	}
+}
+
+// Function returns the coverage of the function whose layout is `layout`
+func (c *Coverage) Function(layout *FunctionLayout) *FunctionCoverage {
+	if layout == nil || layout.declaration == nil {
+		return c.top
+	}
+
+	function, ok := c.functions[layout.declaration]
+	if !ok {
+		function = &FunctionCoverage{
+			name:  FunctionName(layout.declaration),
+			line:  layout.declaration.name.line,
+			lines: make(map[int]int64),
+		}
+		c.functions[layout.declaration] = function
+	}
+	return function
+}
+
+// Attach finds the lines and the branches of the program, including those that never run
+func (c *Coverage) Attach(interpreter *Interpreter, layout *ProgramLayout) {
+	c.attached = true
+	branches := []*BranchCoverage{}
+
+	for stmt, position := range layout.positions {
+		if declaration, ok := stmt.(*FunctionStatement); ok {
+			c.Function(declaration.layout)
+		}
+
+		line := StatementPosition(stmt).line
+		if line == 0 {
+			continue
+		}
+
+		function := c.Function(position.scope.function)
+		function.lines[line] += 0
+		c.owners[stmt] = function
+		c.lines[line] += 0
+
+		switch stmt.(type) {
+		case *IfStatement, *WhileStatement:
+			branch := &BranchCoverage{position: StatementPosition(stmt)}
+			c.branches[stmt] = branch
+			function.branches = append(function.branches, branch)
+			branches = append(branches, branch)
+		}
+	}
+
+	// the branches of a line are numbered from left to right
+	sort.Slice(branches, func(i, j int) bool { return TokenBefore(branches[i].position, branches[j].position) })
+	for i, branch := range branches {
+		if i != 0 && branches[i-1].position.line == branch.position.line {
+			branch.block = branches[i-1].block + 1
+		}
+	}
+
+	for _, function := range c.Functions() {
+		sort.Slice(function.branches, func(i, j int) bool {
+			return TokenBefore(function.branches[i].position, function.branches[j].position)
+		})
+	}
+}
+
+func TokenBefore(a Token, b Token) bool {
+	if a.line != b.line {
+		return a.line < b.line
+	}
+	return a.col < b.col
+}
+
+func (c *Coverage) Statement(stmt Statement) {
+	if line := StatementPosition(stmt).line; line != 0 {
+		c.lines[line]++
+		if owner, ok := c.owners[stmt]; ok {
+			owner.lines[line]++
+		}
+	}
+}
+
+func (c *Coverage) Call(function AspenFunction, arguments []interface{}, callSite Token) {
+	if f, ok := function.(*UserFunction); ok {
+		c.Function(f.declaration.layout).calls++
+	}
+}
+
+func (c *Coverage) TailCall(function AspenFunction, arguments []interface{}, callSite Token) {
+	c.Call(function, arguments, callSite)
+}
+
+func (c *Coverage) Return(value interface{}) {}
+
+func (c *Coverage) Assign(name Token, old interface{}, value interface{}) {}
+
+func (c *Coverage) Branch(stmt Statement, taken bool) {
+	if branch, ok := c.branches[stmt]; ok && taken {
+		branch.taken[0]++
+	} else if ok {
+		branch.taken[1]++
+	}
+}
+
+// Functions returns the functions of the program in the order of their declarations, after the top level code
+func (c *Coverage) Functions() []*FunctionCoverage {
+	functions := []*FunctionCoverage{c.top}
+	for _, function := range c.functions {
+		functions = append(functions, function)
+	}
+	sort.SliceStable(functions[1:], func(i, j int) bool {
+		if functions[i+1].line != functions[j+1].line {
+			return functions[i+1].line < functions[j+1].line
+		}
+		return functions[i+1].name < functions[j+1].name
+	})
+	return functions
+}
+
+// Covered returns the number of lines of a function that were executed and the number of ways its conditions went
+func (c *Coverage) Covered(function *FunctionCoverage) (lines int, branches int) {
+	for _, hits := range function.lines {
+		if hits != 0 {
+			lines++
+		}
+	}
+	for _, branch := range function.branches {
+		for _, taken := range branch.taken {
+			if taken != 0 {
+				branches++
+			}
+		}
+	}
+	return lines, branches
+}
+
+// Lines returns how many times each line a statement starts on was executed. A line that holds the statements of
+// several functions, such as a function literal written on one line, counts as never executed unless the
+// statements of each of them were.
+func (c *Coverage) Lines() map[int]int64 {
+	lines := make(map[int]int64, len(c.lines))
+	for line, hits := range c.lines {
+		lines[line] = hits
+	}
+	for _, function := range c.Functions() {
+		for line, hits := range function.lines {
+			if hits == 0 {
+				lines[line] = 0
+			}
+		}
+	}
+	return lines
+}
+
+// CoveragePercentage formats `covered` as a percentage of `total`
+func CoveragePercentage(covered int, total int) string {
+	if total == 0 {
+		return "-"
+	}
+	return fmt.Sprintf("%.2f%%", float64(covered)*100/float64(total))
+}
+
+// Report returns the coverage of each function, followed by the lines that never ran and the conditions that
+// never went one of their ways
+func (c *Coverage) Report() string {
+	builder := strings.Builder{}
+	source := strings.Split(string(c.source), "\n")
+	sourceLine := func(line int) string {
+		return strings.TrimSpace(source[line-1])
+	}
+
+	lines := c.Lines()
+	coveredLines := 0
+	for _, hits := range lines {
+		if hits != 0 {
+			coveredLines++
+		}
+	}
+	coveredBranches := 0
+	for _, branch := range c.branches {
+		for _, taken := range branch.taken {
+			if taken != 0 {
+				coveredBranches++
+			}
+		}
+	}
+
+	fmt.Fprintf(&builder, "coverage: %d/%d lines (%s), %d/%d branches (%s)\n\n",
+		coveredLines, len(lines), CoveragePercentage(coveredLines, len(lines)),
+		coveredBranches, 2*len(c.branches), CoveragePercentage(coveredBranches, 2*len(c.branches)))
+
+	fmt.Fprintf(&builder, "%11s %7s %11s %9s %10s  %s\n", "lines", "lines%", "branches", "branches%", "calls", "function")
+	for _, function := range c.Functions() {
+		name := function.name
+		if function.line != 0 {
+			name = fmt.Sprintf("%s (line %d)", name, function.line)
+		}
+
+		lines, branches := c.Covered(function)
+		fmt.Fprintf(&builder, "%11s %7s %11s %9s %10d  %s\n",
+			fmt.Sprintf("%d/%d", lines, len(function.lines)),
+			CoveragePercentage(lines, len(function.lines)),
+			fmt.Sprintf("%d/%d", branches, 2*len(function.branches)),
+			CoveragePercentage(branches, 2*len(function.branches)),
+			function.calls,
+			name)
+	}
+
+	missed := []int{}
+	for line, hits := range lines {
+		if hits == 0 {
+			missed = append(missed, line)
+		}
+	}
+	sort.Ints(missed)
+
+	if len(missed) != 0 {
+		builder.WriteString("\nlines never executed\n")
+		for _, line := range missed {
+			fmt.Fprintf(&builder, "%6d | %s\n", line, sourceLine(line))
+		}
+	}
+
+	branches := []*BranchCoverage{}
+	for _, branch := range c.branches {
+		if branch.taken[0] == 0 || branch.taken[1] == 0 {
+			branches = append(branches, branch)
+		}
+	}
+	sort.Slice(branches, func(i, j int) bool { return TokenBefore(branches[i].position, branches[j].position) })
+
+	if len(branches) != 0 {
+		builder.WriteString("\nconditions that always went the same way\n")
+		for _, branch := range branches {
+			never := "never true"
+			switch {
+			case branch.taken[0] == 0 && branch.taken[1] == 0:
+				never = "never evaluated"
+			case branch.taken[1] == 0:
+				never = "never false"
+			}
+			fmt.Fprintf(&builder, "%6d | %s  (%s)\n", branch.position.line, sourceLine(branch.position.line), never)
+		}
+	}
+
+	return builder.String()
+}
+
+// LcovFunction is the number of calls to a function in an LCOV record
+type LcovFunction struct {
+	name  string
+	line  int
+	calls int64
+}
+
+// LcovBranch is the number of times a branch was taken in an LCOV record, the branches of a condition are
+// numbered 0 for true and 1 for false. `taken` is -1 if the condition was never evaluated.
+type LcovBranch struct {
+	line   int
+	block  int
+	branch int
+	taken  int64
+}
+
+// LcovRecord is the coverage of a source file in the LCOV format, which coverage viewers such as genhtml read
+type LcovRecord struct {
+	file      string
+	functions []LcovFunction
+	branches  []LcovBranch
+	lines     map[int]int64
+}
+
+// Lcov returns the coverage of the program as an LCOV record, functions that share their name are told apart by
+// the line they are declared on
+func (c *Coverage) Lcov() *LcovRecord {
+	record := &LcovRecord{file: c.file, lines: make(map[int]int64)}
+
+	functions := c.Functions()[1:]
+	names := make(map[string]int)
+	for _, function := range functions {
+		names[function.name]++
+	}
+
+	for _, function := range functions {
+		name := function.name
+		if names[name] > 1 {
+			name = fmt.Sprintf("%s:%d", name, function.line)
+		}
+		record.functions = append(record.functions, LcovFunction{name: name, line: function.line, calls: function.calls})
+	}
+
+	record.lines = c.Lines()
+
+	for _, branch := range c.branches {
+		for i, taken := range branch.taken {
+			if branch.taken[0] == 0 && branch.taken[1] == 0 {
+				taken = -1
+			}
+			record.branches = append(record.branches, LcovBranch{
+				line:   branch.position.line,
+				block:  branch.block,
+				branch: i,
+				taken:  taken,
+			})
+		}
+	}
+
+	record.Sort()
+	return record
+}
+
+func (r *LcovRecord) Sort() {
+	sort.Slice(r.functions, func(i, j int) bool {
+		if r.functions[i].line != r.functions[j].line {
+			return r.functions[i].line < r.functions[j].line
+		}
+		return r.functions[i].name < r.functions[j].name
+	})
+
+	sort.Slice(r.branches, func(i, j int) bool {
+		a, b := r.branches[i], r.branches[j]
+		if a.line != b.line {
+			return a.line < b.line
+		}
+		if a.block != b.block {
+			return a.block < b.block
+		}
+		return a.branch < b.branch
+	})
+}
+
+// Merge adds the counts of `other`, a record of the same file, to the record
+func (r *LcovRecord) Merge(other *LcovRecord) {
+	for _, function := range other.functions {
+		merged := false
+		for i := range r.functions {
+			if r.functions[i].name == function.name {
+				r.functions[i].calls += function.calls
+				if r.functions[i].line == 0 {
+					r.functions[i].line = function.line
+				}
+				merged = true
+			}
+		}
+		if !merged {
+			r.functions = append(r.functions, function)
+		}
+	}
+
+	for line, hits := range other.lines {
+		r.lines[line] += hits
+	}
+
+	for _, branch := range other.branches {
+		merged := false
+		for i := range r.branches {
+			b := &r.branches[i]
+			if b.line != branch.line || b.block != branch.block || b.branch != branch.branch {
+				continue
+			}
+
+			switch {
+			case b.taken == -1:
+				b.taken = branch.taken
+			case branch.taken != -1:
+				b.taken += branch.taken
+			}
+			merged = true
+		}
+		if !merged {
+			r.branches = append(r.branches, branch)
+		}
+	}
+
+	r.Sort()
+}
+
+// MergeLcov merges `record` into the records of the same file in `records`, or adds it
+func MergeLcov(records []*LcovRecord, record *LcovRecord) []*LcovRecord {
+	for _, existing := range records {
+		if existing.file == record.file {
+			existing.Merge(record)
+			return records
+		}
+	}
+	return append(records, record)
+}
+
+// ReadLcov reads the records of an LCOV file, the summary lines are left out as WriteLcov counts them again
+func ReadLcov(reader io.Reader) ([]*LcovRecord, error) {
+	records := []*LcovRecord{}
+	var record *LcovRecord
+
+	scanner := bufio.NewScanner(reader)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		invalid := fmt.Errorf("invalid LCOV line %d '%s'.", n, line)
+
+		kind, value := line, ""
+		if i := strings.IndexByte(line, ':'); i >= 0 {
+			kind, value = line[:i], line[i+1:]
+		}
+
+		if kind == "SF" {
+			// the counts of a file recorded twice are added up
+			record = &LcovRecord{file: value, lines: make(map[int]int64)}
+			records = MergeLcov(records, record)
+			for _, existing := range records {
+				if existing.file == value {
+					record = existing
+				}
+			}
+			continue
+		}
+		if kind == "" || kind == "TN" || kind == "FNF" || kind == "FNH" || kind == "LF" || kind == "LH" ||
+			kind == "BRF" || kind == "BRH" {
+			continue
+		}
+		if record == nil {
+			return nil, invalid
+		}
+
+		fields := strings.Split(value, ",")
+		numbers := []int64{}
+		for _, field := range fields {
+			number, err := strconv.ParseInt(field, 10, 64)
+			if err != nil && field == "-" {
+				number = -1
+			} else if err != nil {
+				break
+			}
+			numbers = append(numbers, number)
+		}
+
+		switch {
+		case kind == "end_of_record":
+			record = nil
+		case kind == "FN" && len(fields) == 2 && len(numbers) >= 1:
+			record.Merge(&LcovRecord{functions: []LcovFunction{{name: fields[1], line: int(numbers[0])}}})
+		case kind == "FNDA" && len(fields) == 2 && len(numbers) >= 1:
+			record.Merge(&LcovRecord{functions: []LcovFunction{{name: fields[1], calls: numbers[0]}}})
+		case kind == "DA" && len(numbers) >= 2:
+			record.lines[int(numbers[0])] += numbers[1]
+		case kind == "BRDA" && len(numbers) == 4:
+			record.Merge(&LcovRecord{branches: []LcovBranch{{
+				line:   int(numbers[0]),
+				block:  int(numbers[1]),
+				branch: int(numbers[2]),
+				taken:  numbers[3],
+			}}})
+		default:
+			return nil, invalid
+		}
+	}
+
+	if record != nil {
+		return nil, errors.New("the last LCOV record has no end_of_record line.")
+	}
+	return records, scanner.Err()
+}
+
+// WriteLcov writes records in the LCOV format
+func WriteLcov(writer io.Writer, records []*LcovRecord) error {
+	sort.Slice(records, func(i, j int) bool { return records[i].file < records[j].file })
+
+	w := bufio.NewWriter(writer)
+	for _, record := range records {
+		fmt.Fprintln(w, "TN:")
+		fmt.Fprintf(w, "SF:%s\n", record.file)
+
+		called := 0
+		for _, function := range record.functions {
+			fmt.Fprintf(w, "FN:%d,%s\n", function.line, function.name)
+		}
+		for _, function := range record.functions {
+			fmt.Fprintf(w, "FNDA:%d,%s\n", function.calls, function.name)
+			if function.calls != 0 {
+				called++
+			}
+		}
+		fmt.Fprintf(w, "FNF:%d\nFNH:%d\n", len(record.functions), called)
+
+		taken := 0
+		for _, branch := range record.branches {
+			if branch.taken == -1 {
+				fmt.Fprintf(w, "BRDA:%d,%d,%d,-\n", branch.line, branch.block, branch.branch)
+				continue
+			}
+			fmt.Fprintf(w, "BRDA:%d,%d,%d,%d\n", branch.line, branch.block, branch.branch, branch.taken)
+			if branch.taken != 0 {
+				taken++
+			}
+		}
+		fmt.Fprintf(w, "BRF:%d\nBRH:%d\n", len(record.branches), taken)
+
+		lines := []int{}
+		for line := range record.lines {
+			lines = append(lines, line)
+		}
+		sort.Ints(lines)
+
+		hit := 0
+		for _, line := range lines {
+			fmt.Fprintf(w, "DA:%d,%d\n", line, record.lines[line])
+			if record.lines[line] != 0 {
+				hit++
+			}
+		}
+		fmt.Fprintf(w, "LF:%d\nLH:%d\n", len(lines), hit)
+		fmt.Fprintln(w, "end_of_record")
+	}
+
+	return w.Flush()
+}
+
+// MergeLcovFile adds a record to the LCOV file at `path`, adding its counts to those recorded by earlier runs of
+// the same file. The file is created if it does not exist.
+func MergeLcovFile(path string, record *LcovRecord) error {
+	records := []*LcovRecord{}
+
+	if input, err := os.Open(path); err == nil {
+		records, err = ReadLcov(input)
+		input.Close()
+		if err != nil {
+			return fmt.Errorf("error: cannot read the coverage in %s, %v", path, err)
+		}
+	} else if !os.IsNotExist(err) {
+		return fmt.Errorf("error: cannot open file %s", path)
+	}
+
+	output, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("error: cannot create file %s", path)
+	}
+	defer output.Close()
+
+	if err := WriteLcov(output, MergeLcov(records, record)); err != nil {
+		return fmt.Errorf("error: cannot write coverage to %s", path)
+	}
+	return nil
+}
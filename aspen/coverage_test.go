@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestCoverage(t *testing.T) {
+	Initialize()
+
+	source := `fn sign(n i64) i64 {
+    if (n < 0) {
+        return -1;
+    }
+    if (n == 0) {
+        return 0;
+    }
+    return 1;
+}
+
+let i = 1;
+while (i < 3) {
+    sign(i);
+    i = i + 1;
+}
+let twice = fn(x i64) i64 { return x * 2; };
+struct Box { n i64; fn get() i64 { return 1; } }
+`
+
+	coverage := NewCoverage([]rune(source), "sign.aspen")
+	ObserveSource(t, source, coverage)
+
+	report := coverage.Report()
+	for _, expected := range []string{
+		"coverage: 8/12 lines (66.67%), 4/6 branches (66.67%)",
+		"        7/7 100.00%         2/2   100.00%          1  <top level>",
+		"        3/5  60.00%         2/4    50.00%          2  sign (line 1)",
+		"        0/1   0.00%         0/0         -          0  <anonymous fn> (line 16)",
+		"     3 | return -1;",
+		"    16 | let twice = fn(x i64) i64 { return x * 2; };",
+		"    17 | struct Box { n i64; fn get() i64 { return 1; } }",
+		"        0/1   0.00%         0/0         -          0  get (line 17)",
+		"     2 | if (n < 0) {  (never true)",
+	} {
+		if !strings.Contains(report, expected) {
+			t.Errorf("expected the report to contain %q, got\n%s", expected, report)
+		}
+	}
+
+	// a second run of the program doubles the counts
+	buffer := bytes.Buffer{}
+	if err := WriteLcov(&buffer, []*LcovRecord{coverage.Lcov()}); err != nil {
+		t.Fatal(err)
+	}
+	records, err := ReadLcov(&buffer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	records = MergeLcov(records, coverage.Lcov())
+
+	buffer.Reset()
+	if err := WriteLcov(&buffer, records); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `TN:
+SF:sign.aspen
+FN:1,sign
+FN:16,<anonymous fn>
+FN:17,get
+FNDA:4,sign
+FNDA:0,<anonymous fn>
+FNDA:0,get
+FNF:3
+FNH:1
+BRDA:2,0,0,0
+BRDA:2,0,1,4
+BRDA:5,0,0,0
+BRDA:5,0,1,4
+BRDA:12,0,0,4
+BRDA:12,0,1,2
+BRF:6
+BRH:4
+DA:1,2
+DA:2,4
+DA:3,0
+DA:5,4
+DA:6,0
+DA:8,4
+DA:11,2
+DA:12,2
+DA:13,4
+DA:14,4
+DA:16,0
+DA:17,0
+LF:12
+LH:8
+end_of_record
+`
+	if buffer.String() != expected {
+		t.Errorf("expected the merged LCOV file\n%s\ngot\n%s", expected, buffer.String())
+	}
+}
+
+func TestReadLcov(t *testing.T) {
+	records, err := ReadLcov(strings.NewReader(`TN:
+SF:a.aspen
+FN:3,f
+FNDA:1,f
+BRDA:4,0,0,-
+BRDA:4,0,1,-
+DA:3,1
+end_of_record
+SF:a.aspen
+FNDA:2,f
+BRDA:4,0,0,5
+DA:3,1,checksum
+DA:7,0
+end_of_record
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(records) != 1 {
+		t.Fatalf("expected the records of a.aspen to be merged, got %d records", len(records))
+	}
+
+	record := records[0]
+	if record.functions[0] != (LcovFunction{name: "f", line: 3, calls: 3}) {
+		t.Errorf("expected f to be called 3 times, got %+v", record.functions[0])
+	}
+	if record.branches[0].taken != 5 || record.branches[1].taken != -1 {
+		t.Errorf("expected the branches to be taken 5 times and never, got %+v", record.branches)
+	}
+	if record.lines[3] != 2 || record.lines[7] != 0 {
+		t.Errorf("expected line 3 to run twice and line 7 never, got %v", record.lines)
+	}
+
+	if _, err := ReadLcov(strings.NewReader("DA:1,1\n")); err == nil {
+		t.Error("expected an error for a line outside of a record")
+	}
+}
@@ -0,0 +1,695 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DAP_THREAD is the id of the only thread of a program, the editor asks for the threads it can pause
+const DAP_THREAD = 1
+
+// DapRequest is a request of the Debug Adapter Protocol, see
+// https://microsoft.github.io/debug-adapter-protocol/specification
+type DapRequest struct {
+	Seq       int             `json:"seq"`
+	Type      string          `json:"type"`
+	Command   string          `json:"command"`
+	Arguments json.RawMessage `json:"arguments"`
+}
+
+// DapReference is what a variablesReference given to the editor refers to, either the variables of a call or the
+// elements of a value
+type DapReference struct {
+	frame   *DebugFrame // nil for the elements of a value
+	globals bool
+
+	value interface{}
+	atype *Type
+}
+
+// DapServer is the frontend of `aspen dap`, it lets an editor control the debugger through the Debug Adapter
+// Protocol. The server reads the requests of the editor while another goroutine runs the program, the requests
+// that inspect or resume the program are sent to that goroutine as commands.
+type DapServer struct {
+	reader *bufio.Reader
+	writer io.Writer
+
+	// both goroutines send messages, `lock` keeps them whole
+	lock sync.Mutex
+	seq  int
+
+	options  RuntimeOptions
+	path     string
+	debugger *Debugger
+
+	// the commands run by the goroutine running the program, a command returns false if the program resumes
+	commands chan func() bool
+
+	// closed once the program ended
+	done chan struct{}
+
+	// the pipe the program prints to in place of stdout, and a channel closed once all it printed was forwarded
+	stdout    io.Closer
+	forwarded chan struct{}
+
+	// whether the editor sent the configurationDone request before it launched the program
+	configured bool
+
+	// whether the program resumed since it started, and whether the editor paused it
+	resumed bool
+	pausing bool
+
+	// the references given to the editor since the program stopped, a variablesReference is an index plus one
+	references []DapReference
+}
+
+// NewDapServer returns a server reading requests from `input` and writing responses and events to `output`
+func NewDapServer(input io.Reader, output io.Writer, options RuntimeOptions) *DapServer {
+	return &DapServer{
+		reader:   bufio.NewReader(input),
+		writer:   output,
+		options:  options,
+		commands: make(chan func() bool),
+		done:     make(chan struct{}),
+	}
+}
+
+// ServeDap serves the Debug Adapter Protocol on stdin and stdout. The program prints to stdout as well, so stdout
+// is replaced by a pipe whose output is sent to the editor in output events.
+func ServeDap(options RuntimeOptions) error {
+	protocol := os.Stdout
+	reader, writer, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	os.Stdout = writer
+
+	server := NewDapServer(os.Stdin, protocol, options)
+	server.stdout, server.forwarded = writer, make(chan struct{})
+	go func() {
+		server.Forward(reader, "stdout")
+		close(server.forwarded)
+	}()
+	return server.Serve()
+}
+
+// Serve handles requests until the editor disconnects
+func (s *DapServer) Serve() error {
+	for {
+		request, err := s.Read()
+		if err == io.EOF {
+			s.Stop()
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		if request.Type == "request" && !s.Handle(request) {
+			return nil
+		}
+	}
+}
+
+// Read reads a message, which is a header giving the length of its JSON body followed by the body
+func (s *DapServer) Read() (*DapRequest, error) {
+	length := -1
+	for {
+		line, err := s.reader.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+
+		line = strings.TrimSpace(line)
+		if line == "" {
+			break
+		}
+
+		if value := strings.TrimPrefix(line, "Content-Length:"); value != line {
+			length, err = strconv.Atoi(strings.TrimSpace(value))
+			if err != nil {
+				return nil, fmt.Errorf("error: invalid Content-Length header '%s'.", line)
+			}
+		}
+	}
+
+	if length < 0 {
+		return nil, errors.New("error: expected a Content-Length header.")
+	}
+
+	body := make([]byte, length)
+	if _, err := io.ReadFull(s.reader, body); err != nil {
+		return nil, err
+	}
+
+	request := &DapRequest{}
+	if err := json.Unmarshal(body, request); err != nil {
+		return nil, fmt.Errorf("error: invalid message, %v.", err)
+	}
+	return request, nil
+}
+
+func (s *DapServer) Send(message map[string]interface{}) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.seq++
+	message["seq"] = s.seq
+
+	body, _ := json.Marshal(message)
+	fmt.Fprintf(s.writer, "Content-Length: %d\r\n\r\n%s", len(body), body)
+}
+
+// Respond answers a request, with `body` if it succeeded or with the message of `err` if it failed
+func (s *DapServer) Respond(request *DapRequest, body interface{}, err error) {
+	message := map[string]interface{}{
+		"type":        "response",
+		"request_seq": request.Seq,
+		"command":     request.Command,
+		"success":     err == nil,
+	}
+
+	if err != nil {
+		message["message"] = ErrorMessage(err)
+	} else if body != nil {
+		message["body"] = body
+	}
+
+	s.Send(message)
+}
+
+func (s *DapServer) Event(event string, body interface{}) {
+	message := map[string]interface{}{"type": "event", "event": event}
+	if body != nil {
+		message["body"] = body
+	}
+	s.Send(message)
+}
+
+// Forward sends what the program writes to `reader` to the editor, a line at a time
+func (s *DapServer) Forward(reader io.Reader, category string) {
+	lines := bufio.NewReader(reader)
+	for {
+		line, err := lines.ReadString('\n')
+		if line != "" {
+			s.Event("output", map[string]interface{}{"category": category, "output": line})
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// Run runs a command on the goroutine running the program and waits for it, it returns false if no program is
+// running
+func (s *DapServer) Run(command func() bool) bool {
+	if s.debugger == nil {
+		return false
+	}
+
+	finished := make(chan struct{})
+	wrapped := func() bool {
+		defer close(finished)
+		return command()
+	}
+
+	select {
+	case s.commands <- wrapped:
+	case <-s.done:
+		return false
+	}
+
+	<-finished
+	return true
+}
+
+// Stop stops the program and waits for it to end
+func (s *DapServer) Stop() {
+	if s.Run(func() bool { Quit(); return false }) {
+		<-s.done
+	}
+}
+
+// Handle answers a request, it returns false once the editor disconnects
+func (s *DapServer) Handle(request *DapRequest) bool {
+	switch request.Command {
+	case "initialize":
+		s.Respond(request, map[string]interface{}{
+			"supportsConfigurationDoneRequest": true,
+			"supportsEvaluateForHovers":        true,
+			"supportsTerminateRequest":         true,
+		}, nil)
+		s.Event("initialized", nil)
+	case "launch":
+		s.Respond(request, nil, s.Launch(request))
+	case "setBreakpoints":
+		s.Respond(request, s.SetBreakpoints(request), nil)
+	case "configurationDone":
+		s.Configured(request)
+	case "threads":
+		s.Respond(request, map[string]interface{}{
+			"threads": []interface{}{map[string]interface{}{"id": DAP_THREAD, "name": "main"}},
+		}, nil)
+	case "stackTrace":
+		s.Inspect(request, s.StackTrace)
+	case "scopes":
+		s.Inspect(request, s.Scopes)
+	case "variables":
+		s.Inspect(request, s.Variables)
+	case "evaluate":
+		s.Inspect(request, s.Evaluate)
+	case "continue":
+		s.Resume(request, DEBUG_CONTINUE)
+	case "next":
+		s.Resume(request, DEBUG_NEXT)
+	case "stepIn":
+		s.Resume(request, DEBUG_STEP)
+	case "stepOut":
+		s.Resume(request, DEBUG_FINISH)
+	case "pause":
+		s.Run(func() bool {
+			s.pausing = true
+			s.debugger.Resume(DEBUG_STEP)
+			return true
+		})
+		s.Respond(request, nil, nil)
+	case "terminate":
+		s.Stop()
+		s.Respond(request, nil, nil)
+	case "disconnect":
+		s.Stop()
+		s.Respond(request, nil, nil)
+		return false
+	default:
+		s.Respond(request, nil, fmt.Errorf("unsupported request '%s'.", request.Command))
+	}
+
+	return true
+}
+
+// Launch type checks the program of a launch request and starts running it, the program waits for the
+// configurationDone request before its first statement
+func (s *DapServer) Launch(request *DapRequest) error {
+	var arguments struct {
+		Program     string `json:"program"`
+		StopOnEntry bool   `json:"stopOnEntry"`
+		NoDebug     bool   `json:"noDebug"`
+	}
+	if err := json.Unmarshal(request.Arguments, &arguments); err != nil || arguments.Program == "" {
+		return errors.New("expected the path of the program to debug.")
+	}
+
+	if s.debugger != nil {
+		return errors.New("a program was already launched.")
+	}
+
+	source, err := OpenFile(arguments.Program)
+	if err != nil {
+		return fmt.Errorf("cannot open file %s.", arguments.Program)
+	}
+
+	ast, err := TypeCheckSource(source)
+	if err != nil {
+		// the editor shows the message of the response, the whole report goes to its console
+		s.Event("output", map[string]interface{}{"category": "stderr", "output": err.Error() + "\n"})
+		return err
+	}
+
+	s.path, _ = filepath.Abs(arguments.Program)
+	s.debugger = NewDebugger(source)
+	s.debugger.frontend = s
+	s.debugger.detached = arguments.NoDebug
+	if !arguments.StopOnEntry {
+		s.debugger.mode = DEBUG_CONTINUE
+	}
+
+	go func() {
+		err := s.debugger.Run(ast, s.options)
+
+		exitCode := 0
+		if err != nil && !errors.Is(err, ErrDebuggerQuit) {
+			s.Event("output", map[string]interface{}{"category": "stderr", "output": err.Error() + "\n"})
+			exitCode = ExitCode(err)
+		}
+
+		// the editor is told what the program printed before it is told that the program exited
+		if s.stdout != nil {
+			s.stdout.Close()
+			<-s.forwarded
+		}
+
+		s.Event("exited", map[string]interface{}{"exitCode": exitCode})
+		s.Event("terminated", nil)
+		close(s.done)
+	}()
+
+	return nil
+}
+
+// SetBreakpoints replaces the breakpoints of the program, the editor is told which lines no statement starts on
+func (s *DapServer) SetBreakpoints(request *DapRequest) interface{} {
+	var arguments struct {
+		Source struct {
+			Path string `json:"path"`
+		} `json:"source"`
+		Breakpoints []struct {
+			Line int `json:"line"`
+		} `json:"breakpoints"`
+	}
+	json.Unmarshal(request.Arguments, &arguments)
+
+	breakpoints := make([]interface{}, len(arguments.Breakpoints))
+	verified := make([]bool, len(arguments.Breakpoints))
+
+	path, _ := filepath.Abs(arguments.Source.Path)
+	if path == s.path {
+		s.Run(func() bool {
+			s.debugger.breakpoints = make(map[int]bool)
+			for i, breakpoint := range arguments.Breakpoints {
+				verified[i] = s.debugger.Break(breakpoint.Line)
+			}
+			return true
+		})
+	}
+
+	for i, breakpoint := range arguments.Breakpoints {
+		result := map[string]interface{}{"verified": verified[i], "line": breakpoint.Line}
+		if !verified[i] {
+			result["message"] = "no statement starts on this line."
+		}
+		breakpoints[i] = result
+	}
+
+	return map[string]interface{}{"breakpoints": breakpoints}
+}
+
+// Inspect answers a request about the state of the program on the goroutine running it
+func (s *DapServer) Inspect(request *DapRequest, inspect func(arguments json.RawMessage) (interface{}, error)) {
+	var body interface{}
+	var err error
+
+	if !s.Run(func() bool {
+		body, err = inspect(request.Arguments)
+		return true
+	}) {
+		err = errors.New("the program is not running.")
+	}
+
+	s.Respond(request, body, err)
+}
+
+// Resume lets the program run until the debugger stops it in `mode`. The response is sent before the program
+// resumes, so that it comes before the event of the next stop.
+func (s *DapServer) Resume(request *DapRequest, mode DebugMode) {
+	var body interface{}
+	if mode == DEBUG_CONTINUE {
+		body = map[string]interface{}{"allThreadsContinued": true}
+	}
+
+	if !s.Run(func() bool {
+		s.resumed = true
+		s.debugger.Resume(mode)
+		s.Respond(request, body, nil)
+		return false
+	}) {
+		s.Respond(request, nil, errors.New("the program is not running."))
+	}
+}
+
+// Configured lets the program run its first statement once the editor set its breakpoints, a program launched
+// later does not wait
+func (s *DapServer) Configured(request *DapRequest) {
+	if s.debugger == nil {
+		s.configured = true
+		s.Respond(request, nil, nil)
+		return
+	}
+
+	if !s.Run(func() bool {
+		s.Respond(request, nil, nil)
+		return false
+	}) {
+		s.Respond(request, nil, nil)
+	}
+}
+
+func (s *DapServer) Attached() {
+	if s.configured {
+		return
+	}
+
+	for command := range s.commands {
+		if !command() {
+			return
+		}
+	}
+}
+
+func (s *DapServer) Running() {
+	for {
+		select {
+		case command := <-s.commands:
+			command()
+		default:
+			return
+		}
+	}
+}
+
+func (s *DapServer) Stopped(reason string, position Token) {
+	switch {
+	case s.pausing:
+		reason = "pause"
+	case reason == "step" && !s.resumed:
+		reason = "entry"
+	}
+	s.pausing = false
+	s.references = nil
+
+	s.Event("stopped", map[string]interface{}{
+		"reason":            reason,
+		"threadId":          DAP_THREAD,
+		"allThreadsStopped": true,
+	})
+
+	for command := range s.commands {
+		if !command() {
+			return
+		}
+	}
+}
+
+func (s *DapServer) Returned(frame *DebugFrame, value interface{}) {}
+
+// Frame returns the call whose id is `id`, the id of a call is its position in the calls in progress plus one
+func (s *DapServer) Frame(id int) (*DebugFrame, error) {
+	if id == 0 {
+		return s.debugger.Top(), nil
+	}
+	if id < 1 || id > len(s.debugger.frames) || s.debugger.frames[id-1].statement == nil {
+		return nil, fmt.Errorf("no stack frame %d.", id)
+	}
+	return &s.debugger.frames[id-1], nil
+}
+
+// Reference returns the variablesReference of `reference`
+func (s *DapServer) Reference(reference DapReference) int {
+	s.references = append(s.references, reference)
+	return len(s.references)
+}
+
+func (s *DapServer) StackTrace(arguments json.RawMessage) (interface{}, error) {
+	source := map[string]interface{}{"name": filepath.Base(s.path), "path": s.path}
+
+	frames := []interface{}{}
+	for i := len(s.debugger.frames) - 1; i >= 0; i-- {
+		frame := &s.debugger.frames[i]
+		if frame.layout == nil || frame.statement == nil {
+			continue
+		}
+
+		position := frame.Position()
+		frames = append(frames, map[string]interface{}{
+			"id":     i + 1,
+			"name":   frame.name,
+			"line":   position.line,
+			"column": position.col,
+			"source": source,
+		})
+	}
+
+	return map[string]interface{}{"stackFrames": frames, "totalFrames": len(frames)}, nil
+}
+
+func (s *DapServer) Scopes(arguments json.RawMessage) (interface{}, error) {
+	var scopes struct {
+		FrameId int `json:"frameId"`
+	}
+	json.Unmarshal(arguments, &scopes)
+
+	frame, err := s.Frame(scopes.FrameId)
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]interface{}{"scopes": []interface{}{
+		map[string]interface{}{
+			"name":               "Locals",
+			"presentationHint":   "locals",
+			"variablesReference": s.Reference(DapReference{frame: frame}),
+			"expensive":          false,
+		},
+		map[string]interface{}{
+			"name":               "Globals",
+			"variablesReference": s.Reference(DapReference{frame: frame, globals: true}),
+			"expensive":          false,
+		},
+	}}, nil
+}
+
+func (s *DapServer) Variables(arguments json.RawMessage) (interface{}, error) {
+	var variables struct {
+		VariablesReference int `json:"variablesReference"`
+	}
+	json.Unmarshal(arguments, &variables)
+
+	id := variables.VariablesReference
+	if id < 1 || id > len(s.references) {
+		return nil, fmt.Errorf("no variables %d.", id)
+	}
+	reference := s.references[id-1]
+
+	results := []interface{}{}
+	if reference.frame != nil {
+		for _, variable := range s.debugger.Variables(reference.frame) {
+			if variable.global != reference.globals || !IsUserVariable(variable) {
+				continue
+			}
+
+			value := s.debugger.Get(reference.frame, variable)
+			results = append(results, s.Variable(variable.name, value, variable.atype))
+		}
+	} else {
+		names, values, types := Elements(reference.value, reference.atype)
+		for i := range names {
+			results = append(results, s.Variable(names[i], values[i], types[i]))
+		}
+	}
+
+	return map[string]interface{}{"variables": results}, nil
+}
+
+// Variable describes a value to the editor, values with elements can be expanded
+func (s *DapServer) Variable(name string, value interface{}, atype *Type) map[string]interface{} {
+	variable := map[string]interface{}{
+		"name":               name,
+		"value":              TraceValue(value),
+		"type":               atype.String(),
+		"variablesReference": 0,
+	}
+
+	if IsComposite(value, atype) {
+		variable["variablesReference"] = s.Reference(DapReference{value: value, atype: atype})
+	}
+
+	return variable
+}
+
+// IsComposite reports whether a value is a slice, tuple, map or struct, or an optional holding one, even one
+// without elements
+func IsComposite(value interface{}, atype *Type) bool {
+	if value == nil {
+		return false
+	}
+
+	if atype.kind == TYPE_OPTIONAL {
+		atype = atype.other.(OptionalType).of
+	}
+
+	switch atype.kind {
+	case TYPE_SLICE, TYPE_TUPLE, TYPE_MAP, TYPE_STRUCT:
+		return true
+	}
+	return false
+}
+
+// Elements returns the names, values and types of the elements of a slice, tuple, map or struct, or of the value
+// of an optional holding one
+func Elements(value interface{}, atype *Type) ([]string, []interface{}, []*Type) {
+	names, values, types := []string{}, []interface{}{}, []*Type{}
+	if value == nil {
+		return names, values, types
+	}
+
+	if atype.kind == TYPE_OPTIONAL {
+		atype = atype.other.(OptionalType).of
+	}
+
+	switch v := value.(type) {
+	case []interface{}:
+		of := atype.other.(SliceType).of
+		for i, element := range v {
+			names = append(names, fmt.Sprintf("[%d]", i))
+			values = append(values, element)
+			types = append(types, of)
+		}
+	case TupleValue:
+		elements := atype.other.(TupleType).elements
+		for i, element := range v {
+			names = append(names, strconv.Itoa(i))
+			values = append(values, element)
+			types = append(types, elements[i])
+		}
+	case *MapValue:
+		mapType := atype.other.(MapType)
+		keys, entries := v.Entries()
+		for i, key := range keys {
+			names = append(names, QuoteValue(key))
+			values = append(values, entries[i])
+			types = append(types, mapType.value)
+		}
+	case *StructValue:
+		for i, field := range v.atype.fields {
+			names = append(names, field.name)
+			values = append(values, v.fields[i])
+			types = append(types, field.atype)
+		}
+	}
+
+	return names, values, types
+}
+
+func (s *DapServer) Evaluate(arguments json.RawMessage) (interface{}, error) {
+	var evaluate struct {
+		Expression string `json:"expression"`
+		FrameId    int    `json:"frameId"`
+	}
+	json.Unmarshal(arguments, &evaluate)
+
+	frame, err := s.Frame(evaluate.FrameId)
+	if err != nil {
+		return nil, err
+	}
+
+	value, atype, err := s.debugger.Evaluate(frame, evaluate.Expression)
+	if err != nil {
+		return nil, err
+	}
+
+	if atype.IsVoid() {
+		return map[string]interface{}{"result": "", "type": "void", "variablesReference": 0}, nil
+	}
+
+	result := s.Variable(evaluate.Expression, value, atype)
+	result["result"] = QuoteValue(value)
+	delete(result, "name")
+	delete(result, "value")
+	return result, nil
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// DapClient plays the part of the editor in the tests of the debug adapter
+type DapClient struct {
+	t      *testing.T
+	writer io.Writer
+	reader *bufio.Reader
+	seq    int
+}
+
+func (c *DapClient) Request(command string, arguments interface{}) {
+	c.seq++
+	body, _ := json.Marshal(map[string]interface{}{
+		"seq":       c.seq,
+		"type":      "request",
+		"command":   command,
+		"arguments": arguments,
+	})
+	fmt.Fprintf(c.writer, "Content-Length: %d\r\n\r\n%s", len(body), body)
+}
+
+func (c *DapClient) Read() map[string]interface{} {
+	length := 0
+	for {
+		line, err := c.reader.ReadString('\n')
+		if err != nil {
+			c.t.Fatal(err)
+		}
+		if line = strings.TrimSpace(line); line == "" {
+			break
+		}
+		length, _ = strconv.Atoi(strings.TrimPrefix(line, "Content-Length: "))
+	}
+
+	body := make([]byte, length)
+	if _, err := io.ReadFull(c.reader, body); err != nil {
+		c.t.Fatal(err)
+	}
+
+	message := map[string]interface{}{}
+	if err := json.Unmarshal(body, &message); err != nil {
+		c.t.Fatal(err)
+	}
+	return message
+}
+
+// Expect reads messages until the response to `command` or the event `event`, and returns its body
+func (c *DapClient) Expect(kind string, name string) map[string]interface{} {
+	for {
+		message := c.Read()
+		if message["type"] != kind || (message["command"] != name && message["event"] != name) {
+			continue
+		}
+
+		if message["type"] == "response" && message["success"] != true {
+			c.t.Fatalf("%s failed: %v", name, message["message"])
+		}
+		body, _ := message["body"].(map[string]interface{})
+		return body
+	}
+}
+
+// Call sends a request and returns the body of its response
+func (c *DapClient) Call(command string, arguments interface{}) map[string]interface{} {
+	c.Request(command, arguments)
+	return c.Expect("response", command)
+}
+
+// Variables returns the variables of a variablesReference as `name: type = value` strings
+func (c *DapClient) Variables(reference interface{}) []string {
+	body := c.Call("variables", map[string]interface{}{"variablesReference": reference})
+
+	variables := []string{}
+	for _, v := range body["variables"].([]interface{}) {
+		variable := v.(map[string]interface{})
+		variables = append(variables, fmt.Sprintf("%s: %s = %s", variable["name"], variable["type"], variable["value"]))
+	}
+	return variables
+}
+
+// References returns the variablesReference of each variable of a variablesReference by variable name
+func (c *DapClient) References(reference interface{}) map[string]interface{} {
+	body := c.Call("variables", map[string]interface{}{"variablesReference": reference})
+
+	references := make(map[string]interface{})
+	for _, v := range body["variables"].([]interface{}) {
+		variable := v.(map[string]interface{})
+		references[variable["name"].(string)] = variable["variablesReference"]
+	}
+	return references
+}
+
+func TestDapServer(t *testing.T) {
+	Initialize()
+
+	path := filepath.Join(t.TempDir(), "points.aspen")
+	source := `struct Point {
+    x i64;
+    y i64;
+}
+
+fn area(p Point) i64 {
+    let result = p.x * p.y;
+    return result;
+}
+
+let empty = Point[]{};
+let points = Point[]{Point{x: 2, y: 3}, Point{x: 4, y: 5}};
+let total = 0;
+total = total + area(points[0]);
+total = total + area(points[1]);
+`
+	if err := os.WriteFile(path, []byte(source), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	requests, input := io.Pipe()
+	output, responses := io.Pipe()
+	server := NewDapServer(requests, responses, DefaultRuntimeOptions())
+	served := make(chan error)
+	go func() { served <- server.Serve() }()
+
+	client := &DapClient{t: t, writer: input, reader: bufio.NewReader(output)}
+
+	client.Call("initialize", map[string]interface{}{"adapterID": "aspen"})
+	client.Expect("event", "initialized")
+	client.Call("launch", map[string]interface{}{"program": path})
+
+	body := client.Call("setBreakpoints", map[string]interface{}{
+		"source":      map[string]interface{}{"path": path},
+		"breakpoints": []interface{}{map[string]interface{}{"line": 7}, map[string]interface{}{"line": 5}},
+	})
+	verified := []bool{}
+	for _, breakpoint := range body["breakpoints"].([]interface{}) {
+		verified = append(verified, breakpoint.(map[string]interface{})["verified"].(bool))
+	}
+	if !reflect.DeepEqual(verified, []bool{true, false}) {
+		t.Errorf("expected only the breakpoint on line 7 to be verified, got %v", verified)
+	}
+
+	client.Call("configurationDone", nil)
+	if reason := client.Expect("event", "stopped")["reason"]; reason != "breakpoint" {
+		t.Errorf("expected to stop at a breakpoint, stopped for %v", reason)
+	}
+
+	frames, ids := []string{}, []interface{}{}
+	for _, f := range client.Call("stackTrace", map[string]interface{}{"threadId": DAP_THREAD})["stackFrames"].([]interface{}) {
+		frame := f.(map[string]interface{})
+		frames = append(frames, fmt.Sprintf("%s %v:%v", frame["name"], frame["line"], frame["column"]))
+		ids = append(ids, frame["id"])
+	}
+	if expected := []string{"area 7:9", "<top level> 14:1"}; !reflect.DeepEqual(frames, expected) {
+		t.Errorf("expected the stack trace %v, got %v", expected, frames)
+	}
+
+	scopes := client.Call("scopes", map[string]interface{}{"frameId": ids[1]})["scopes"].([]interface{})
+	globals := client.Variables(scopes[1].(map[string]interface{})["variablesReference"])
+	expected := []string{"area: fn(Point)i64 = <fn area>", "empty: Point[] = []", "points: Point[] = [Point{x: 2, y: 3} Point{x: 4, y: 5}]", "total: i64 = 0"}
+	if !reflect.DeepEqual(globals, expected) {
+		t.Errorf("expected the globals %v, got %v", expected, globals)
+	}
+
+	// composite values can be expanded, even when they have no elements
+	references := client.References(scopes[1].(map[string]interface{})["variablesReference"])
+	if references["area"] != 0.0 || references["total"] != 0.0 || references["empty"] == 0.0 || references["points"] == 0.0 {
+		t.Errorf("expected only the slices to have variables, got %v", references)
+	}
+	if elements := client.Variables(references["empty"]); len(elements) != 0 {
+		t.Errorf("expected no elements in empty, got %v", elements)
+	}
+
+	scopes = client.Call("scopes", map[string]interface{}{"frameId": ids[0]})["scopes"].([]interface{})
+	references = client.References(scopes[0].(map[string]interface{})["variablesReference"])
+	fields := client.Variables(references["p"])
+	if expected := []string{"x: i64 = 2", "y: i64 = 3"}; !reflect.DeepEqual(fields, expected) {
+		t.Errorf("expected the fields of p %v, got %v", expected, fields)
+	}
+
+	body = client.Call("evaluate", map[string]interface{}{"expression": "points", "frameId": ids[1]})
+	if body["type"] != "Point[]" {
+		t.Errorf("expected points to be a Point[], got %v", body["type"])
+	}
+	elements := client.Variables(body["variablesReference"])
+	if expected := []string{"[0]: Point = Point{x: 2, y: 3}", "[1]: Point = Point{x: 4, y: 5}"}; !reflect.DeepEqual(elements, expected) {
+		t.Errorf("expected the elements %v, got %v", expected, elements)
+	}
+
+	body = client.Call("evaluate", map[string]interface{}{"expression": "p.x * 10"})
+	if body["result"] != "20" {
+		t.Errorf("expected p.x * 10 to be 20, got %v", body["result"])
+	}
+
+	client.Call("next", map[string]interface{}{"threadId": DAP_THREAD})
+	if reason := client.Expect("event", "stopped")["reason"]; reason != "step" {
+		t.Errorf("expected to stop after a step, stopped for %v", reason)
+	}
+	body = client.Call("evaluate", map[string]interface{}{"expression": "result"})
+	if body["result"] != "6" {
+		t.Errorf("expected result to be 6, got %v", body["result"])
+	}
+
+	client.Call("setBreakpoints", map[string]interface{}{
+		"source":      map[string]interface{}{"path": path},
+		"breakpoints": []interface{}{},
+	})
+	client.Call("continue", map[string]interface{}{"threadId": DAP_THREAD})
+	if exitCode := client.Expect("event", "exited")["exitCode"]; exitCode != 0.0 {
+		t.Errorf("expected the exit code 0, got %v", exitCode)
+	}
+	client.Expect("event", "terminated")
+
+	client.Call("disconnect", nil)
+	if err := <-served; err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,288 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const debugConsoleHelp = `commands:
+    break <line>, b <line>    stop at the statements starting on <line>
+    clear <line>              remove the breakpoint on <line>
+    breakpoints               list the breakpoints
+    continue, c               run until the next breakpoint
+    step, s                   run until the next line, stepping into calls
+    next, n                   run until the next line, stepping over calls
+    finish, f                 run until the current call returns
+    locals, l                 print the local variables
+    globals                   print the global variables
+    print <expr>, p <expr>    evaluate an expression in the current scope
+    watch <expr>, w <expr>    evaluate an expression each time the program stops
+    unwatch <n>               remove the watch expression <n>
+    backtrace, bt             print the calls in progress
+    quit, q                   stop the program
+    help, h                   print this help
+an empty line repeats the previous command`
+
+// DebugConsole is the frontend of `aspen debug`, it reads commands at a prompt while the program is stopped
+type DebugConsole struct {
+	debugger *Debugger
+	input    *bufio.Scanner
+	output   io.Writer
+
+	// the watch expressions, removed expressions are empty so that the others keep their numbers
+	watches []string
+
+	// the command repeated by an empty line
+	previous string
+}
+
+// NewDebugConsole returns a console controlling `debugger`, reading commands from `input` and writing to `output`
+func NewDebugConsole(debugger *Debugger, input io.Reader, output io.Writer) *DebugConsole {
+	console := &DebugConsole{debugger: debugger, input: bufio.NewScanner(input), output: output}
+	debugger.frontend = console
+	return console
+}
+
+// Run executes a type checked program in the debugger, the console reports when the program finishes unless the
+// user quit
+func (c *DebugConsole) Run(ast Program, options RuntimeOptions) error {
+	err := c.debugger.Run(ast, options)
+	if errors.Is(err, ErrDebuggerQuit) {
+		return nil
+	}
+
+	if err == nil {
+		fmt.Fprintln(c.output, "the program finished.")
+	}
+	return err
+}
+
+func (c *DebugConsole) Attached() {}
+
+func (c *DebugConsole) Running() {}
+
+func (c *DebugConsole) Stopped(reason string, position Token) {
+	if reason == "step" {
+		reason = "stopped"
+	}
+
+	fmt.Fprintf(c.output, "%s at %d:%d in %s\n", reason, position.line, position.col, c.debugger.Top().name)
+	fmt.Fprintf(c.output, "    %d | %s\n", position.line, GetLine(c.debugger.source, position.line))
+	c.PrintWatches()
+
+	for c.Command() {
+	}
+}
+
+func (c *DebugConsole) Returned(frame *DebugFrame, value interface{}) {
+	if frame.void {
+		fmt.Fprintf(c.output, "%s returned\n", frame.name)
+	} else {
+		fmt.Fprintf(c.output, "%s returned %s\n", frame.name, QuoteValue(value))
+	}
+}
+
+// Command reads and runs a command, it returns false once the program resumes
+func (c *DebugConsole) Command() bool {
+	d := c.debugger
+
+	fmt.Fprint(c.output, "(aspen) ")
+	if !c.input.Scan() {
+		// without commands the program runs to completion
+		fmt.Fprintln(c.output)
+		d.detached = true
+		return false
+	}
+
+	line := strings.TrimSpace(c.input.Text())
+	if line == "" {
+		line = c.previous
+	}
+	c.previous = line
+
+	command, argument := line, ""
+	if i := strings.IndexAny(line, " \t"); i >= 0 {
+		command, argument = line[:i], strings.TrimSpace(line[i+1:])
+	}
+
+	switch command {
+	case "":
+	case "continue", "c":
+		d.Resume(DEBUG_CONTINUE)
+		return false
+	case "step", "s":
+		d.Resume(DEBUG_STEP)
+		return false
+	case "next", "n":
+		d.Resume(DEBUG_NEXT)
+		return false
+	case "finish", "f":
+		if len(d.frames) == 1 {
+			fmt.Fprintln(c.output, "the program is not in a function.")
+			break
+		}
+		d.Resume(DEBUG_FINISH)
+		return false
+	case "break", "b":
+		c.Break(argument)
+	case "clear":
+		c.Clear(argument)
+	case "breakpoints":
+		c.PrintBreakpoints()
+	case "locals", "l":
+		c.PrintVariables(false)
+	case "globals":
+		c.PrintVariables(true)
+	case "print", "p":
+		c.Print(argument)
+	case "watch", "w":
+		c.Watch(argument)
+	case "unwatch":
+		c.Unwatch(argument)
+	case "backtrace", "bt":
+		c.PrintBacktrace()
+	case "quit", "q":
+		Quit()
+	case "help", "h":
+		fmt.Fprintln(c.output, debugConsoleHelp)
+	default:
+		fmt.Fprintf(c.output, "unknown command '%s', type help to list the commands.\n", command)
+	}
+
+	return true
+}
+
+func (c *DebugConsole) Line(argument string) (int, bool) {
+	line, err := strconv.Atoi(argument)
+	if err != nil {
+		fmt.Fprintf(c.output, "expected a line number, got '%s'.\n", argument)
+		return 0, false
+	}
+	return line, true
+}
+
+func (c *DebugConsole) Break(argument string) {
+	line, ok := c.Line(argument)
+	if !ok {
+		return
+	}
+
+	if !c.debugger.Break(line) {
+		fmt.Fprintf(c.output, "no statement starts on line %d.\n", line)
+		return
+	}
+	fmt.Fprintf(c.output, "breakpoint on line %d.\n", line)
+}
+
+func (c *DebugConsole) Clear(argument string) {
+	line, ok := c.Line(argument)
+	if !ok {
+		return
+	}
+
+	if !c.debugger.breakpoints[line] {
+		fmt.Fprintf(c.output, "no breakpoint on line %d.\n", line)
+		return
+	}
+
+	delete(c.debugger.breakpoints, line)
+	fmt.Fprintf(c.output, "cleared the breakpoint on line %d.\n", line)
+}
+
+func (c *DebugConsole) PrintBreakpoints() {
+	lines := []int{}
+	for line := range c.debugger.breakpoints {
+		lines = append(lines, line)
+	}
+	sort.Ints(lines)
+
+	if len(lines) == 0 {
+		fmt.Fprintln(c.output, "no breakpoints.")
+	}
+	for _, line := range lines {
+		fmt.Fprintf(c.output, "    %d | %s\n", line, GetLine(c.debugger.source, line))
+	}
+}
+
+func (c *DebugConsole) PrintBacktrace() {
+	top := c.debugger.Top()
+	position := top.Position()
+	fmt.Fprintf(c.output, "%s at %d:%d\n", top.name, position.line, position.col)
+
+	for _, frame := range c.debugger.interpreter.StackTrace() {
+		fmt.Fprintf(c.output, "    %s, called at %d:%d\n", frame.function, frame.callSite.line, frame.callSite.col)
+	}
+}
+
+func (c *DebugConsole) PrintVariables(globals bool) {
+	top := c.debugger.Top()
+
+	printed := false
+	for _, variable := range c.debugger.Variables(top) {
+		if variable.global != globals || !IsUserVariable(variable) {
+			continue
+		}
+
+		value := c.debugger.Get(top, variable)
+		fmt.Fprintf(c.output, "    %s: %v = %s\n", variable.name, variable.atype, QuoteValue(value))
+		printed = true
+	}
+
+	if !printed && globals {
+		fmt.Fprintln(c.output, "no global variables.")
+	} else if !printed {
+		fmt.Fprintln(c.output, "no local variables.")
+	}
+}
+
+func (c *DebugConsole) Print(argument string) {
+	value, atype, err := c.debugger.Evaluate(c.debugger.Top(), argument)
+	if err != nil {
+		fmt.Fprintf(c.output, "error: %s\n", ErrorMessage(err))
+	} else if !atype.IsVoid() {
+		fmt.Fprintln(c.output, QuoteValue(value))
+	}
+}
+
+func (c *DebugConsole) Watch(argument string) {
+	if _, err := c.debugger.Check(c.debugger.Top(), argument); err != nil {
+		fmt.Fprintf(c.output, "error: %s\n", ErrorMessage(err))
+		return
+	}
+
+	c.watches = append(c.watches, argument)
+	fmt.Fprintf(c.output, "watch %d: %s\n", len(c.watches), argument)
+}
+
+func (c *DebugConsole) Unwatch(argument string) {
+	n, err := strconv.Atoi(argument)
+	if err != nil || n < 1 || n > len(c.watches) || c.watches[n-1] == "" {
+		fmt.Fprintf(c.output, "no watch expression '%s'.\n", argument)
+		return
+	}
+
+	c.watches[n-1] = ""
+}
+
+func (c *DebugConsole) PrintWatches() {
+	for i, watch := range c.watches {
+		if watch == "" {
+			continue
+		}
+
+		value, atype, err := c.debugger.Evaluate(c.debugger.Top(), watch)
+		switch {
+		case err != nil:
+			fmt.Fprintf(c.output, "watch %d: %s: %s\n", i+1, watch, ErrorMessage(err))
+		case atype.IsVoid():
+			fmt.Fprintf(c.output, "watch %d: %s\n", i+1, watch)
+		default:
+			fmt.Fprintf(c.output, "watch %d: %s = %s\n", i+1, watch, QuoteValue(value))
+		}
+	}
+}
@@ -0,0 +1,437 @@
+package main
+
+import (
+	"errors"
+	"strings"
+)
+
+// DebugMode is what the debugger waits for before it stops the program again
+type DebugMode int
+
+const (
+	DEBUG_CONTINUE DebugMode = iota // a breakpoint
+	DEBUG_STEP                      // the next line
+	DEBUG_NEXT                      // the next line of the current call or of one of its callers
+	DEBUG_FINISH                    // the next line of one of the callers of the current call
+)
+
+// ErrDebuggerQuit is the cause of the runtime error that stops a program when the debugger quits
+var ErrDebuggerQuit = errors.New("the debugger stopped the program")
+
+// Quit stops the program, it must be called by the goroutine running the program
+func Quit() {
+	panic(&AspenRuntimeError{message: "the debugger stopped the program.", cause: ErrDebuggerQuit})
+}
+
+// DebugFrontend lets a user control the debugger. Its methods are called by the goroutine running the program.
+type DebugFrontend interface {
+	// Attached is called once the program is resolved, before its first statement
+	Attached()
+
+	// Running is called before each statement the program executes
+	Running()
+
+	// Stopped is called when the program stops, it returns once the program resumes
+	Stopped(reason string, position Token)
+
+	// Returned is called when the call being finished returns `value`
+	Returned(frame *DebugFrame, value interface{})
+}
+
+// DebugFrame is a call in progress
+type DebugFrame struct {
+	name   string
+	layout *FunctionLayout // nil for native and builtin functions
+	void   bool
+
+	// the frame of the call and the statement it is executing
+	frame     *Frame
+	statement Statement
+}
+
+// Binding returns where the call finds a variable, a call can only access the variables of enclosing functions
+// that it captured
+func (f *DebugFrame) Binding(variable *Variable) (Binding, bool) {
+	if variable.global {
+		return Binding{kind: BINDING_GLOBAL, index: variable.index}, true
+	}
+
+	if variable.function == f.layout {
+		if variable.captured {
+			return Binding{kind: BINDING_CELL, index: variable.index}, true
+		}
+		return Binding{kind: BINDING_LOCAL, index: variable.index}, true
+	}
+
+	for i, captured := range f.layout.captured {
+		if captured == variable {
+			return Binding{kind: BINDING_CAPTURED, index: i}, true
+		}
+	}
+	return Binding{}, false
+}
+
+// Position returns the position of the statement the call is executing
+func (f *DebugFrame) Position() Token {
+	return StatementPosition(f.statement)
+}
+
+// Debugger is an observer that stops the program at breakpoints and after steps, a frontend lets the user inspect
+// the program while it is stopped and decide how it resumes
+type Debugger struct {
+	source   []rune
+	frontend DebugFrontend
+
+	interpreter *Interpreter
+	layout      *ProgramLayout
+	structs     map[string]*Type
+
+	// the lines that statements start on, breakpoints can only be set on them
+	lines map[int]bool
+
+	breakpoints map[int]bool
+
+	// the calls in progress, the first frame is the top level code
+	frames []DebugFrame
+
+	mode DebugMode
+
+	// the number of calls in progress when the program resumed
+	depth int
+
+	// whether the call being finished returned
+	finished bool
+
+	// the line of the previous statement and the number of calls in progress then, the statements of a line
+	// are one step
+	line      int
+	lineDepth int
+
+	// the program runs to completion without stopping
+	detached bool
+}
+
+// NewDebugger returns a debugger for the program `source`, it stops before the first statement of the program
+// unless its mode is changed
+func NewDebugger(source []rune) *Debugger {
+	return &Debugger{
+		source:      source,
+		structs:     make(map[string]*Type),
+		lines:       make(map[int]bool),
+		breakpoints: make(map[int]bool),
+		mode:        DEBUG_STEP,
+	}
+}
+
+// Run executes a type checked program in the debugger, a program stopped by the debugger fails with an error
+// caused by ErrDebuggerQuit
+func (d *Debugger) Run(ast Program, options RuntimeOptions) error {
+	for _, stmt := range ast {
+		if st, ok := stmt.(*StructStatement); ok {
+			d.structs[st.name.String()] = st.atype
+		}
+	}
+
+	options.observer = d
+	return Interpret(ast, d.source, options)
+}
+
+func (d *Debugger) Attach(interpreter *Interpreter, layout *ProgramLayout) {
+	d.interpreter, d.layout = interpreter, layout
+	d.frames = []DebugFrame{{name: "<top level>", layout: layout.script, frame: interpreter.frame}}
+
+	for stmt := range layout.positions {
+		if line := StatementPosition(stmt).line; line != 0 {
+			d.lines[line] = true
+		}
+	}
+
+	d.frontend.Attached()
+}
+
+// Top returns the innermost call in progress
+func (d *Debugger) Top() *DebugFrame {
+	return &d.frames[len(d.frames)-1]
+}
+
+func (d *Debugger) Statement(stmt Statement) {
+	d.frontend.Running()
+
+	position := StatementPosition(stmt)
+	if position.line == 0 {
+		// a block begins, its first statement starts a step even if it is on the same line, so that a loop
+		// written on one line stops on each iteration
+		d.line = 0
+		return
+	}
+
+	top := d.Top()
+	top.statement, top.frame = stmt, d.interpreter.frame
+
+	depth := len(d.frames)
+	if d.detached || (position.line == d.line && depth == d.lineDepth) {
+		return
+	}
+	d.line, d.lineDepth = position.line, depth
+
+	reason := "step"
+	switch {
+	case d.breakpoints[position.line]:
+		reason = "breakpoint"
+	case d.mode == DEBUG_STEP:
+	case d.mode == DEBUG_NEXT && depth <= d.depth:
+	case d.mode == DEBUG_FINISH && depth < d.depth:
+	default:
+		return
+	}
+
+	d.frontend.Stopped(reason, position)
+}
+
+func (d *Debugger) Call(function AspenFunction, arguments []interface{}, callSite Token) {
+	d.frames = append(d.frames, NewDebugFrame(function))
+}
+
+func (d *Debugger) TailCall(function AspenFunction, arguments []interface{}, callSite Token) {
+	d.frames[len(d.frames)-1] = NewDebugFrame(function)
+}
+
+func (d *Debugger) Return(value interface{}) {
+	frame := d.frames[len(d.frames)-1]
+	d.frames = d.frames[:len(d.frames)-1]
+
+	if d.mode == DEBUG_FINISH && len(d.frames) < d.depth && !d.finished && !d.detached {
+		d.finished = true
+		d.frontend.Returned(&frame, value)
+	}
+}
+
+func (d *Debugger) Assign(name Token, old interface{}, value interface{}) {}
+
+func (d *Debugger) Branch(stmt Statement, taken bool) {}
+
+func NewDebugFrame(function AspenFunction) DebugFrame {
+	frame := DebugFrame{name: function.Name()}
+
+	switch f := function.(type) {
+	case *UserFunction:
+		frame.layout = f.declaration.layout
+		frame.void = f.declaration.atype.returnType.IsVoid()
+	case *NativeFunction:
+		frame.void = f.atype.returnType.IsVoid()
+	}
+
+	return frame
+}
+
+// Resume lets the program run until the debugger stops it in `mode`
+func (d *Debugger) Resume(mode DebugMode) {
+	d.mode = mode
+	d.depth = len(d.frames)
+	d.finished = false
+}
+
+// Break sets a breakpoint, it returns false if no statement starts on `line`
+func (d *Debugger) Break(line int) bool {
+	if !d.lines[line] {
+		return false
+	}
+	d.breakpoints[line] = true
+	return true
+}
+
+// Variables returns the variables in scope at the statement a call is executing that the call can access, in
+// the order they were declared
+func (d *Debugger) Variables(frame *DebugFrame) []*Variable {
+	variables := []*Variable{}
+	scope := d.layout.positions[frame.statement].Variables()
+	for i := len(scope) - 1; i >= 0; i-- {
+		if _, ok := frame.Binding(scope[i]); ok {
+			variables = append(variables, scope[i])
+		}
+	}
+	return variables
+}
+
+// IsUserVariable reports whether a variable was declared by the program, rather than being a native or builtin
+// function
+func IsUserVariable(variable *Variable) bool {
+	return variable.atype != nil && NativeFunctions[variable.name] == nil
+}
+
+// Get returns the value of a variable that a call can access
+func (d *Debugger) Get(frame *DebugFrame, variable *Variable) interface{} {
+	binding, _ := frame.Binding(variable)
+
+	enclosing := d.interpreter.frame
+	d.interpreter.frame = frame.frame
+	value := d.interpreter.Get(&binding)
+	d.interpreter.frame = enclosing
+
+	return value
+}
+
+// Set assigns a variable that a call can access
+func (d *Debugger) Set(frame *DebugFrame, variable *Variable, value interface{}) {
+	binding, _ := frame.Binding(variable)
+
+	enclosing := d.interpreter.frame
+	d.interpreter.frame = frame.frame
+	d.interpreter.Set(&binding, value)
+	d.interpreter.frame = enclosing
+}
+
+// DebugExpression is an expression typed in the debugger, checked against the scope of the statement a call is
+// executing. Global variables are used in place, the other variables in scope are copied to the frame of the
+// expression and copied back once the expression has been evaluated.
+type DebugExpression struct {
+	source    []rune
+	expr      Expression
+	atype     *Type
+	layout    *FunctionLayout
+	variables []*Variable // the variables copied to the frame of the expression
+	copies    []*Variable // the copies, in the same order as `variables`
+}
+
+// Check parses and type checks an expression against the scope of the statement `frame` is executing
+func (d *Debugger) Check(frame *DebugFrame, text string) (*DebugExpression, error) {
+	source := []rune(strings.TrimSuffix(strings.TrimSpace(text), ";") + ";")
+	reporter := NewErrorReporter(source)
+
+	tokens, err := ScanTokens(source, reporter)
+	if err != nil {
+		return nil, err
+	}
+
+	ast, err := Parse(tokens, reporter)
+	if err != nil {
+		return nil, err
+	}
+
+	var stmt *ExpressionStatement
+	if len(ast) == 1 {
+		stmt, _ = ast[0].(*ExpressionStatement)
+	}
+	if stmt == nil {
+		return nil, errors.New("expected an expression.")
+	}
+
+	// the type checker and the resolver see the globals in an outer scope and the other variables in an inner one
+	globals := NewEnvironment(nil)
+	environment := NewEnvironment(&globals)
+
+	expression := &DebugExpression{source: source, expr: stmt.expr, layout: &FunctionLayout{}}
+	resolver := &Resolver{function: expression.layout}
+	resolver.scope = NewScope(NewScope(nil, nil), expression.layout)
+
+	for _, variable := range d.Variables(frame) {
+		if !variable.global {
+			environment.Define(variable.name, variable.atype)
+			expression.variables = append(expression.variables, variable)
+			expression.copies = append(expression.copies, resolver.Declare(variable.name, variable.atype))
+			continue
+		}
+
+		if builtin, ok := BuiltinFunctions[variable.name]; ok {
+			globals.Define(variable.name, builtin)
+		} else {
+			globals.Define(variable.name, variable.atype)
+		}
+		resolver.scope.enclosing.variables[variable.name] = variable
+	}
+
+	typeChecker := NewTypeChecker(reporter)
+	typeChecker.environment = environment
+	typeChecker.structs = d.structs
+	typeChecker.scopes = append(typeChecker.scopes, make(map[string]*FunctionStatement))
+
+	expression.atype = CheckExpression(typeChecker, stmt.expr)
+	if reporter.HadError() {
+		return nil, reporter
+	}
+
+	resolver.VisitStatementNode(stmt)
+	for _, binding := range expression.layout.locals {
+		if binding.variable.captured {
+			binding.kind = BINDING_CELL
+		}
+	}
+
+	return expression, nil
+}
+
+// CheckExpression type checks an expression, reporting its errors rather than panicking
+func CheckExpression(typeChecker *TypeChecker, expr Expression) (atype *Type) {
+	defer func() {
+		if r := recover(); r != nil {
+			switch v := r.(type) {
+			case ErrorData:
+				typeChecker.errorReporter.Push(v.line, v.col, v.message)
+			case ReportedError:
+			default:
+				panic(v)
+			}
+		}
+	}()
+
+	return typeChecker.VisitExpressionNode(expr).(*Type)
+}
+
+// Evaluate evaluates an expression in the scope of the statement `frame` is executing
+func (d *Debugger) Evaluate(frame *DebugFrame, text string) (interface{}, *Type, error) {
+	expression, err := d.Check(frame, text)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	slots := NewFrame(expression.layout, nil)
+	for i, variable := range expression.variables {
+		slots.Define(expression.copies[i], d.Get(frame, variable))
+	}
+
+	// the expression is not observed, and the calls it makes are the only calls in its call stack
+	interpreter := d.interpreter
+	saved := *interpreter
+	interpreter.frame, interpreter.observer, interpreter.callStack = slots, nil, nil
+
+	value, err := func() (value interface{}, err error) {
+		defer func() {
+			if r := recover(); r != nil {
+				runtimeError, ok := r.(*AspenRuntimeError)
+				if !ok {
+					panic(r)
+				}
+				runtimeError.source = expression.source
+				err = runtimeError
+			}
+		}()
+		return interpreter.VisitExpressionNode(expression.expr), nil
+	}()
+
+	*interpreter = saved
+
+	// the expression may have assigned the variables
+	for i, variable := range expression.variables {
+		copied := slots.slots[expression.copies[i].index]
+		if expression.copies[i].captured {
+			copied = copied.(*Cell).value
+		}
+		d.Set(frame, variable, copied)
+	}
+
+	if err != nil {
+		return nil, nil, err
+	}
+	return value, expression.atype, nil
+}
+
+// ErrorMessage returns the message of the first error reported by `err`
+func ErrorMessage(err error) string {
+	switch e := err.(type) {
+	case *AspenError:
+		return e.data[0].message
+	case *AspenRuntimeError:
+		return e.message
+	}
+	return err.Error()
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDebugger(t *testing.T) {
+	Initialize()
+
+	source := []rune(`fn fib(n i64) i64 {
+    if (n < 2) {
+        return n;
+    }
+    let a = fib(n - 1);
+    return a + fib(n - 2);
+}
+
+let total = 0;
+total = fib(3);
+let done = true;
+`)
+
+	tests := []struct {
+		name     string
+		commands string
+		expected string
+	}{
+		{"breakpoints", `break 5
+break 4
+continue
+locals
+print n * 2 + 1
+backtrace
+clear 5
+continue
+`, `stopped at 1:4 in <top level>
+    1 | fn fib(n i64) i64 {
+(aspen) breakpoint on line 5.
+(aspen) no statement starts on line 4.
+(aspen) breakpoint at 5:9 in fib
+    5 |     let a = fib(n - 1);
+(aspen)     n: i64 = 3
+(aspen) 7
+(aspen) fib at 5:9
+    fib, called at 10:14
+(aspen) cleared the breakpoint on line 5.
+(aspen) the program finished.
+`},
+		{"stepping", `next
+next
+step
+step
+next
+finish
+globals
+finish
+continue
+`, `stopped at 1:4 in <top level>
+    1 | fn fib(n i64) i64 {
+(aspen) stopped at 9:5 in <top level>
+    9 | let total = 0;
+(aspen) stopped at 10:1 in <top level>
+    10 | total = fib(3);
+(aspen) stopped at 2:8 in fib
+    2 |     if (n < 2) {
+(aspen) stopped at 5:9 in fib
+    5 |     let a = fib(n - 1);
+(aspen) stopped at 6:5 in fib
+    6 |     return a + fib(n - 2);
+(aspen) fib returned 2
+stopped at 11:5 in <top level>
+    11 | let done = true;
+(aspen)     fib: fn(i64)i64 = <fn fib>
+    total: i64 = 2
+(aspen) the program is not in a function.
+(aspen) the program finished.
+`},
+		{"watches", `break 6
+continue
+locals
+watch a + n
+watch a +
+print a = 10
+watch missing
+unwatch 1
+unwatch 1
+quit
+`, `stopped at 1:4 in <top level>
+    1 | fn fib(n i64) i64 {
+(aspen) breakpoint on line 6.
+(aspen) breakpoint at 6:5 in fib
+    6 |     return a + fib(n - 2);
+(aspen)     n: i64 = 2
+    a: i64 = 1
+(aspen) watch 1: a + n
+(aspen) error: expected expression.
+(aspen) 10
+(aspen) error: undeclared identifier 'missing'.
+(aspen) (aspen) no watch expression '1'.
+(aspen) `},
+	}
+
+	for _, test := range tests {
+		output := strings.Builder{}
+		console := NewDebugConsole(NewDebugger(source), strings.NewReader(test.commands), &output)
+
+		ast, err := TypeCheckSource(source)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := console.Run(ast, DefaultRuntimeOptions()); err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+
+		if output.String() != test.expected {
+			t.Errorf("%s: expected the output\n%s\ngot\n%s", test.name, test.expected, output.String())
+		}
+	}
+}
@@ -8,9 +8,19 @@ import (
 	"testing"
 )
 
+// backends are the command line flags selecting each implementation of aspen, every test case is run on all of them
+var backends = [][]string{{}, {"--bytecode"}}
+
 type End2EndTestCase struct {
 	fileName string
 	stdout   string
+
+	// the expected output on stderr, for programs that stop with a runtime error
+	stderr      string
+	shouldError bool
+
+	// the expected exit code of a program that stops with an error, zero accepts any nonzero exit code
+	exitCode int
 }
 
 func (tc *End2EndTestCase) Run(t *testing.T) {
@@ -18,19 +28,41 @@ func (tc *End2EndTestCase) Run(t *testing.T) {
 		return
 	}
 
-	cmd := exec.Command("./aspen", tc.fileName)
-	var out bytes.Buffer
+	for _, flags := range backends {
+		tc.RunWith(t, flags)
+	}
+}
+
+func (tc *End2EndTestCase) RunWith(t *testing.T, flags []string) {
+	args := append(append([]string{}, flags...), tc.fileName)
+	cmd := exec.Command("./aspen", args...)
+	var out, errOut bytes.Buffer
 	cmd.Stdout = &out
+	cmd.Stderr = &errOut
 	err := cmd.Run()
+
+	if tc.shouldError {
+		if err == nil {
+			t.Errorf("%s %v: expected aspen to exit with a nonzero exit code", tc.fileName, flags)
+		}
+		if exitErr, ok := err.(*exec.ExitError); ok && tc.exitCode != 0 && exitErr.ExitCode() != tc.exitCode {
+			t.Errorf("%s %v: expected exit code %d, got %d", tc.fileName, flags, tc.exitCode, exitErr.ExitCode())
+		}
+		if stderr := errOut.String(); stderr != tc.stderr {
+			t.Errorf("%s %v: expected stderr be be:\n%s\ngot:\n%s", tc.fileName, flags, tc.stderr, stderr)
+		}
+		return
+	}
+
 	if err != nil {
-		t.Errorf("%s: could not run aspen file: %v", tc.fileName, err)
+		t.Errorf("%s %v: could not run aspen file: %v", tc.fileName, flags, err)
 		return
 	}
 
 	stdout := out.String()
 
 	if stdout != tc.stdout {
-		t.Errorf("%s: expected stdout be be:\n%s\ngot:\n%s", tc.fileName, tc.stdout, stdout)
+		t.Errorf("%s %v: expected stdout be be:\n%s\ngot:\n%s", tc.fileName, flags, tc.stdout, stdout)
 	}
 }
 
@@ -52,6 +84,14 @@ func NewEnd2EndTestCase(file string, t *testing.T) *End2EndTestCase {
 	return &End2EndTestCase{fileName: file, stdout: stdout}
 }
 
+// NewRuntimeErrorTestCase reads a test case whose header comment is the expected output on stderr
+func NewRuntimeErrorTestCase(file string, t *testing.T) *End2EndTestCase {
+	tc := NewEnd2EndTestCase(file, t)
+	tc.stderr, tc.stdout = tc.stdout, ""
+	tc.shouldError = true
+	return tc
+}
+
 func TestEnd2End(t *testing.T) {
 	// Build aspen binary
 
@@ -74,3 +114,53 @@ func TestEnd2End(t *testing.T) {
 		tc.Run(t)
 	}
 }
+
+func TestRuntimeErrors(t *testing.T) {
+	cmd := exec.Command("go", "build")
+	err := cmd.Run()
+	if err != nil {
+		t.Fatalf("could not build aspen binary: %v", err)
+	}
+
+	matches, err := filepath.Glob("test_cases/runtime_error/*.aspen")
+
+	if err != nil {
+		t.Error("could not glob files")
+		return
+	}
+
+	for _, match := range matches {
+		fmt.Printf("%s\n", match)
+		tc := NewRuntimeErrorTestCase(match, t)
+		tc.Run(t)
+	}
+}
+
+func TestLimits(t *testing.T) {
+	cmd := exec.Command("go", "build")
+	err := cmd.Run()
+	if err != nil {
+		t.Fatalf("could not build aspen binary: %v", err)
+	}
+
+	limits := []struct {
+		file     string
+		flags    []string
+		exitCode int
+	}{
+		{"test_cases/limit/steps.aspen", []string{"--max-steps", "1000"}, EXIT_STEP_LIMIT},
+		{"test_cases/limit/timeout.aspen", []string{"--timeout", "100ms"}, EXIT_TIME_LIMIT},
+		{"test_cases/limit/allocation.aspen", []string{"--max-allocation", "1000000"}, EXIT_ALLOCATION_LIMIT},
+		{"test_cases/limit/allocation_map.aspen", []string{"--max-allocation", "1000000"}, EXIT_ALLOCATION_LIMIT},
+		{"test_cases/limit/allocation_constant.aspen", []string{"--max-allocation", "100"}, EXIT_ALLOCATION_LIMIT},
+	}
+
+	for _, limit := range limits {
+		fmt.Printf("%s\n", limit.file)
+		tc := NewRuntimeErrorTestCase(limit.file, t)
+		tc.exitCode = limit.exitCode
+		for _, backend := range backends {
+			tc.RunWith(t, append(append([]string{}, limit.flags...), backend...))
+		}
+	}
+}
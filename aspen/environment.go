@@ -1,21 +1,11 @@
 package main
 
+// Environment maps the names in scope to their types while type checking
 type Environment struct {
 	enclosing *Environment
 	values    map[string]interface{}
 }
 
-func NewGlobalEnvironment() Environment {
-	environment := NewEnvironment(nil)
-
-	// copy native functions into global environment
-	for k, v := range NativeFunctions {
-		environment.values[k] = v
-	}
-
-	return environment
-}
-
 func (e Environment) Define(name string, value interface{}) {
 	e.values[name] = value
 }
@@ -32,16 +22,6 @@ func (e Environment) Ancestor(depth int) Environment {
 	return environment
 }
 
-func (e Environment) AssignAt(name string, depth int, value interface{}) {
-	ancestor := e.Ancestor(depth)
-
-	if !ancestor.IsDefinedLocally(name) {
-		panic("Environment::AssignAt: name not defined")
-	}
-
-	ancestor.values[name] = value
-}
-
 func (e Environment) IsDefined(name string) bool {
 	_, ok := e.values[name]
 	if !ok && e.enclosing != nil {
@@ -73,3 +53,28 @@ func (e Environment) GetDepth(name string) int {
 func NewEnvironment(enclosing *Environment) Environment {
 	return Environment{values: make(map[string]interface{}), enclosing: enclosing}
 }
+
+// Cell holds a variable captured by a closure, it is shared by the frame that declares the variable and by
+// the closures that capture it
+type Cell struct {
+	value interface{}
+}
+
+// Frame holds the variables of a function call, or of the blocks of top level code
+type Frame struct {
+	slots    []interface{}
+	captured []*Cell // the variables of enclosing functions captured by the function being called
+}
+
+func NewFrame(layout *FunctionLayout, captured []*Cell) *Frame {
+	return &Frame{slots: make([]interface{}, layout.size), captured: captured}
+}
+
+// Define initializes the slot of a local variable
+func (f *Frame) Define(variable *Variable, value interface{}) {
+	if variable.captured {
+		f.slots[variable.index] = &Cell{value: value}
+	} else {
+		f.slots[variable.index] = value
+	}
+}
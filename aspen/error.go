@@ -1,6 +1,9 @@
 package main
 
-import "strings"
+import (
+	"fmt"
+	"strings"
+)
 
 type ErrorReporter interface {
 	Error() string
@@ -43,3 +46,54 @@ func (e *AspenError) Error() string {
 
 	return builder.String()
 }
+
+// StackFrame is a call to a user defined function that is in progress
+type StackFrame struct {
+	function string
+	callSite Token
+}
+
+// AspenRuntimeError is an error raised while executing a program. It carries the position the error occurred at
+// and the call stack at that time, innermost call first.
+type AspenRuntimeError struct {
+	source  []rune
+	line    int
+	col     int
+	message string
+	stack   []StackFrame
+
+	// the error that caused the runtime error, for a program that exceeded one of its limits
+	cause error
+}
+
+func (e *AspenRuntimeError) Unwrap() error {
+	return e.cause
+}
+
+// MAX_PRINTED_STACK_FRAMES is the number of the most recent calls shown in the call stack of a runtime error
+const MAX_PRINTED_STACK_FRAMES = 20
+
+func (e *AspenRuntimeError) Error() string {
+	builder := strings.Builder{}
+
+	if e.line == 0 {
+		// the error cannot be attributed to a position in the source code
+		fmt.Fprintf(&builder, "error: %s\n", e.message)
+	} else {
+		builder.WriteString(ErrorString(e.source, e.message, e.line, e.col))
+	}
+
+	if len(e.stack) != 0 {
+		builder.WriteString("\ncall stack:\n")
+		for i, frame := range e.stack {
+			if i == MAX_PRINTED_STACK_FRAMES {
+				// the stack of a runaway recursion is mostly the same frame repeated
+				fmt.Fprintf(&builder, "    ... %d more\n", len(e.stack)-i)
+				break
+			}
+			fmt.Fprintf(&builder, "    %s, called at %d:%d\n", frame.function, frame.callSite.line, frame.callSite.col)
+		}
+	}
+
+	return builder.String()
+}
@@ -0,0 +1,551 @@
+package main
+
+import (
+	"sort"
+	"strings"
+)
+
+const (
+	// lists of arguments and elements longer than this are broken over several lines
+	FORMAT_WIDTH  = 100
+	FORMAT_INDENT = "    "
+)
+
+// Formatter prints a program in the canonical format: statements on their own line indented by four spaces per
+// block, a single space around binary operators and after commas, and the literals, comments and empty lines of
+// the source code kept as they were written, except that consecutive empty lines are merged
+type Formatter struct {
+	source []rune
+	syntax *Syntax
+
+	// the offset of the start of each line of the source code
+	lines []int
+
+	indent int
+	// whether expressions are printed on one line whatever their length, to measure them
+	flat bool
+}
+
+func NewFormatter(source []rune, syntax *Syntax) *Formatter {
+	lines := []int{0}
+	for i, r := range source {
+		if r == '\n' {
+			lines = append(lines, i+1)
+		}
+	}
+	return &Formatter{source: source, syntax: syntax, lines: lines}
+}
+
+// FormatSource returns the source code of a program in the canonical format
+func FormatSource(source []rune) (string, error) {
+	tokens, err := ScanSource(source)
+	if err != nil {
+		return "", err
+	}
+
+	ast, syntax, err := ParseSyntax(tokens, NewErrorReporter(source))
+	if err != nil {
+		return "", err
+	}
+
+	return NewFormatter(source, syntax).Program(ast), nil
+}
+
+func (f *Formatter) Program(ast Program) string {
+	return f.Statements(ast, f.syntax.end)
+}
+
+func (f *Formatter) Indentation() string {
+	return strings.Repeat(FORMAT_INDENT, f.indent)
+}
+
+// Text returns the source code of a literal token, whose value does not tell how it was written
+func (f *Formatter) Text(token Token) string {
+	start := f.lines[token.line-1] + token.col - 1
+	end := start + 1
+
+	switch f.source[start] {
+	case '`':
+		for f.source[end] != '`' {
+			end++
+		}
+		end++
+	case '"', '}':
+		end = f.Segment(start)
+		if f.source[end] == '"' {
+			end++
+		}
+	default:
+		for end < len(f.source) && (IsDigit(f.source[end]) || f.source[end] == '.') {
+			end++
+		}
+	}
+	return string(f.source[start:end])
+}
+
+// Segment returns the end of the segment of a string starting at `start`, before its closing quote or the "${"
+// of an interpolated expression
+func (f *Formatter) Segment(start int) int {
+	end := start + 1
+	for {
+		switch {
+		case f.source[end] == '\\':
+			end += 2
+		case f.source[end] == '"', f.source[end] == '$' && f.source[end+1] == '{':
+			return end
+		default:
+			end++
+		}
+	}
+}
+
+func (f *Formatter) Comment(comment Comment) string {
+	token := comment.token
+	if f.source[f.lines[token.line-1]+token.col] == '*' {
+		return "/*" + token.value.(string) + "*/"
+	}
+	return strings.TrimRight("//"+token.value.(string), " \t\r")
+}
+
+// Members prints the statements of a block or the members of a struct with their comments, each on its own line,
+// followed by the comments before the closing brace
+func (f *Formatter) Members(nodes []interface{}, closing []Comment, member func(node interface{}) string) string {
+	builder := strings.Builder{}
+	indentation := f.Indentation()
+
+	separate := func(blank bool) {
+		if blank && builder.Len() != 0 {
+			builder.WriteRune('\n')
+		}
+	}
+
+	comments := func(comments []Comment) {
+		for _, comment := range comments {
+			separate(comment.blank)
+			builder.WriteString(indentation + f.Comment(comment) + "\n")
+		}
+	}
+
+	for _, node := range nodes {
+		comments(f.syntax.leading[node])
+		separate(f.syntax.blank[node])
+
+		builder.WriteString(indentation + member(node))
+		if comment, ok := f.syntax.trailing[node]; ok {
+			builder.WriteString(" " + f.Comment(comment))
+		}
+		builder.WriteRune('\n')
+	}
+	comments(closing)
+
+	return builder.String()
+}
+
+func (f *Formatter) Statements(statements []Statement, closing []Comment) string {
+	nodes := make([]interface{}, len(statements))
+	for i, stmt := range statements {
+		nodes[i] = stmt
+	}
+
+	return f.Members(nodes, closing, func(node interface{}) string {
+		return f.Statement(node.(Statement))
+	})
+}
+
+func (f *Formatter) Block(block *BlockStatement) string {
+	closing := f.syntax.closing[block]
+	if len(block.statements) == 0 && len(closing) == 0 {
+		return "{}"
+	}
+
+	f.indent++
+	statements := f.Statements(block.statements, closing)
+	f.indent--
+
+	return "{\n" + statements + f.Indentation() + "}"
+}
+
+func (f *Formatter) Statement(stmt Statement) string {
+	if loop, ok := f.syntax.loops[stmt]; ok {
+		return f.ForLoop(loop)
+	}
+
+	column := len(f.Indentation())
+
+	switch s := stmt.(type) {
+	case *ExpressionStatement:
+		return f.Expression(s.expr, column) + ";"
+	case *PrintStatement:
+		return "print " + f.Expression(s.expr, column+6) + ";"
+	case *LetStatement:
+		return f.Let(s)
+	case *BlockStatement:
+		return f.Block(s)
+	case *IfStatement:
+		text := "if (" + f.Expression(s.condition, column+4) + ") " + f.Block(s.thenBranch.(*BlockStatement))
+		switch elseBranch := s.elseBranch.(type) {
+		case *IfStatement:
+			text += " else " + f.Statement(elseBranch)
+		case *BlockStatement:
+			text += " else " + f.Block(elseBranch)
+		}
+		return text
+	case *WhileStatement:
+		label := f.Label(s.label)
+		return label + "while (" + f.Expression(s.condition, column+len(label)+7) + ") " + f.Block(s.body.(*BlockStatement))
+	case *FunctionStatement:
+		return "fn " + s.name.String() + f.Signature(s) + " " + f.Block(s.body)
+	case *ReturnStatement:
+		if s.value == nil {
+			return "return;"
+		}
+		if tuple, ok := s.value.(*TupleExpression); ok && tuple.loc.tokenType == TOKEN_RETURN {
+			// `return a, b;` is written without the parentheses of the tuple
+			elements := make([]string, len(tuple.elements))
+			for i, element := range tuple.elements {
+				elements[i] = f.Expression(element, column+7)
+			}
+			return "return " + strings.Join(elements, ", ") + ";"
+		}
+		return "return " + f.Expression(s.value, column+7) + ";"
+	case *BreakStatement:
+		return "break" + f.Jump(s.label) + ";"
+	case *ContinueStatement:
+		return "continue" + f.Jump(s.label) + ";"
+	case *StructStatement:
+		return f.Struct(s)
+	}
+
+	Unreachable("Formatter::Statement")
+	return ""
+}
+
+func (f *Formatter) Let(s *LetStatement) string {
+	text := "let " + s.name.String()
+	if s.names != nil {
+		names := make([]string, len(s.names))
+		for i, name := range s.names {
+			names[i] = name.String()
+		}
+		text = "let (" + strings.Join(names, ", ") + ")"
+	}
+
+	if !s.inferred {
+		text += " " + s.atype.String()
+	}
+
+	if s.initializer != nil {
+		text += " = " + f.Expression(s.initializer, len(f.Indentation())+len(text)+3)
+	}
+	return text + ";"
+}
+
+func (f *Formatter) Label(label *Token) string {
+	if label == nil {
+		return ""
+	}
+	return label.String() + ": "
+}
+
+func (f *Formatter) Jump(label *Token) string {
+	if label == nil {
+		return ""
+	}
+	return " " + label.String()
+}
+
+func (f *Formatter) ForLoop(loop *ForLoop) string {
+	label := f.Label(loop.label)
+	column := len(f.Indentation()) + len(label) + 5
+
+	if loop.iterable != nil {
+		variables := loop.key.String()
+		if loop.value != nil {
+			variables += ", " + loop.value.String()
+		}
+		return label + "for (" + variables + " in " + f.Expression(loop.iterable, column+len(variables)+4) + ") " + f.Block(loop.body)
+	}
+
+	header := ";"
+	if loop.initializer != nil {
+		header = f.Statement(loop.initializer)
+	}
+	if loop.condition != nil {
+		header += " " + f.Expression(loop.condition, column+len(header)+1)
+	}
+	header += ";"
+	if loop.increment != nil {
+		header += " " + f.Expression(loop.increment, column+len(header)+1)
+	}
+
+	return label + "for (" + header + ") " + f.Block(loop.body)
+}
+
+// Signature prints the parameters and the return type of a function
+func (f *Formatter) Signature(function *FunctionStatement) string {
+	parameters := make([]string, len(function.parameters))
+	for i, parameter := range function.parameters {
+		parameters[i] = parameter.String() + " " + function.atype.parameters[i].String()
+	}
+	return "(" + strings.Join(parameters, ", ") + ") " + function.atype.returnType.String()
+}
+
+// Struct prints the fields and the methods of a struct in the order they were declared
+func (f *Formatter) Struct(s *StructStatement) string {
+	members := []interface{}{}
+	types := make(map[Token]*Type)
+	for i, field := range s.fields {
+		members = append(members, field)
+		types[field] = s.atype.other.(*StructType).fields[i].atype
+	}
+	for _, method := range s.methods {
+		members = append(members, method)
+	}
+
+	position := func(member interface{}) Token {
+		if method, ok := member.(*FunctionStatement); ok {
+			return method.name
+		}
+		return member.(Token)
+	}
+	sort.SliceStable(members, func(i, j int) bool {
+		a, b := position(members[i]), position(members[j])
+		return a.line < b.line || a.line == b.line && a.col < b.col
+	})
+
+	closing := f.syntax.closing[s]
+	if len(members) == 0 && len(closing) == 0 {
+		return "struct " + s.name.String() + " {}"
+	}
+
+	f.indent++
+	body := f.Members(members, closing, func(member interface{}) string {
+		if method, ok := member.(*FunctionStatement); ok {
+			return f.Statement(method)
+		}
+		field := member.(Token)
+		return field.String() + " " + types[field].String() + ";"
+	})
+	f.indent--
+
+	return "struct " + s.name.String() + " {\n" + body + f.Indentation() + "}"
+}
+
+// List prints a list of arguments or elements between `open` and `close`, one per line if it does not fit in the
+// remaining width
+func (f *Formatter) List(open string, items []func(column int) string, close string, column int) string {
+	elements := make([]string, len(items))
+	for i, item := range items {
+		elements[i] = item(column + len(open))
+	}
+
+	inline := open + strings.Join(elements, ", ") + close
+	if len(items) == 0 || f.flat || column+len(FirstLine(inline)) <= FORMAT_WIDTH {
+		return inline
+	}
+
+	f.indent++
+	indentation := f.Indentation()
+	for i, item := range items {
+		elements[i] = indentation + item(len(indentation))
+	}
+	f.indent--
+
+	return open + "\n" + strings.Join(elements, ",\n") + "\n" + f.Indentation() + close
+}
+
+// Literal prints the entries of a slice, map or struct literal between braces, one per line with their comments
+// if comments are attached to them. `values` are the values of the entries, which the comments are attached to.
+func (f *Formatter) Literal(literal Expression, values []Expression, items []func(column int) string, column int) string {
+	closing := f.syntax.closing[literal]
+
+	nodes := make([]interface{}, len(values))
+	index := make(map[interface{}]int)
+	commented := len(closing) != 0
+	for i, value := range values {
+		nodes[i] = value
+		index[value] = i
+		_, trailing := f.syntax.trailing[value]
+		commented = commented || trailing || len(f.syntax.leading[value]) != 0
+	}
+
+	if !commented || f.flat {
+		return f.List("{", items, "}", column)
+	}
+
+	f.indent++
+	entries := f.Members(nodes, closing, func(node interface{}) string {
+		i := index[node]
+		if i == len(items)-1 {
+			return items[i](len(f.Indentation()))
+		}
+		return items[i](len(f.Indentation())) + ","
+	})
+	f.indent--
+
+	return "{\n" + entries + f.Indentation() + "}"
+}
+
+func FirstLine(text string) string {
+	if i := strings.IndexRune(text, '\n'); i >= 0 {
+		return text[:i]
+	}
+	return text
+}
+
+// Items returns the functions printing each expression of a list
+func (f *Formatter) Items(expressions []Expression) []func(column int) string {
+	items := make([]func(column int) string, len(expressions))
+	for i, expr := range expressions {
+		expr := expr
+		items[i] = func(column int) string {
+			return f.Expression(expr, column)
+		}
+	}
+	return items
+}
+
+// Entries returns the functions printing each `key: value` entry of a map or struct literal
+func (f *Formatter) Entries(keys []string, values []Expression) []func(column int) string {
+	items := make([]func(column int) string, len(values))
+	for i, value := range values {
+		key, value := keys[i], value
+		items[i] = func(column int) string {
+			return key + ": " + f.Expression(value, column+len(key)+2)
+		}
+	}
+	return items
+}
+
+// Expression prints an expression starting at `column`, which decides whether its lists fit on the line
+func (f *Formatter) Expression(expr Expression, column int) string {
+	if interpolation, ok := f.syntax.interpolations[expr]; ok {
+		return f.Interpolation(interpolation, column)
+	}
+
+	switch e := expr.(type) {
+	case *BinaryExpression:
+		operator := " " + e.operator.String()
+		if f.flat || column+len(FirstLine(f.Flat(e))) <= FORMAT_WIDTH {
+			left := f.Expression(e.left, column)
+			return left + operator + " " + f.Expression(e.right, column+len(left)+len(operator)+1)
+		}
+
+		// a binary expression that does not fit is broken after its outermost operator before the lists it
+		// contains are, the right operand continues on the next line indented once more
+		left := f.Expression(e.left, column)
+		f.indent++
+		indentation := f.Indentation()
+		right := f.Expression(e.right, len(indentation))
+		f.indent--
+		return left + operator + "\n" + indentation + right
+	case *UnaryExpression:
+		return e.operator.String() + f.Expression(e.operand, column+1)
+	case *LiteralExpression:
+		switch e.value.tokenType {
+		case TOKEN_INT_LITERAL, TOKEN_FLOAT_LITERAL, TOKEN_STRING_LITERAL:
+			return f.Text(e.value)
+		}
+		return e.value.String()
+	case *GroupingExpression:
+		return "(" + f.Expression(e.expr, column+1) + ")"
+	case *IdentifierExpression:
+		return e.name.String()
+	case *AssignmentExpression:
+		name := e.name.String()
+		return name + " = " + f.Expression(e.value, column+len(name)+3)
+	case *CallExpression:
+		callee := f.Expression(e.callee, column)
+		return callee + f.List("(", f.Items(e.arguments), ")", column+len(callee))
+	case *TypeCastExpression:
+		to := e.to.String()
+		return to + "(" + f.Expression(e.value, column+len(to)+1) + ")"
+	case *SubscriptExpression:
+		object := f.Expression(e.object, column)
+		return object + "[" + f.Expression(e.index, column+len(object)+1) + "]"
+	case *SubscriptAssignmentExpression:
+		target := f.Expression(e.target, column)
+		return target + " = " + f.Expression(e.value, column+len(target)+3)
+	case *SliceLiteralExpression:
+		atype := e.atype.String()
+		return atype + f.Literal(e, e.elements, f.Items(e.elements), column+len(atype))
+	case *FieldExpression:
+		return f.Expression(e.object, column) + "." + e.name.String()
+	case *FieldAssignmentExpression:
+		target := f.Expression(e.target, column)
+		return target + " = " + f.Expression(e.value, column+len(target)+3)
+	case *StructLiteralExpression:
+		fields := make([]string, len(e.fields))
+		for i, field := range e.fields {
+			fields[i] = field.String()
+		}
+		atype := e.atype.String()
+		return atype + f.Literal(e, e.values, f.Entries(fields, e.values), column+len(atype))
+	case *MapLiteralExpression:
+		keys := make([]string, len(e.keys))
+		for i, key := range e.keys {
+			keys[i] = f.Expression(key, column)
+		}
+		atype := e.atype.String()
+		return atype + f.Literal(e, e.values, f.Entries(keys, e.values), column+len(atype))
+	case *TupleExpression:
+		return f.List("(", f.Items(e.elements), ")", column)
+	case *FunctionLiteralExpression:
+		return f.FunctionLiteral(e.function, column)
+	}
+
+	Unreachable("Formatter::Expression")
+	return ""
+}
+
+// Flat prints an expression on one line, except for the bodies of its function literals, to tell whether it fits
+func (f *Formatter) Flat(expr Expression) string {
+	flat := f.flat
+	f.flat = true
+	text := f.Expression(expr, 0)
+	f.flat = flat
+	return text
+}
+
+// FunctionLiteral prints a function literal, on one line if it was written on one line and its body is a single
+// simple statement that fits
+func (f *Formatter) FunctionLiteral(function *FunctionStatement, column int) string {
+	header := "fn" + f.Signature(function) + " "
+
+	body := function.body
+	if len(body.statements) == 1 && len(f.syntax.closing[body]) == 0 && StatementPosition(body.statements[0]).line == function.name.line {
+		stmt := body.statements[0]
+		_, commented := f.syntax.leading[stmt]
+		_, trailing := f.syntax.trailing[stmt]
+
+		switch stmt.(type) {
+		case *ReturnStatement, *ExpressionStatement, *PrintStatement:
+			if text := f.Statement(stmt); !commented && !trailing && !strings.Contains(text, "\n") {
+				if inline := header + "{ " + text + " }"; column+len(inline) <= FORMAT_WIDTH {
+					return inline
+				}
+			}
+		}
+	}
+
+	return header + f.Block(body)
+}
+
+func (f *Formatter) Interpolation(interpolation *Interpolation, column int) string {
+	builder := strings.Builder{}
+	builder.WriteRune('"')
+
+	for i, segment := range interpolation.segments {
+		start := f.lines[segment.line-1] + segment.col
+		builder.WriteString(string(f.source[start:f.Segment(start-1)]))
+
+		if i < len(interpolation.values) {
+			builder.WriteString("${")
+			builder.WriteString(f.Expression(interpolation.values[i], column+builder.Len()))
+			builder.WriteRune('}')
+		}
+	}
+
+	builder.WriteRune('"')
+	return builder.String()
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFormat(t *testing.T) {
+	tests := []struct {
+		source   string
+		expected string
+	}{
+		{`/* header
+   kept as written */
+
+// a point
+struct Point {
+    x i64; // the x coordinate
+    y i64;
+
+    // the distance to the origin
+    fn length() double { return 0.0; }
+    // nothing after the methods
+}
+struct Empty {}
+
+
+fn divide(a i64,b i64) (i64,i64) {
+    return a/b,a%b;
+}
+
+let (q, r) = divide(7,2);
+let s = "q = ${q}, r = ${r + 1}\n";
+let raw = ` + "`" + `line \n
+next` + "`" + `;
+outer: for (let i=0;i<3;i=i+1) {
+    for (;;) { break outer; }
+}
+for (key, value in map[string]i64{"a": 1}) {
+    if (value > 1) { print key; } else if (value == 1) {
+        // one
+    } else { continue; }
+}
+let total = someFunction(firstArgument, secondArgument, thirdArgument, fourthArgument, 1.50);
+let f = fn(x i64) i64 { return x*2; }; // double
+print f(-q) + i64(2.5) + Point{x: 1, y: 2}.x + (1, 2); /* trailing
+block */
+while (false) {
+    // empty
+}
+// the end
+`, `/* header
+   kept as written */
+
+// a point
+struct Point {
+    x i64; // the x coordinate
+    y i64;
+
+    // the distance to the origin
+    fn length() double {
+        return 0.0;
+    }
+    // nothing after the methods
+}
+struct Empty {}
+
+fn divide(a i64, b i64) (i64, i64) {
+    return a / b, a % b;
+}
+
+let (q, r) = divide(7, 2);
+let s = "q = ${q}, r = ${r + 1}\n";
+let raw = ` + "`" + `line \n
+next` + "`" + `;
+outer: for (let i = 0; i < 3; i = i + 1) {
+    for (;;) {
+        break outer;
+    }
+}
+for (key, value in map[string]i64{"a": 1}) {
+    if (value > 1) {
+        print key;
+    } else if (value == 1) {
+        // one
+    } else {
+        continue;
+    }
+}
+let total = someFunction(firstArgument, secondArgument, thirdArgument, fourthArgument, 1.50);
+let f = fn(x i64) i64 { return x * 2; }; // double
+print f(-q) + i64(2.5) + Point{x: 1, y: 2}.x + (1, 2); /* trailing
+block */
+while (false) {
+    // empty
+}
+// the end
+`},
+		{`fn main() void {
+    let total = someFunction(firstArgument, secondArgument, thirdArgument, fourthArgument, fifthArgument);
+    let points = Point[]{Point{x: 1, y: 2}, Point{x: 3, y: 4}, Point{x: 5, y: 6}, Point{x: 7, y: 8}, Point{x: 9, y: 10}};
+    call(a, // inside
+        b);
+    if (x) { // after the brace
+        y();
+    } // after the block
+}
+`, `fn main() void {
+    let total = someFunction(
+        firstArgument,
+        secondArgument,
+        thirdArgument,
+        fourthArgument,
+        fifthArgument
+    );
+    let points = Point[]{
+        Point{x: 1, y: 2},
+        Point{x: 3, y: 4},
+        Point{x: 5, y: 6},
+        Point{x: 7, y: 8},
+        Point{x: 9, y: 10}
+    };
+    // inside
+    call(a, b);
+    if (x) {
+        // after the brace
+        y();
+    } // after the block
+}
+`},
+		{`let total = firstFunction(1, 2) + secondFunction(3, 4) + thirdFunction(5, 6) + fourthFunction(7, 8, 9);
+print total * 2 + someFunction(firstArgument, secondArgument, thirdArgument, fourthArgument, fifthArgument);
+`, `let total = firstFunction(1, 2) + secondFunction(3, 4) + thirdFunction(5, 6) +
+    fourthFunction(7, 8, 9);
+print total * 2 +
+    someFunction(firstArgument, secondArgument, thirdArgument, fourthArgument, fifthArgument);
+`},
+		{`let m = map[string]i64{
+    // the first
+    "a": 1,   // one
+
+    "b": 2 // two
+    // no more
+};
+let points = Point[]{Point{x: 1, // x
+    y: 2}};
+let empty = i64[]{ // none yet
+};
+`, `let m = map[string]i64{
+    // the first
+    "a": 1, // one
+
+    "b": 2 // two
+    // no more
+};
+let points = Point[]{Point{
+    x: 1, // x
+    y: 2
+}};
+let empty = i64[]{
+    // none yet
+};
+`},
+	}
+
+	for _, test := range tests {
+		formatted, err := FormatSource([]rune(test.source))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if formatted != test.expected {
+			t.Errorf("expected\n%s\ngot\n%s", test.expected, formatted)
+		}
+	}
+}
+
+// TestFormatFiles formats the end to end test cases and the examples, which must keep their syntax tree and be
+// formatted again unchanged
+func TestFormatFiles(t *testing.T) {
+	e2e, _ := filepath.Glob("test_cases/e2e/*.aspen")
+	examples, _ := filepath.Glob("../examples/*.aspen")
+
+	for _, path := range append(e2e, examples...) {
+		bytes, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		source := []rune(string(bytes))
+
+		formatted, err := FormatSource(source)
+		if err != nil {
+			t.Errorf("%s: %v", path, err)
+			continue
+		}
+
+		again, err := FormatSource([]rune(formatted))
+		if err != nil {
+			t.Errorf("%s: the formatted source code does not parse: %v", path, err)
+			continue
+		}
+		if again != formatted {
+			t.Errorf("%s: formatting is not idempotent, expected\n%s\ngot\n%s", path, formatted, again)
+		}
+
+		before, _ := ParseSource(source)
+		after, _ := ParseSource([]rune(formatted))
+		if before.String() != after.String() {
+			t.Errorf("%s: formatting changed the syntax tree from\n%v\nto\n%v", path, before, after)
+		}
+	}
+}
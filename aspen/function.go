@@ -5,10 +5,12 @@ import "fmt"
 type AspenFunction interface {
 	Arity() int
 	Call(interpreter *Interpreter, args []interface{}) interface{}
+	Name() string
 	String() string
 }
 
 type NativeFunction struct {
+	name  string
 	atype FunctionType
 	impl  func([]interface{}) interface{}
 }
@@ -21,55 +23,111 @@ func (f *NativeFunction) Call(interpreter *Interpreter, args []interface{}) inte
 	return f.impl(args)
 }
 
+func (f *NativeFunction) Name() string {
+	return f.name
+}
+
 func (f *NativeFunction) String() string {
 	return "<native fn>"
 }
 
 type UserFunction struct {
 	declaration *FunctionStatement
-	closure     Environment
-}
-
-type ReturnValue struct {
-	value interface{}
+	captured    []*Cell
 }
 
 func (f *UserFunction) Arity() int {
 	return f.declaration.atype.Arity()
 }
 
-func (f *UserFunction) Call(interpreter *Interpreter, args []interface{}) (ret interface{}) {
-	defer func() {
-		if r := recover(); r != nil {
-			switch v := r.(type) {
-			case ReturnValue:
-				// recover from any calls to panic with an argument of type `ReturnValue`
-				// override the return value
-				ret = v.value
-			default:
-				// else re-panic
-				panic(v)
+func (f *UserFunction) Call(interpreter *Interpreter, args []interface{}) interface{} {
+	for {
+		// the arguments were allocated with room for the whole frame by Interpreter.EvaluateCall
+		frame := &Frame{slots: args[:f.declaration.layout.size], captured: f.captured}
+
+		for i, parameter := range f.declaration.layout.parameters {
+			if parameter.captured {
+				frame.Define(parameter, args[i])
 			}
 		}
-	}()
 
-	environment := NewEnvironment(&f.closure)
+		switch interpreter.ExecuteBlock(f.declaration.body, frame) {
+		case COMPLETION_RETURN:
+			return interpreter.TakeReturnValue()
+		case COMPLETION_TAIL_CALL:
+			// run the tail call in a loop rather than recursively, so that the Go stack does not grow
+			f, args = interpreter.TakeTailCall()
+			continue
+		}
 
-	for i := range f.declaration.parameters {
-		environment.Define(f.declaration.parameters[i].String(), args[i])
+		return nil
 	}
+}
 
-	interpreter.ExecuteBlock(f.declaration.body, environment)
+// Name returns the name of the function as it appears in a call stack
+func (f *UserFunction) Name() string {
+	return FunctionName(f.declaration)
+}
 
-	return nil
+// FunctionName returns the name of a declared function
+func FunctionName(declaration *FunctionStatement) string {
+	if declaration.name.tokenType == TOKEN_FN {
+		// function literals are named after their "fn" keyword
+		return "<anonymous fn>"
+	}
+	return declaration.name.String()
 }
 
 func (f *UserFunction) String() string {
+	if f.declaration.name.tokenType == TOKEN_FN {
+		return "<fn>"
+	}
 	return fmt.Sprintf("<fn %v>", f.declaration.name)
 }
 
 var NativeFunctions = make(map[string]*NativeFunction)
 
 func DefineNativeFunction(atype FunctionType, name string, impl func([]interface{}) interface{}) {
-	NativeFunctions[name] = &NativeFunction{atype: atype, impl: impl}
+	NativeFunctions[name] = &NativeFunction{name: name, atype: atype, impl: impl}
+}
+
+// BuiltinFunction is a native function whose signature cannot be expressed as a `FunctionType`, such as `len`
+// which accepts a slice of any type. Instead of a type, a builtin carries its own type checking function.
+// Builtins are not first class values, they can only be called.
+type BuiltinFunction struct {
+	name  string
+	check func(tc *TypeChecker, expr *CallExpression, arguments []*Type) *Type
+	impl  func([]interface{}) interface{}
+}
+
+func (f *BuiltinFunction) Arity() int {
+	// builtins may be variadic, the number of arguments is validated by `check`
+	return -1
+}
+
+func (f *BuiltinFunction) Call(interpreter *Interpreter, args []interface{}) interface{} {
+	return f.impl(args)
+}
+
+func (f *BuiltinFunction) Name() string {
+	return f.name
+}
+
+func (f *BuiltinFunction) String() string {
+	return fmt.Sprintf("<builtin fn %s>", f.name)
+}
+
+var BuiltinFunctions = make(map[string]*BuiltinFunction)
+
+// DefineBuiltinFunction defines a builtin under its name and under its reserved name, see `ReservedBuiltin`
+func DefineBuiltinFunction(name string, check func(tc *TypeChecker, expr *CallExpression, arguments []*Type) *Type, impl func([]interface{}) interface{}) {
+	builtin := &BuiltinFunction{name: name, check: check, impl: impl}
+	BuiltinFunctions[name] = builtin
+	BuiltinFunctions[ReservedBuiltin(name)] = builtin
+}
+
+// ReservedBuiltin returns the name the parser uses to call a builtin from desugared code. Identifiers cannot contain
+// "$", so unlike the name of the builtin it cannot be shadowed by a variable of the program.
+func ReservedBuiltin(name string) string {
+	return "$builtin_" + name
 }
@@ -1,7 +1,127 @@
 package main
 
+import "fmt"
+
+// Completion is how the execution of a statement ended. Statements that return, break or continue end
+// the execution of the enclosing statements until the function or loop they target is reached.
+type Completion uint8
+
+const (
+	COMPLETION_NORMAL Completion = iota
+	COMPLETION_RETURN
+	COMPLETION_BREAK
+	COMPLETION_CONTINUE
+	COMPLETION_TAIL_CALL
+)
+
+// TailCall is a call in tail position, the function making it is replaced by the function it calls
+type TailCall struct {
+	function  *UserFunction
+	arguments []interface{}
+	call      *CallExpression
+}
+
 type Interpreter struct {
-	environment Environment
+	globals  []interface{}
+	frame    *Frame
+	options  RuntimeOptions
+	budget   Budget
+	observer Observer
+
+	// the value of the return statement being completed
+	returnValue interface{}
+
+	// the loop targeted by the break or continue statement being completed
+	target *WhileStatement
+
+	// the call made by the return statement being completed with COMPLETION_TAIL_CALL
+	tailCall TailCall
+
+	// the calls to user defined functions in progress, outermost call first
+	callStack []StackFrame
+}
+
+func (i *Interpreter) RuntimeError(token Token, message string) {
+	panic(&AspenRuntimeError{line: token.line, col: token.col, message: message, stack: i.StackTrace()})
+}
+
+// LimitError stops the program because it exceeded one of its limits
+func (i *Interpreter) LimitError(token Token, err error) {
+	panic(&AspenRuntimeError{
+		line:    token.line,
+		col:     token.col,
+		message: i.budget.Message(err),
+		stack:   i.StackTrace(),
+		cause:   err,
+	})
+}
+
+func (i *Interpreter) Step(token Token) {
+	if err := i.budget.Step(); err != nil {
+		i.LimitError(token, err)
+	}
+}
+
+func (i *Interpreter) Allocate(token Token, bytes int64) {
+	if err := i.budget.Allocate(bytes); err != nil {
+		i.LimitError(token, err)
+	}
+}
+
+// StackTrace returns a copy of the call stack, innermost call first
+func (i *Interpreter) StackTrace() []StackFrame {
+	trace := make([]StackFrame, len(i.callStack))
+	for j := range i.callStack {
+		trace[j] = i.callStack[len(i.callStack)-1-j]
+	}
+	return trace
+}
+
+func (i *Interpreter) Get(binding *Binding) interface{} {
+	switch binding.kind {
+	case BINDING_LOCAL:
+		return i.frame.slots[binding.index]
+	case BINDING_CELL:
+		return i.frame.slots[binding.index].(*Cell).value
+	case BINDING_CAPTURED:
+		return i.frame.captured[binding.index].value
+	}
+	return i.globals[binding.index]
+}
+
+func (i *Interpreter) Set(binding *Binding, value interface{}) {
+	switch binding.kind {
+	case BINDING_LOCAL:
+		i.frame.slots[binding.index] = value
+	case BINDING_CELL:
+		i.frame.slots[binding.index].(*Cell).value = value
+	case BINDING_CAPTURED:
+		i.frame.captured[binding.index].value = value
+	case BINDING_GLOBAL:
+		i.globals[binding.index] = value
+	}
+}
+
+func (i *Interpreter) Define(variable *Variable, value interface{}) {
+	if variable.global {
+		i.globals[variable.index] = value
+	} else {
+		i.frame.Define(variable, value)
+	}
+}
+
+// NewClosure creates a function, capturing the variables it uses from the current frame
+func (i *Interpreter) NewClosure(declaration *FunctionStatement) *UserFunction {
+	captures := declaration.layout.captures
+	captured := make([]*Cell, len(captures))
+	for j, capture := range captures {
+		if capture.local {
+			captured[j] = i.frame.slots[capture.index].(*Cell)
+		} else {
+			captured[j] = i.frame.captured[capture.index]
+		}
+	}
+	return &UserFunction{declaration: declaration, captured: captured}
 }
 
 func (i *Interpreter) VisitExpressionNode(expr Expression) interface{} {
@@ -12,15 +132,49 @@ func (i *Interpreter) VisitStatementNode(stmt Statement) interface{} {
 	return stmt.Accept(i)
 }
 
+// Execute executes a statement and returns how it completed
+func (i *Interpreter) Execute(stmt Statement) Completion {
+	if i.observer != nil {
+		i.observer.Statement(stmt)
+	}
+	return stmt.Accept(i).(Completion)
+}
+
+// TakeTailCall returns the function and the arguments of the completed tail call, which replaces the
+// innermost call in the call stack
+func (i *Interpreter) TakeTailCall() (*UserFunction, []interface{}) {
+	tailCall := i.tailCall
+	i.tailCall = TailCall{}
+
+	frame := &i.callStack[len(i.callStack)-1]
+	frame.function, frame.callSite = tailCall.function.Name(), tailCall.call.loc
+	if i.observer != nil {
+		i.observer.TailCall(tailCall.function, tailCall.arguments, tailCall.call.loc)
+	}
+	return tailCall.function, tailCall.arguments
+}
+
+// TakeReturnValue returns the value of the completed return statement
+func (i *Interpreter) TakeReturnValue() interface{} {
+	value := i.returnValue
+	i.returnValue = nil
+	return value
+}
+
 func (i *Interpreter) VisitBinary(expr *BinaryExpression) interface{} {
 	lhs := i.VisitExpressionNode(expr.left)
-	rhs := i.VisitExpressionNode(expr.right)
 
+	// && and || short circuit, the type checker relies on it to narrow optionals in the right operand
 	switch expr.operator.tokenType {
 	case TOKEN_AMP_AMP:
-		return lhs.(bool) && rhs.(bool)
+		return lhs.(bool) && i.VisitExpressionNode(expr.right).(bool)
 	case TOKEN_PIPE_PIPE:
-		return lhs.(bool) || rhs.(bool)
+		return lhs.(bool) || i.VisitExpressionNode(expr.right).(bool)
+	}
+
+	rhs := i.VisitExpressionNode(expr.right)
+
+	switch expr.operator.tokenType {
 	case TOKEN_EQUAL_EQUAL:
 		return ValuesEqual(lhs, rhs)
 	case TOKEN_BANG_EQUAL:
@@ -38,12 +192,14 @@ func (i *Interpreter) VisitBinary(expr *BinaryExpression) interface{} {
 	case TOKEN_CARET:
 		return OperatorCaret(lhs, rhs)
 	case TOKEN_PERCENT:
+		i.CheckDivisor(expr.operator, rhs)
 		return OperatorModulus(lhs, rhs)
 	case TOKEN_AMP:
 		return OperatorAmp(lhs, rhs)
 	case TOKEN_MINUS:
 		return OperatorMinus(lhs, rhs)
 	case TOKEN_SLASH:
+		i.CheckDivisor(expr.operator, rhs)
 		return OperatorSlash(lhs, rhs)
 	case TOKEN_STAR:
 		return OperatorStar(lhs, rhs)
@@ -51,6 +207,7 @@ func (i *Interpreter) VisitBinary(expr *BinaryExpression) interface{} {
 		switch lhsV := lhs.(type) {
 		case []rune:
 			rhsV := rhs.([]rune)
+			i.Allocate(expr.operator, int64(len(lhsV)+len(rhsV))*STRING_CHAR_SIZE)
 			return AddString(lhsV, rhsV)
 		default:
 			return OperatorPlus(lhs, rhs)
@@ -61,26 +218,74 @@ func (i *Interpreter) VisitBinary(expr *BinaryExpression) interface{} {
 	return nil
 }
 
+// IsIntegerZero reports whether the divisor `rhs` makes a division fail, floating point division by zero is
+// well defined
+func IsIntegerZero(rhs interface{}) bool {
+	switch v := rhs.(type) {
+	case int64:
+		return v == 0
+	case uint64:
+		return v == 0
+	}
+	return false
+}
+
+func (i *Interpreter) CheckDivisor(operator Token, rhs interface{}) {
+	if IsIntegerZero(rhs) {
+		i.RuntimeError(operator, "integer division by zero.")
+	}
+}
+
+// SliceIndex converts `value` to an index into a slice of length `length`, reporting whether it is in range
+func SliceIndex(value interface{}, length int) (index int, inRange bool) {
+	switch v := value.(type) {
+	case int64:
+		return int(v), v >= 0 && v < int64(length)
+	case uint64:
+		return int(v), v < uint64(length)
+	}
+	return 0, false
+}
+
+func IndexOutOfRangeMessage(value interface{}, length int) string {
+	return fmt.Sprintf("index out of range [%v] with length %d.", value, length)
+}
+
+func KeyNotFoundMessage(key interface{}) string {
+	format := "key %v not found in map."
+	if _, isString := key.([]rune); isString {
+		format = "key %q not found in map."
+	}
+	return fmt.Sprintf(format, FormatValue(key))
+}
+
 func (i *Interpreter) VisitUnary(expr *UnaryExpression) interface{} {
 	operand := i.VisitExpressionNode(expr.operand)
 	switch expr.operator.tokenType {
 	case TOKEN_BANG:
 		return !operand.(bool)
 	case TOKEN_MINUS:
-		switch v := operand.(type) {
-		case int64:
-			return -v
-		case uint64:
-			return -v
-		case float64:
-			return -v
-		}
+		return Negate(operand)
 	}
 
 	Unreachable("Interpreter::VisitUnary")
 	return nil
 }
 
+func Negate(value interface{}) interface{} {
+	switch v := value.(type) {
+	case int64:
+		return -v
+	case uint64:
+		return -v
+	case float64:
+		return -v
+	}
+
+	Unreachable("interpreter.go: Negate")
+	return nil
+}
+
 func (i *Interpreter) VisitLiteral(expr *LiteralExpression) interface{} {
 	switch expr.value.tokenType {
 	case TOKEN_FALSE:
@@ -92,7 +297,13 @@ func (i *Interpreter) VisitLiteral(expr *LiteralExpression) interface{} {
 	case TOKEN_FLOAT_LITERAL:
 		return expr.value.value.(float64)
 	case TOKEN_STRING_LITERAL:
-		return expr.value.value.([]rune)
+		value := expr.value.value.([]rune)
+		if err := i.budget.AllocateConstant(expr, value); err != nil {
+			i.LimitError(expr.value, err)
+		}
+		return value
+	case TOKEN_NIL:
+		return nil
 	}
 
 	Unreachable("Interpreter::VisitLiteral")
@@ -100,7 +311,14 @@ func (i *Interpreter) VisitLiteral(expr *LiteralExpression) interface{} {
 }
 
 func (i *Interpreter) VisitIdentifier(expr *IdentifierExpression) interface{} {
-	return i.environment.GetAt(expr.name.String(), expr.depth)
+	value := i.Get(&expr.binding)
+
+	// a narrowed optional can still be nil if it was assigned in a closure or a later loop iteration
+	if expr.narrowed && value == nil {
+		i.RuntimeError(expr.name, fmt.Sprintf("'%s' is nil.", expr.name))
+	}
+
+	return value
 }
 
 func (i *Interpreter) VisitGrouping(expr *GroupingExpression) interface{} {
@@ -109,19 +327,74 @@ func (i *Interpreter) VisitGrouping(expr *GroupingExpression) interface{} {
 
 func (i *Interpreter) VisitAssignment(expr *AssignmentExpression) interface{} {
 	value := i.VisitExpressionNode(expr.value)
-	i.environment.AssignAt(expr.name.String(), expr.depth, value)
+	if i.observer != nil {
+		i.observer.Assign(expr.name, i.Get(&expr.binding), value)
+	}
+	i.Set(&expr.binding, value)
 	return value
 }
 
-func (i *Interpreter) VisitCall(expr *CallExpression) interface{} {
-	callee := i.VisitExpressionNode(expr.callee).(AspenFunction)
+// EvaluateCall evaluates the callee and the arguments of a call
+func (i *Interpreter) EvaluateCall(expr *CallExpression) (AspenFunction, []interface{}) {
+	callee, ok := i.VisitExpressionNode(expr.callee).(AspenFunction)
+	if !ok {
+		i.RuntimeError(expr.loc, "cannot call a nil function.")
+	}
+
+	var arguments []interface{}
+	if function, ok := callee.(*UserFunction); ok {
+		// the arguments become the first slots of the frame of the call, see UserFunction.Call
+		arguments = make([]interface{}, len(expr.arguments), function.declaration.layout.size)
+	} else {
+		arguments = make([]interface{}, len(expr.arguments))
+	}
 
-	arguments := make([]interface{}, callee.Arity())
 	for j := range arguments {
 		arguments[j] = i.VisitExpressionNode(expr.arguments[j])
 	}
 
-	return callee.Call(i, arguments)
+	return callee, arguments
+}
+
+// CallNative calls a native or builtin function and counts the memory allocated for its result
+func (i *Interpreter) CallNative(expr *CallExpression, callee AspenFunction, arguments []interface{}) interface{} {
+	if i.observer != nil {
+		i.observer.Call(callee, arguments, expr.loc)
+	}
+
+	value := callee.Call(i, arguments)
+	i.Allocate(expr.loc, ResultSize(callee, arguments, value))
+
+	if i.observer != nil {
+		i.observer.Return(value)
+	}
+	return value
+}
+
+func (i *Interpreter) VisitCall(expr *CallExpression) interface{} {
+	callee, arguments := i.EvaluateCall(expr)
+	i.Step(expr.loc)
+
+	if function, ok := callee.(*UserFunction); ok {
+		if len(i.callStack) >= i.options.maxCallDepth {
+			i.RuntimeError(expr.loc, StackOverflowMessage(i.options.maxCallDepth))
+		}
+
+		i.callStack = append(i.callStack, StackFrame{function: function.Name(), callSite: expr.loc})
+		if i.observer != nil {
+			i.observer.Call(function, arguments, expr.loc)
+		}
+
+		value := callee.Call(i, arguments)
+
+		i.callStack = i.callStack[:len(i.callStack)-1]
+		if i.observer != nil {
+			i.observer.Return(value)
+		}
+		return value
+	}
+
+	return i.CallNative(expr, callee, arguments)
 }
 
 func (i *Interpreter) VisitTypeCast(expr *TypeCastExpression) interface{} {
@@ -129,83 +402,347 @@ func (i *Interpreter) VisitTypeCast(expr *TypeCastExpression) interface{} {
 	return handler(i.VisitExpressionNode(expr.value))
 }
 
+func (i *Interpreter) EvaluateIndex(slice []interface{}, expr *SubscriptExpression) int {
+	value := i.VisitExpressionNode(expr.index)
+
+	index, inRange := SliceIndex(value, len(slice))
+	if !inRange {
+		i.RuntimeError(expr.loc, IndexOutOfRangeMessage(value, len(slice)))
+	}
+
+	return index
+}
+
+func (i *Interpreter) VisitSubscript(expr *SubscriptExpression) interface{} {
+	object := i.VisitExpressionNode(expr.object)
+
+	if m, ok := object.(*MapValue); ok {
+		key := i.VisitExpressionNode(expr.index)
+		value, ok := m.Get(key)
+		if !ok {
+			i.RuntimeError(expr.loc, KeyNotFoundMessage(key))
+		}
+		return value
+	}
+
+	slice := object.([]interface{})
+	return slice[i.EvaluateIndex(slice, expr)]
+}
+
+func (i *Interpreter) VisitSubscriptAssignment(expr *SubscriptAssignmentExpression) interface{} {
+	object := i.VisitExpressionNode(expr.target.object)
+
+	if m, ok := object.(*MapValue); ok {
+		key := i.VisitExpressionNode(expr.target.index)
+		value := i.VisitExpressionNode(expr.value)
+		if m.Set(key, value) {
+			i.Allocate(expr.target.loc, MAP_ENTRY_SIZE)
+		}
+		return value
+	}
+
+	slice := object.([]interface{})
+	index := i.EvaluateIndex(slice, expr.target)
+	value := i.VisitExpressionNode(expr.value)
+	slice[index] = value
+	return value
+}
+
+func (i *Interpreter) VisitSliceLiteral(expr *SliceLiteralExpression) interface{} {
+	i.Allocate(expr.loc, int64(len(expr.elements))*SLICE_ELEMENT_SIZE)
+	slice := make([]interface{}, len(expr.elements))
+	for j := range expr.elements {
+		slice[j] = i.VisitExpressionNode(expr.elements[j])
+	}
+	return slice
+}
+
+func (i *Interpreter) VisitMapLiteral(expr *MapLiteralExpression) interface{} {
+	i.Allocate(expr.loc, int64(len(expr.keys))*MAP_ENTRY_SIZE)
+	m := NewMapValue()
+	for j := range expr.keys {
+		m.Set(i.VisitExpressionNode(expr.keys[j]), i.VisitExpressionNode(expr.values[j]))
+	}
+	return m
+}
+
+func (i *Interpreter) VisitTuple(expr *TupleExpression) interface{} {
+	tuple := make(TupleValue, len(expr.elements))
+	for j := range expr.elements {
+		tuple[j] = i.VisitExpressionNode(expr.elements[j])
+	}
+	return tuple
+}
+
+func (i *Interpreter) VisitField(expr *FieldExpression) interface{} {
+	object := i.VisitExpressionNode(expr.object).(*StructValue)
+	name := expr.name.String()
+
+	if j := object.atype.FieldIndex(name); j >= 0 {
+		return object.fields[j]
+	}
+
+	// bind the receiver to `self`, the only variable a method can capture
+	method := object.atype.methods[name]
+	self := &Cell{value: object}
+	captured := make([]*Cell, len(method.layout.captures))
+	for j := range captured {
+		captured[j] = self
+	}
+	return &UserFunction{declaration: method, captured: captured}
+}
+
+func (i *Interpreter) VisitFieldAssignment(expr *FieldAssignmentExpression) interface{} {
+	object := i.VisitExpressionNode(expr.target.object).(*StructValue)
+	value := i.VisitExpressionNode(expr.value)
+	object.fields[object.atype.FieldIndex(expr.target.name.String())] = value
+	return value
+}
+
+func (i *Interpreter) VisitStructLiteral(expr *StructLiteralExpression) interface{} {
+	atype := expr.atype.other.(*StructType)
+	value := &StructValue{atype: atype, fields: make([]interface{}, len(atype.fields))}
+
+	for j := range expr.fields {
+		value.fields[atype.FieldIndex(expr.fields[j].String())] = i.VisitExpressionNode(expr.values[j])
+	}
+
+	return value
+}
+
 func (i *Interpreter) VisitExpression(stmt *ExpressionStatement) interface{} {
 	i.VisitExpressionNode(stmt.expr)
-	return nil
+	return COMPLETION_NORMAL
 }
 
 func (i *Interpreter) VisitPrint(stmt *PrintStatement) interface{} {
 	value := i.VisitExpressionNode(stmt.expr)
 	PrintValue(value)
-	return nil
+	return COMPLETION_NORMAL
 }
 
 func (i *Interpreter) VisitLet(stmt *LetStatement) interface{} {
 	value := i.VisitExpressionNode(stmt.initializer)
 
-	i.environment.Define(stmt.name.String(), value)
-	return nil
+	if stmt.names != nil {
+		tuple := value.(TupleValue)
+		for j, variable := range stmt.variables {
+			i.Define(variable, tuple[j])
+		}
+		return COMPLETION_NORMAL
+	}
+
+	i.Define(stmt.variables[0], value)
+	return COMPLETION_NORMAL
 }
 
-func (i *Interpreter) ExecuteBlock(stmt *BlockStatement, environment Environment) {
-	enclosing := i.environment
-	i.environment = environment
+// ExecuteBlock executes the body of a function in the frame of the call
+func (i *Interpreter) ExecuteBlock(stmt *BlockStatement, frame *Frame) Completion {
+	enclosing := i.frame
+	i.frame = frame
 
-	// we defer because VisitStatementNode can panic when we return
-	defer func() {
-		i.environment = enclosing
-	}()
+	completion := i.ExecuteStatements(stmt.statements)
 
-	for _, stmt := range stmt.statements {
-		i.VisitStatementNode(stmt)
+	i.frame = enclosing
+	return completion
+}
+
+// ExecuteStatements executes statements until one of them does not complete normally
+func (i *Interpreter) ExecuteStatements(statements []Statement) Completion {
+	for _, stmt := range statements {
+		if completion := i.Execute(stmt); completion != COMPLETION_NORMAL {
+			return completion
+		}
 	}
+	return COMPLETION_NORMAL
 }
 
 func (i *Interpreter) VisitBlock(stmt *BlockStatement) interface{} {
-	enclosing := i.environment
-	i.ExecuteBlock(stmt, NewEnvironment(&enclosing))
-	return nil
+	// the variables of a block have their own slots in the frame of the enclosing function
+	return i.ExecuteStatements(stmt.statements)
 }
 
-func (i *Interpreter) VisitIf(stmt *IfStatement) interface{} {
-	condition := i.VisitExpressionNode(stmt.condition).(bool)
+// Condition evaluates the condition of an if or while statement
+func (i *Interpreter) Condition(stmt Statement, condition Expression) bool {
+	taken := i.VisitExpressionNode(condition).(bool)
+	if i.observer != nil {
+		i.observer.Branch(stmt, taken)
+	}
+	return taken
+}
 
-	if condition {
-		i.VisitStatementNode(stmt.thenBranch)
+func (i *Interpreter) VisitIf(stmt *IfStatement) interface{} {
+	if i.Condition(stmt, stmt.condition) {
+		return i.Execute(stmt.thenBranch)
 	} else if stmt.elseBranch != nil {
-		i.VisitStatementNode(stmt.elseBranch)
+		return i.Execute(stmt.elseBranch)
 	}
 
-	return nil
+	return COMPLETION_NORMAL
 }
 
 func (i *Interpreter) VisitWhile(stmt *WhileStatement) interface{} {
-	for i.VisitExpressionNode(stmt.condition).(bool) {
-		i.VisitStatementNode(stmt.body)
+	for i.Condition(stmt, stmt.condition) {
+		switch completion := i.Execute(stmt.body); completion {
+		case COMPLETION_BREAK, COMPLETION_CONTINUE:
+			if i.target != stmt {
+				// the signal targets an outer loop
+				return completion
+			}
+			i.target = nil
+			if completion == COMPLETION_BREAK {
+				return COMPLETION_NORMAL
+			}
+		case COMPLETION_RETURN, COMPLETION_TAIL_CALL:
+			return completion
+		}
+
+		if stmt.increment != nil {
+			i.VisitExpressionNode(stmt.increment)
+		}
+		i.Step(stmt.loc)
 	}
-	return nil
+	return COMPLETION_NORMAL
+}
+
+func (i *Interpreter) VisitBreak(stmt *BreakStatement) interface{} {
+	i.target = stmt.target
+	return COMPLETION_BREAK
+}
+
+func (i *Interpreter) VisitContinue(stmt *ContinueStatement) interface{} {
+	i.target = stmt.target
+	return COMPLETION_CONTINUE
+}
+
+func (i *Interpreter) VisitFunctionLiteral(expr *FunctionLiteralExpression) interface{} {
+	return i.NewClosure(expr.function)
 }
 
 func (i *Interpreter) VisitFunction(stmt *FunctionStatement) interface{} {
-	i.environment.Define(stmt.name.String(), &UserFunction{declaration: stmt, closure: i.environment})
-	return nil
+	if stmt.variable.global {
+		i.globals[stmt.variable.index] = i.NewClosure(stmt)
+		return COMPLETION_NORMAL
+	}
+
+	if !stmt.variable.captured {
+		i.frame.slots[stmt.variable.index] = i.NewClosure(stmt)
+		return COMPLETION_NORMAL
+	}
+
+	// create the cell before the closure, which captures it to call itself
+	cell := &Cell{}
+	i.frame.slots[stmt.variable.index] = cell
+	cell.value = i.NewClosure(stmt)
+	return COMPLETION_NORMAL
+}
+
+func (i *Interpreter) VisitStruct(stmt *StructStatement) interface{} {
+	// structs are declared ahead of time by the type checker
+	return COMPLETION_NORMAL
 }
 
 func (i *Interpreter) VisitReturn(stmt *ReturnStatement) interface{} {
-	var value ReturnValue
+	if call, ok := stmt.value.(*CallExpression); ok && call.tail {
+		callee, arguments := i.EvaluateCall(call)
+		i.Step(call.loc)
+		if function, ok := callee.(*UserFunction); ok {
+			// the caller runs the call in place of the current one
+			i.tailCall = TailCall{function: function, arguments: arguments, call: call}
+			return COMPLETION_TAIL_CALL
+		}
+
+		i.returnValue = i.CallNative(call, callee, arguments)
+		return COMPLETION_RETURN
+	}
 
 	if stmt.value != nil {
-		value.value = i.VisitExpressionNode(stmt.value)
+		i.returnValue = i.VisitExpressionNode(stmt.value)
+	}
+	return COMPLETION_RETURN
+}
+
+// Interpret executes a type checked program, a runtime error stops the program and is returned as an
+// `*AspenRuntimeError`
+func Interpret(ast Program, source []rune, options RuntimeOptions) (err error) {
+	// some observers need the scope of each statement
+	attached, attaching := options.observer.(LayoutObserver)
+	layout := Resolve(ast, attaching)
+	interpreter := NewInterpreter(layout, options)
+
+	if attaching {
+		defer interpreter.Recover(source, interpreter.frame, &err)
+		attached.Attach(interpreter, layout)
+	}
+
+	return interpreter.Run(ast, source)
+}
+
+// NewInterpreter returns an interpreter for the top level code of a resolved program
+func NewInterpreter(layout *ProgramLayout, options RuntimeOptions) *Interpreter {
+	interpreter := &Interpreter{
+		frame:    NewFrame(layout.script, nil),
+		options:  options,
+		budget:   NewBudget(options),
+		observer: options.observer,
+	}
+	interpreter.Grow(layout)
+
+	for j, name := range layout.globals {
+		if native, ok := NativeFunctions[name]; ok {
+			interpreter.globals[j] = native
+		} else if builtin, ok := BuiltinFunctions[name]; ok {
+			interpreter.globals[j] = builtin
+		}
 	}
 
-	panic(value)
+	return interpreter
+}
+
+// Grow makes room for the variables declared by the parts of the program resolved since the interpreter was
+// created
+func (i *Interpreter) Grow(layout *ProgramLayout) {
+	for len(i.globals) < len(layout.globals) {
+		i.globals = append(i.globals, nil)
+	}
+	for len(i.frame.slots) < layout.script.size {
+		i.frame.slots = append(i.frame.slots, nil)
+	}
 }
 
-func Interpret(ast Program) (err error) {
-	interpreter := Interpreter{environment: NewGlobalEnvironment()}
+// Run executes statements of the top level code, a runtime error stops them and is returned
+func (i *Interpreter) Run(ast Program, source []rune) (err error) {
+	defer i.Recover(source, i.frame, &err)
 
 	for _, stmt := range ast {
-		interpreter.VisitStatementNode(stmt)
+		i.Execute(stmt)
 	}
 	return nil
 }
+
+// Evaluate evaluates an expression of the top level code
+func (i *Interpreter) Evaluate(expr Expression, source []rune) (value interface{}, err error) {
+	defer i.Recover(source, i.frame, &err)
+
+	return i.VisitExpressionNode(expr), nil
+}
+
+// Recover turns the panic of a runtime error into `err`, the calls in progress are abandoned and `frame`, the frame
+// of the top level code, becomes the current frame again so that the interpreter can run more code. Any other
+// panic is a bug in the interpreter and is raised again, so that it is not mistaken for an error of the program.
+func (i *Interpreter) Recover(source []rune, frame *Frame, err *error) {
+	r := recover()
+	if r == nil {
+		return
+	}
+
+	runtimeError, ok := r.(*AspenRuntimeError)
+	if !ok {
+		panic(r)
+	}
+	runtimeError.source = source
+	*err = runtimeError
+
+	i.frame, i.callStack = frame, nil
+	i.returnValue, i.target, i.tailCall = nil, nil, TailCall{}
+}
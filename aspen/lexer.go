@@ -4,6 +4,8 @@ import (
 	"fmt"
 	"strconv"
 	"strings"
+	"unicode"
+	"unicode/utf8"
 )
 
 type TokenType int
@@ -24,6 +26,9 @@ const (
 	TOKEN_STAR
 	TOKEN_CARET
 	TOKEN_PERCENT
+	TOKEN_COLON
+	TOKEN_DOT
+	TOKEN_QUESTION
 
 	// one or two character tokens
 	TOKEN_BANG
@@ -42,6 +47,8 @@ const (
 	// literals
 	TOKEN_IDENTIFIER
 	TOKEN_STRING_LITERAL
+	TOKEN_INTERPOLATION
+	TOKEN_INTERPOLATION_END
 	TOKEN_FLOAT_LITERAL
 	TOKEN_INT_LITERAL
 	TOKEN_COMMENT
@@ -58,6 +65,11 @@ const (
 	TOKEN_FALSE
 	TOKEN_LET
 	TOKEN_WHILE
+	TOKEN_BREAK
+	TOKEN_CONTINUE
+	TOKEN_STRUCT
+	TOKEN_NIL
+	TOKEN_IN
 
 	// types
 	TOKEN_I64
@@ -65,6 +77,7 @@ const (
 	TOKEN_BOOL
 	TOKEN_STRING
 	TOKEN_DOUBLE
+	TOKEN_MAP
 
 	TOKEN_EOF
 )
@@ -106,6 +119,12 @@ func (token Token) String() string {
 		return "^"
 	case TOKEN_PERCENT:
 		return "%"
+	case TOKEN_COLON:
+		return ":"
+	case TOKEN_DOT:
+		return "."
+	case TOKEN_QUESTION:
+		return "?"
 	case TOKEN_BANG:
 		return "!"
 	case TOKEN_BANG_EQUAL:
@@ -131,9 +150,13 @@ func (token Token) String() string {
 	case TOKEN_PIPE_PIPE:
 		return "||"
 	case TOKEN_IDENTIFIER:
-		return fmt.Sprintf("%v", token.value.(string))
+		return token.value.(string)
 	case TOKEN_STRING_LITERAL:
 		return fmt.Sprintf("\"%v\"", string(token.value.([]rune)))
+	case TOKEN_INTERPOLATION:
+		return fmt.Sprintf("\"%v${", string(token.value.([]rune)))
+	case TOKEN_INTERPOLATION_END:
+		return fmt.Sprintf("}%v\"", string(token.value.([]rune)))
 	case TOKEN_FLOAT_LITERAL:
 		return fmt.Sprintf("%.2f", token.value.(float64))
 	case TOKEN_INT_LITERAL:
@@ -162,6 +185,16 @@ func (token Token) String() string {
 		return "let"
 	case TOKEN_WHILE:
 		return "while"
+	case TOKEN_BREAK:
+		return "break"
+	case TOKEN_CONTINUE:
+		return "continue"
+	case TOKEN_STRUCT:
+		return "struct"
+	case TOKEN_NIL:
+		return "nil"
+	case TOKEN_IN:
+		return "in"
 	case TOKEN_I64:
 		return "i64"
 	case TOKEN_U64:
@@ -172,6 +205,8 @@ func (token Token) String() string {
 		return "string"
 	case TOKEN_DOUBLE:
 		return "double"
+	case TOKEN_MAP:
+		return "map"
 	case TOKEN_EOF:
 		return "<eof>"
 	}
@@ -212,22 +247,28 @@ func IsDigit(r rune) bool {
 }
 
 var KEYWORDS = map[string]TokenType{
-	"else":   TOKEN_ELSE,
-	"for":    TOKEN_FOR,
-	"fn":     TOKEN_FN,
-	"if":     TOKEN_IF,
-	"void":   TOKEN_VOID,
-	"print":  TOKEN_PRINT,
-	"return": TOKEN_RETURN,
-	"true":   TOKEN_TRUE,
-	"false":  TOKEN_FALSE,
-	"let":    TOKEN_LET,
-	"while":  TOKEN_WHILE,
-	"i64":    TOKEN_I64,
-	"u64":    TOKEN_U64,
-	"bool":   TOKEN_BOOL,
-	"string": TOKEN_STRING,
-	"double": TOKEN_DOUBLE,
+	"else":     TOKEN_ELSE,
+	"for":      TOKEN_FOR,
+	"fn":       TOKEN_FN,
+	"if":       TOKEN_IF,
+	"void":     TOKEN_VOID,
+	"print":    TOKEN_PRINT,
+	"return":   TOKEN_RETURN,
+	"true":     TOKEN_TRUE,
+	"false":    TOKEN_FALSE,
+	"let":      TOKEN_LET,
+	"while":    TOKEN_WHILE,
+	"break":    TOKEN_BREAK,
+	"continue": TOKEN_CONTINUE,
+	"struct":   TOKEN_STRUCT,
+	"nil":      TOKEN_NIL,
+	"in":       TOKEN_IN,
+	"i64":      TOKEN_I64,
+	"u64":      TOKEN_U64,
+	"bool":     TOKEN_BOOL,
+	"string":   TOKEN_STRING,
+	"double":   TOKEN_DOUBLE,
+	"map":      TOKEN_MAP,
 }
 
 // note: this function can be optimised, see: https://craftinginterpreters.com/scanning-on-demand.html#tries-and-state-machines
@@ -342,30 +383,130 @@ func ScanTokens(source []rune, errorReporter ErrorReporter) (TokenStream, error)
 		}
 	}
 
+	// the number of unclosed "{" in each interpolated expression being scanned, innermost last
+	interpolations := []int{}
+
+	// escapeSequence scans the escape sequence following a "\"
+	escapeSequence := func() (rune, bool) {
+		backslash := i - 1
+		escapeCol := col - 1
+
+		r := advance()
+		col++
+
+		switch r {
+		case 'n':
+			return '\n', true
+		case 't':
+			return '\t', true
+		case 'r':
+			return '\r', true
+		case '0':
+			return 0, true
+		case '\\', '"', '$':
+			return r, true
+		case 'u':
+			// \u{XXXX}, with 1 to 6 hex digits
+			if !match('{') {
+				break
+			}
+			col++
+
+			start := i
+			for !isAtEnd() && i-start < 6 && unicode.Is(unicode.ASCII_Hex_Digit, peek()) {
+				advance()
+				col++
+			}
+			end := i
+
+			if start == end || !match('}') {
+				break
+			}
+			col++
+
+			value, _ := strconv.ParseUint(string(source[start:end]), 16, 32)
+			if !utf8.ValidRune(rune(value)) {
+				errorReporter.Push(line, escapeCol, fmt.Sprintf("invalid unicode code point \"%s\".", string(source[backslash:i])))
+				return 0, false
+			}
+			return rune(value), true
+		}
+
+		errorReporter.Push(line, escapeCol, fmt.Sprintf("invalid escape sequence \"%s\".", string(source[backslash:i])))
+		return 0, false
+	}
+
+	// stringBody scans the rest of a string literal, up to the closing quote or the start of an interpolation.
+	// The part of a string after its last interpolation is a TOKEN_INTERPOLATION_END rather than a literal.
+	stringBody := func(tokenLine, tokenCol int, end TokenType) {
+		value := []rune{}
+
+		for {
+			if isAtEnd() || peek() == '\n' {
+				errorReporter.Push(line, col, "string literal not terminated.")
+				return
+			}
+
+			r := advance()
+			col++
+
+			switch {
+			case r == '"':
+				tokens = append(tokens, Token{end, tokenLine, tokenCol, value})
+				return
+			case r == '$' && match('{'):
+				col++
+				tokens = append(tokens, Token{TOKEN_INTERPOLATION, tokenLine, tokenCol, value})
+				interpolations = append(interpolations, 0)
+				return
+			case r == '\\':
+				if isAtEnd() || peek() == '\n' {
+					continue
+				}
+				if escaped, ok := escapeSequence(); ok {
+					value = append(value, escaped)
+				}
+			default:
+				value = append(value, r)
+			}
+		}
+	}
+
 	stringToken := func() {
 		oldCol := col
 		col++
+		stringBody(line, oldCol, TOKEN_STRING_LITERAL)
+	}
+
+	// rawStringToken scans a string delimited by backticks, which may span several lines and has no escapes
+	rawStringToken := func() {
+		oldLine := line
+		oldCol := col
+		col++
 
 		start := i
 
-		for !isAtEnd() && peek() != '\n' && peek() != '"' {
-			advance()
-			col++
+		for !isAtEnd() && peek() != '`' {
+			if advance() == '\n' {
+				line++
+				col = 1
+			} else {
+				col++
+			}
 		}
 
-		if isAtEnd() || peek() == '\n' {
+		if isAtEnd() {
 			errorReporter.Push(line, col, "string literal not terminated.")
 			return
 		}
 
 		end := i
-
-		if !match('"') {
-			Unreachable("lexer.go: stringToken()")
-		}
+		advance()
 		col++
 
-		tokens = append(tokens, Token{TOKEN_STRING_LITERAL, line, oldCol, source[start:end]})
+		value := make([]rune, end-start)
+		copy(value, source[start:end])
+		tokens = append(tokens, Token{TOKEN_STRING_LITERAL, oldLine, oldCol, value})
 	}
 
 	numberToken := func() {
@@ -450,9 +591,23 @@ func ScanTokens(source []rune, errorReporter ErrorReporter) (TokenStream, error)
 			simpleToken(TOKEN_RIGHT_PAREN)
 			col++
 		case '{':
+			if len(interpolations) > 0 {
+				interpolations[len(interpolations)-1]++
+			}
 			simpleToken(TOKEN_LEFT_BRACE)
 			col++
 		case '}':
+			if n := len(interpolations); n > 0 && interpolations[n-1] == 0 {
+				// the end of an interpolated expression, scan the rest of the string
+				interpolations = interpolations[:n-1]
+				oldCol := col
+				col++
+				stringBody(line, oldCol, TOKEN_INTERPOLATION_END)
+				continue
+			}
+			if len(interpolations) > 0 {
+				interpolations[len(interpolations)-1]--
+			}
 			simpleToken(TOKEN_RIGHT_BRACE)
 			col++
 		case '[':
@@ -482,6 +637,20 @@ func ScanTokens(source []rune, errorReporter ErrorReporter) (TokenStream, error)
 		case '%':
 			simpleToken(TOKEN_PERCENT)
 			col++
+		case ':':
+			simpleToken(TOKEN_COLON)
+			col++
+		case '.':
+			if !isAtEnd() && IsDigit(peek()) {
+				// floating point literals must have a leading digit
+				errorReporter.Push(line, col, "unexpected token \".\".")
+			} else {
+				simpleToken(TOKEN_DOT)
+			}
+			col++
+		case '?':
+			simpleToken(TOKEN_QUESTION)
+			col++
 		case '/':
 			if match('/') {
 				singleLineComment()
@@ -505,6 +674,8 @@ func ScanTokens(source []rune, errorReporter ErrorReporter) (TokenStream, error)
 			conditionalToken(TOKEN_PIPE, TOKEN_PIPE_PIPE, '|')
 		case '"':
 			stringToken()
+		case '`':
+			rawStringToken()
 		default:
 			if IsDigit(r) {
 				numberToken()
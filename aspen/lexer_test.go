@@ -127,6 +127,12 @@ func toTokenType(tokenType string) TokenType {
 		return TOKEN_CARET
 	case "TOKEN_PERCENT":
 		return TOKEN_PERCENT
+	case "TOKEN_COLON":
+		return TOKEN_COLON
+	case "TOKEN_DOT":
+		return TOKEN_DOT
+	case "TOKEN_QUESTION":
+		return TOKEN_QUESTION
 	case "TOKEN_BANG":
 		return TOKEN_BANG
 	case "TOKEN_BANG_EQUAL":
@@ -155,6 +161,10 @@ func toTokenType(tokenType string) TokenType {
 		return TOKEN_IDENTIFIER
 	case "TOKEN_STRING_LITERAL":
 		return TOKEN_STRING_LITERAL
+	case "TOKEN_INTERPOLATION":
+		return TOKEN_INTERPOLATION
+	case "TOKEN_INTERPOLATION_END":
+		return TOKEN_INTERPOLATION_END
 	case "TOKEN_FLOAT":
 		return TOKEN_FLOAT_LITERAL
 	case "TOKEN_INT":
@@ -183,6 +193,16 @@ func toTokenType(tokenType string) TokenType {
 		return TOKEN_LET
 	case "TOKEN_WHILE":
 		return TOKEN_WHILE
+	case "TOKEN_BREAK":
+		return TOKEN_BREAK
+	case "TOKEN_CONTINUE":
+		return TOKEN_CONTINUE
+	case "TOKEN_STRUCT":
+		return TOKEN_STRUCT
+	case "TOKEN_NIL":
+		return TOKEN_NIL
+	case "TOKEN_IN":
+		return TOKEN_IN
 	case "TOKEN_I64":
 		return TOKEN_I64
 	case "TOKEN_U64":
@@ -193,6 +213,8 @@ func toTokenType(tokenType string) TokenType {
 		return TOKEN_STRING
 	case "TOKEN_DOUBLE":
 		return TOKEN_DOUBLE
+	case "TOKEN_MAP":
+		return TOKEN_MAP
 	case "TOKEN_EOF":
 		return TOKEN_EOF
 	}
@@ -227,7 +249,7 @@ func LexerTestGetValue(line string, tokenType string) (interface{}, error) {
 		var i int64
 		fmt.Sscanf(valueString, "%d", &i)
 		return i, nil
-	case "TOKEN_STRING_LITERAL":
+	case "TOKEN_STRING_LITERAL", "TOKEN_INTERPOLATION", "TOKEN_INTERPOLATION_END":
 		unescaped, err := UnescapeString(valueString)
 		if err != nil {
 			return nil, err
@@ -327,7 +349,7 @@ func NewLexerTestCase(file string, t *testing.T) TestCase {
 			fmt.Sscanf(line, "%d:%d %s", &lineNumber, &col, &tokenType)
 
 			var value interface{}
-			hasValue := tokenType == "TOKEN_INT" || tokenType == "TOKEN_STRING_LITERAL" || tokenType == "TOKEN_FLOAT" || tokenType == "TOKEN_COMMENT" || tokenType == "TOKEN_IDENTIFIER"
+			hasValue := tokenType == "TOKEN_INT" || tokenType == "TOKEN_STRING_LITERAL" || tokenType == "TOKEN_INTERPOLATION" || tokenType == "TOKEN_INTERPOLATION_END" || tokenType == "TOKEN_FLOAT" || tokenType == "TOKEN_COMMENT" || tokenType == "TOKEN_IDENTIFIER"
 
 			if hasValue {
 				value, err = LexerTestGetValue(line, tokenType)
@@ -0,0 +1,593 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// LintRule is a kind of problem the linter reports, it is enabled or disabled by its id
+type LintRule struct {
+	id          string
+	description string
+}
+
+var LintRules = []LintRule{
+	{"unused-variable", "a local variable is declared but never read"},
+	{"unused-parameter", "a parameter is never read, parameters starting with _ are exempt"},
+	{"unused-function", "a function is never called outside of its own body"},
+	{"shadowed-variable", "a variable hides a variable of the same name declared in an enclosing scope"},
+	{"self-assignment", "a variable or field is assigned to itself"},
+	{"float-equality", "doubles are compared with == or !=, which rounding errors make unreliable"},
+	{"constant-condition", "the condition of an if or while statement is always true or always false"},
+	{"unreachable-code", "a statement follows a return, break or continue statement"},
+}
+
+// IsLintRule reports whether `id` is the id of a rule
+func IsLintRule(id string) bool {
+	for _, rule := range LintRules {
+		if rule.id == id {
+			return true
+		}
+	}
+	return false
+}
+
+// Warning is a problem found by the linter
+type Warning struct {
+	rule    string
+	loc     Token
+	message string
+}
+
+type LintVariableKind int
+
+const (
+	LINT_VARIABLE LintVariableKind = iota
+	LINT_PARAMETER
+	LINT_FUNCTION
+	LINT_BUILTIN // native and builtin functions, and the receiver of methods
+)
+
+// LintVariable is a variable, a parameter or a function declared in a scope
+type LintVariable struct {
+	name     Token
+	kind     LintVariableKind
+	function *FunctionStatement // the declaration of a function
+	used     bool
+}
+
+// LintScope mirrors the scopes of the resolver, so that an identifier finds its declaration `depth` scopes up
+type LintScope struct {
+	enclosing *LintScope
+	variables map[string]*LintVariable
+
+	// the variables declared in the scope in the order of their declarations
+	declared []*LintVariable
+}
+
+// Linter reports the problems of a type checked program that the type checker accepts
+type Linter struct {
+	// the type of each expression of the program
+	types map[Expression]*Type
+	// the ids of the rules that are reported
+	rules map[string]bool
+
+	scope *LintScope
+
+	// the functions enclosing the current statement, innermost last
+	functions []*FunctionStatement
+
+	warnings []Warning
+}
+
+func NewLinter(types map[Expression]*Type, rules map[string]bool) *Linter {
+	linter := &Linter{types: types, rules: rules}
+	linter.BeginScope()
+
+	for name := range NativeFunctions {
+		linter.Declare(Token{tokenType: TOKEN_IDENTIFIER, value: name}, LINT_BUILTIN)
+	}
+	for name := range BuiltinFunctions {
+		linter.Declare(Token{tokenType: TOKEN_IDENTIFIER, value: name}, LINT_BUILTIN)
+	}
+
+	return linter
+}
+
+// LintSource type checks a program and returns the warnings of the enabled rules, sorted by their position, that
+// are not suppressed by an `// aspen:ignore` comment
+func LintSource(source []rune, rules map[string]bool) ([]Warning, error) {
+	tokens, err := ScanSource(source)
+	if err != nil {
+		return nil, err
+	}
+
+	errorReporter := NewErrorReporter(source)
+	ast, err := Parse(tokens, errorReporter)
+	if err != nil {
+		return nil, err
+	}
+
+	typeChecker := NewTypeChecker(errorReporter)
+	typeChecker.types = make(map[Expression]*Type)
+	if err := typeChecker.Check(ast); err != nil {
+		return nil, err
+	}
+
+	linter := NewLinter(typeChecker.types, rules)
+	linter.Lint(ast)
+
+	ignored := IgnoredRules(tokens)
+	warnings := []Warning{}
+	for _, warning := range linter.warnings {
+		rules, ok := ignored[warning.loc.line]
+		if ok && (len(rules) == 0 || rules[warning.rule]) {
+			continue
+		}
+		warnings = append(warnings, warning)
+	}
+
+	sort.SliceStable(warnings, func(i, j int) bool {
+		a, b := warnings[i].loc, warnings[j].loc
+		return a.line < b.line || a.line == b.line && a.col < b.col
+	})
+	return warnings, nil
+}
+
+// IgnoredRules returns the rules ignored on each line by `// aspen:ignore <rules>` comments, an empty set ignores
+// every rule. A comment after code applies to its own line, a comment on a line of its own to the next line of code.
+func IgnoredRules(tokens TokenStream) map[int]map[string]bool {
+	ignored := make(map[int]map[string]bool)
+
+	for i, token := range tokens {
+		if token.tokenType != TOKEN_COMMENT {
+			continue
+		}
+
+		text := strings.TrimSpace(token.value.(string))
+		if !strings.HasPrefix(text, "aspen:ignore") {
+			continue
+		}
+
+		line := token.line
+		if i == 0 || LastLine(tokens[i-1]) != token.line {
+			// the comment is on a line of its own
+			for _, next := range tokens[i+1:] {
+				if next.tokenType != TOKEN_COMMENT {
+					line = next.line
+					break
+				}
+			}
+		}
+
+		rules, ok := ignored[line]
+		if !ok {
+			rules = make(map[string]bool)
+			ignored[line] = rules
+		}
+		for _, rule := range strings.FieldsFunc(strings.TrimPrefix(text, "aspen:ignore"), func(r rune) bool {
+			return r == ' ' || r == ',' || r == '\t'
+		}) {
+			rules[rule] = true
+		}
+	}
+
+	return ignored
+}
+
+func (l *Linter) Warn(rule string, loc Token, message string) {
+	if l.rules[rule] {
+		l.warnings = append(l.warnings, Warning{rule: rule, loc: loc, message: message})
+	}
+}
+
+// Lint reports the problems of the statements of a program
+func (l *Linter) Lint(ast Program) {
+	// global functions can be called before their declarations, like the type checker allows
+	for _, stmt := range ast {
+		if fn, ok := stmt.(*FunctionStatement); ok {
+			l.Declare(fn.name, LINT_FUNCTION).function = fn
+		}
+	}
+
+	l.Statements(ast)
+	l.EndScope()
+}
+
+func (l *Linter) BeginScope() {
+	l.scope = &LintScope{enclosing: l.scope, variables: make(map[string]*LintVariable)}
+}
+
+// EndScope reports the variables of the current scope that were never used. Global variables may be used by code
+// outside of the program, such as the repl, so only global functions are reported.
+func (l *Linter) EndScope() {
+	global := l.scope.enclosing == nil
+
+	for _, variable := range l.scope.declared {
+		name := variable.name.String()
+		if variable.used || strings.HasPrefix(name, "$") {
+			continue
+		}
+
+		switch {
+		case variable.kind == LINT_FUNCTION:
+			l.Warn("unused-function", variable.name, fmt.Sprintf("function '%s' is never used.", name))
+		case variable.kind == LINT_PARAMETER && !strings.HasPrefix(name, "_"):
+			l.Warn("unused-parameter", variable.name, fmt.Sprintf("parameter '%s' is never used.", name))
+		case variable.kind == LINT_VARIABLE && !global:
+			l.Warn("unused-variable", variable.name, fmt.Sprintf("variable '%s' is declared but never used.", name))
+		}
+	}
+
+	l.scope = l.scope.enclosing
+}
+
+// Declare declares a variable in the current scope, reporting the variable of an enclosing scope it shadows
+func (l *Linter) Declare(name Token, kind LintVariableKind) *LintVariable {
+	variable := &LintVariable{name: name, kind: kind}
+
+	if kind != LINT_BUILTIN && !strings.HasPrefix(name.String(), "$") {
+		for scope := l.scope.enclosing; scope != nil; scope = scope.enclosing {
+			if shadowed, ok := scope.variables[name.String()]; ok && shadowed.kind != LINT_BUILTIN {
+				l.Warn("shadowed-variable", name, fmt.Sprintf("'%s' shadows the declaration on line %d.", name, shadowed.name.line))
+				break
+			}
+		}
+	}
+
+	l.scope.variables[name.String()] = variable
+	l.scope.declared = append(l.scope.declared, variable)
+	return variable
+}
+
+// Use marks the variable an identifier refers to as used, a function calling itself does not use itself
+func (l *Linter) Use(name Token, depth int) {
+	scope := l.scope
+	for i := 0; i < depth && scope != nil; i++ {
+		scope = scope.enclosing
+	}
+	if scope == nil {
+		return
+	}
+
+	variable, ok := scope.variables[name.String()]
+	if !ok {
+		return
+	}
+
+	if variable.function != nil {
+		for _, function := range l.functions {
+			if function == variable.function {
+				return
+			}
+		}
+	}
+	variable.used = true
+}
+
+// Statements lints a list of statements, reporting the first statement that follows one control never flows past
+func (l *Linter) Statements(statements []Statement) {
+	for i, stmt := range statements {
+		l.VisitStatementNode(stmt)
+
+		if i+1 < len(statements) && Terminates(stmt) {
+			l.Warn("unreachable-code", LintPosition(statements[i+1]), "unreachable code.")
+			for _, unreachable := range statements[i+1:] {
+				l.VisitStatementNode(unreachable)
+			}
+			return
+		}
+	}
+}
+
+// LintPosition returns the position of a statement, a block is at the position of its first statement
+func LintPosition(stmt Statement) Token {
+	if block, ok := stmt.(*BlockStatement); ok && len(block.statements) != 0 {
+		return LintPosition(block.statements[0])
+	}
+	return StatementPosition(stmt)
+}
+
+// LintFunction lints the parameters and the body of a function, which share a scope
+func (l *Linter) LintFunction(stmt *FunctionStatement) {
+	l.functions = append(l.functions, stmt)
+	l.BeginScope()
+
+	for _, parameter := range stmt.parameters {
+		l.Declare(parameter, LINT_PARAMETER)
+	}
+	l.Statements(stmt.body.statements)
+
+	l.EndScope()
+	l.functions = l.functions[:len(l.functions)-1]
+}
+
+// ConstantCondition returns the value of a condition that only depends on literals
+func ConstantCondition(expr Expression) (interface{}, bool) {
+	switch e := expr.(type) {
+	case *GroupingExpression:
+		return ConstantCondition(e.expr)
+	case *UnaryExpression:
+		value, ok := ConstantCondition(e.operand)
+		if !ok {
+			return nil, false
+		}
+		if e.operator.tokenType == TOKEN_BANG {
+			return !value.(bool), true
+		}
+		return Negate(value), true
+	case *BinaryExpression:
+		lhs, lhsConstant := ConstantCondition(e.left)
+		operator := e.operator.tokenType
+
+		// `false && x` and `true || x` do not depend on x
+		if lhsConstant && (operator == TOKEN_AMP_AMP || operator == TOKEN_PIPE_PIPE) && lhs.(bool) == (operator == TOKEN_PIPE_PIPE) {
+			return lhs, true
+		}
+
+		rhs, rhsConstant := ConstantCondition(e.right)
+		if !lhsConstant || !rhsConstant {
+			return nil, false
+		}
+
+		switch operator {
+		case TOKEN_AMP_AMP, TOKEN_PIPE_PIPE:
+			return rhs, true
+		case TOKEN_SLASH, TOKEN_PERCENT:
+			if IsIntegerZero(rhs) {
+				return nil, false
+			}
+		}
+		if evaluate, ok := BinaryOperators[operator]; ok {
+			return evaluate(lhs, rhs), true
+		}
+		return nil, false
+	}
+	return Constant(expr)
+}
+
+// Condition reports a condition that is always true or always false
+func (l *Linter) Condition(condition Expression, loc Token) {
+	if value, ok := ConstantCondition(condition); ok {
+		l.Warn("constant-condition", loc, fmt.Sprintf("condition is always %v.", value))
+	}
+}
+
+// SameVariable reports whether two expressions refer to the same variable or field, without evaluating anything
+// that could change between them
+func SameVariable(a, b Expression) bool {
+	switch a := a.(type) {
+	case *IdentifierExpression:
+		b, ok := b.(*IdentifierExpression)
+		return ok && a.name.String() == b.name.String() && a.depth == b.depth
+	case *FieldExpression:
+		b, ok := b.(*FieldExpression)
+		return ok && a.name.String() == b.name.String() && SameVariable(a.object, b.object)
+	case *GroupingExpression:
+		return SameVariable(a.expr, b)
+	}
+	return false
+}
+
+func (l *Linter) VisitExpressionNode(expr Expression) interface{} {
+	return expr.Accept(l)
+}
+
+func (l *Linter) VisitStatementNode(stmt Statement) interface{} {
+	return stmt.Accept(l)
+}
+
+func (l *Linter) VisitBinary(expr *BinaryExpression) interface{} {
+	l.VisitExpressionNode(expr.left)
+	l.VisitExpressionNode(expr.right)
+
+	switch expr.operator.tokenType {
+	case TOKEN_EQUAL_EQUAL, TOKEN_BANG_EQUAL:
+		if atype, ok := l.types[expr.left]; ok && atype.kind == TYPE_DOUBLE {
+			l.Warn("float-equality", expr.operator, fmt.Sprintf("doubles compared with %v, compare their difference to a tolerance instead.", expr.operator))
+		}
+	}
+	return nil
+}
+
+func (l *Linter) VisitUnary(expr *UnaryExpression) interface{} {
+	l.VisitExpressionNode(expr.operand)
+	return nil
+}
+
+func (l *Linter) VisitLiteral(expr *LiteralExpression) interface{} {
+	return nil
+}
+
+func (l *Linter) VisitGrouping(expr *GroupingExpression) interface{} {
+	l.VisitExpressionNode(expr.expr)
+	return nil
+}
+
+func (l *Linter) VisitIdentifier(expr *IdentifierExpression) interface{} {
+	// the reads the parser generates when it desugars a for-in loop do not use the key
+	if !expr.generated {
+		l.Use(expr.name, expr.depth)
+	}
+	return nil
+}
+
+func (l *Linter) VisitAssignment(expr *AssignmentExpression) interface{} {
+	if identifier, ok := expr.value.(*IdentifierExpression); ok && identifier.name.String() == expr.name.String() && identifier.depth == expr.depth {
+		l.Warn("self-assignment", expr.name, fmt.Sprintf("'%s' is assigned to itself.", expr.name))
+	}
+
+	// assigning a variable does not use it
+	l.VisitExpressionNode(expr.value)
+	return nil
+}
+
+func (l *Linter) VisitCall(expr *CallExpression) interface{} {
+	l.VisitExpressionNode(expr.callee)
+	for _, argument := range expr.arguments {
+		l.VisitExpressionNode(argument)
+	}
+	return nil
+}
+
+func (l *Linter) VisitTypeCast(expr *TypeCastExpression) interface{} {
+	l.VisitExpressionNode(expr.value)
+	return nil
+}
+
+func (l *Linter) VisitSubscript(expr *SubscriptExpression) interface{} {
+	l.VisitExpressionNode(expr.object)
+	l.VisitExpressionNode(expr.index)
+	return nil
+}
+
+func (l *Linter) VisitSubscriptAssignment(expr *SubscriptAssignmentExpression) interface{} {
+	l.VisitExpressionNode(expr.target)
+	l.VisitExpressionNode(expr.value)
+	return nil
+}
+
+func (l *Linter) VisitSliceLiteral(expr *SliceLiteralExpression) interface{} {
+	for _, element := range expr.elements {
+		l.VisitExpressionNode(element)
+	}
+	return nil
+}
+
+func (l *Linter) VisitField(expr *FieldExpression) interface{} {
+	l.VisitExpressionNode(expr.object)
+	return nil
+}
+
+func (l *Linter) VisitFieldAssignment(expr *FieldAssignmentExpression) interface{} {
+	if SameVariable(expr.target, expr.value) {
+		l.Warn("self-assignment", expr.target.name, fmt.Sprintf("'%s' is assigned to itself.", expr.target.name))
+	}
+
+	l.VisitExpressionNode(expr.target)
+	l.VisitExpressionNode(expr.value)
+	return nil
+}
+
+func (l *Linter) VisitStructLiteral(expr *StructLiteralExpression) interface{} {
+	for _, value := range expr.values {
+		l.VisitExpressionNode(value)
+	}
+	return nil
+}
+
+func (l *Linter) VisitMapLiteral(expr *MapLiteralExpression) interface{} {
+	for i := range expr.keys {
+		l.VisitExpressionNode(expr.keys[i])
+		l.VisitExpressionNode(expr.values[i])
+	}
+	return nil
+}
+
+func (l *Linter) VisitTuple(expr *TupleExpression) interface{} {
+	for _, element := range expr.elements {
+		l.VisitExpressionNode(element)
+	}
+	return nil
+}
+
+func (l *Linter) VisitFunctionLiteral(expr *FunctionLiteralExpression) interface{} {
+	l.LintFunction(expr.function)
+	return nil
+}
+
+func (l *Linter) VisitExpression(stmt *ExpressionStatement) interface{} {
+	l.VisitExpressionNode(stmt.expr)
+	return nil
+}
+
+func (l *Linter) VisitPrint(stmt *PrintStatement) interface{} {
+	l.VisitExpressionNode(stmt.expr)
+	return nil
+}
+
+func (l *Linter) VisitLet(stmt *LetStatement) interface{} {
+	if stmt.initializer != nil {
+		l.VisitExpressionNode(stmt.initializer)
+	}
+
+	if stmt.names == nil {
+		l.Declare(stmt.name, LINT_VARIABLE)
+	}
+	for _, name := range stmt.names {
+		l.Declare(name, LINT_VARIABLE)
+	}
+	return nil
+}
+
+func (l *Linter) VisitBlock(stmt *BlockStatement) interface{} {
+	l.BeginScope()
+	l.Statements(stmt.statements)
+	l.EndScope()
+	return nil
+}
+
+func (l *Linter) VisitIf(stmt *IfStatement) interface{} {
+	l.VisitExpressionNode(stmt.condition)
+	l.Condition(stmt.condition, stmt.loc)
+
+	l.VisitStatementNode(stmt.thenBranch)
+	if stmt.elseBranch != nil {
+		l.VisitStatementNode(stmt.elseBranch)
+	}
+	return nil
+}
+
+func (l *Linter) VisitWhile(stmt *WhileStatement) interface{} {
+	l.VisitExpressionNode(stmt.condition)
+
+	// `while (true)` is how an endless loop is written, as is a for loop without a condition
+	if literal, ok := stmt.condition.(*LiteralExpression); !ok || literal.value.tokenType != TOKEN_TRUE {
+		l.Condition(stmt.condition, stmt.loc)
+	}
+
+	l.VisitStatementNode(stmt.body)
+	if stmt.increment != nil {
+		l.VisitExpressionNode(stmt.increment)
+	}
+	return nil
+}
+
+func (l *Linter) VisitBreak(stmt *BreakStatement) interface{} {
+	return nil
+}
+
+func (l *Linter) VisitContinue(stmt *ContinueStatement) interface{} {
+	return nil
+}
+
+func (l *Linter) VisitFunction(stmt *FunctionStatement) interface{} {
+	if l.scope.enclosing != nil {
+		// declare the function first so that it can refer to itself, global functions were declared ahead of time
+		l.Declare(stmt.name, LINT_FUNCTION).function = stmt
+	}
+
+	l.LintFunction(stmt)
+	return nil
+}
+
+func (l *Linter) VisitReturn(stmt *ReturnStatement) interface{} {
+	if stmt.value != nil {
+		l.VisitExpressionNode(stmt.value)
+	}
+	return nil
+}
+
+func (l *Linter) VisitStruct(stmt *StructStatement) interface{} {
+	// like the resolver, methods are declared in a scope whose only variable is `self`
+	l.BeginScope()
+	l.Declare(Token{tokenType: TOKEN_IDENTIFIER, value: "self"}, LINT_BUILTIN)
+
+	for _, method := range stmt.methods {
+		l.LintFunction(method)
+	}
+
+	l.EndScope()
+	return nil
+}
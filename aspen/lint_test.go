@@ -0,0 +1,132 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestLint(t *testing.T) {
+	Initialize()
+
+	all := map[string]bool{}
+	for _, rule := range LintRules {
+		all[rule.id] = true
+	}
+
+	tests := []struct {
+		source   string
+		rules    map[string]bool
+		expected []string
+	}{
+		{`let volume double = 11.0;
+{
+    let volume i64 = 3 * 4 * 5;
+    print volume;
+}
+print volume;`, all, []string{
+			"3:9: 'volume' shadows the declaration on line 1. [shadowed-variable]",
+		}},
+		{`fn area(w i64, h i64, _unit string) i64 {
+    let scale = 2;
+    return w * w;
+}
+fn helper() void {}
+fn countdown(n i64) void {
+    if (n > 0) { countdown(n - 1); }
+}
+print area(1, 2, "m");`, all, []string{
+			"1:16: parameter 'h' is never used. [unused-parameter]",
+			"2:9: variable 'scale' is declared but never used. [unused-variable]",
+			"5:4: function 'helper' is never used. [unused-function]",
+			"6:4: function 'countdown' is never used. [unused-function]",
+		}},
+		{`struct Point { x i64; y i64; }
+let p = Point{x: 1, y: 2};
+let a = 1;
+a = a;
+p.x = p.x;
+p.x = p.y;
+let d = 0.5;
+print d == 0.5 || a == 1;
+if (1 < 2 && true) { print a; }
+while (false) {}
+while (true) { break; }
+for (;;) { break; }`, all, []string{
+			"4:1: 'a' is assigned to itself. [self-assignment]",
+			"5:3: 'x' is assigned to itself. [self-assignment]",
+			"8:9: doubles compared with ==, compare their difference to a tolerance instead. [float-equality]",
+			"9:4: condition is always true. [constant-condition]",
+			"10:7: condition is always false. [constant-condition]",
+		}},
+		{`fn sign(n i64) i64 {
+    if (n < 0) {
+        return -1;
+        print n;
+    }
+    while (n > 100) {
+        break;
+        {
+            n = n - 1;
+        }
+    }
+    return 1;
+}
+print sign(3);`, all, []string{
+			"4:9: unreachable code. [unreachable-code]",
+			"9:13: unreachable code. [unreachable-code]",
+		}},
+		{`fn f() void {
+    let a = 1; // aspen:ignore unused-variable
+    // aspen:ignore
+    let b = 2;
+    // aspen:ignore shadowed-variable
+    let c = 3;
+    let d = 4; // aspen:ignore shadowed-variable, unused-variable
+}
+f();`, all, []string{
+			"6:9: variable 'c' is declared but never used. [unused-variable]",
+		}},
+		{`fn f(x i64) void {
+    let y = 1;
+    y = y;
+}
+f(1);`, map[string]bool{"unused-parameter": true}, []string{
+			"1:6: parameter 'x' is never used. [unused-parameter]",
+		}},
+		{`let m = map[string]i64{"a": 1};
+for (key, value in m) { print key; }
+let f = fn(n i64) i64 { return n; };
+print f(1);`, all, []string{
+			"2:11: variable 'value' is declared but never used. [unused-variable]",
+		}},
+		{`let m = map[string]i64{"a": 1};
+for (key, value in m) { print value; }
+for (key in m) {}`, all, []string{
+			"2:6: variable 'key' is declared but never used. [unused-variable]",
+			"3:6: variable 'key' is declared but never used. [unused-variable]",
+		}},
+	}
+
+	for _, test := range tests {
+		warnings, err := LintSource([]rune(test.source), test.rules)
+		if err != nil {
+			t.Fatalf("%s\nunexpected error: %v", test.source, err)
+		}
+
+		got := []string{}
+		for _, warning := range warnings {
+			got = append(got, fmt.Sprintf("%d:%d: %s [%s]", warning.loc.line, warning.loc.col, warning.message, warning.rule))
+		}
+
+		if strings.Join(got, "\n") != strings.Join(test.expected, "\n") {
+			t.Errorf("%s\nexpected\n%s\ngot\n%s", test.source, strings.Join(test.expected, "\n"), strings.Join(got, "\n"))
+		}
+	}
+}
+
+func TestLintTypeError(t *testing.T) {
+	if _, err := LintSource([]rune("let x i64 = true;"), map[string]bool{}); err == nil {
+		t.Errorf("expected the type error to be returned")
+	}
+}
@@ -1,16 +1,67 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
 	"fmt"
-	"io/ioutil"
+	"io"
 	"os"
+	"path/filepath"
 	"strconv"
+	"strings"
 	"time"
 )
 
 type Program []Statement
 
 const helpString = `useage: aspen [<options>] <path>
+       aspen [repl]
+       aspen debug [<options>] <path>
+       aspen dap [<options>]
+       aspen fmt [-w | --check] <path>...
+       aspen lint [--enable <rules>] [--disable <rules>] <path>...
+
+Commands
+    repl
+    Read declarations, statements and expressions line by line and run them, printing
+    the value and type of each expression. This is what aspen does without arguments,
+    type :help at the aspen> prompt to list the commands
+
+    debug
+    Execute the program using the tree walk implementation in an interactive debugger,
+    which stops before the first statement. Commands are read from stdin, type help at
+    the (aspen) prompt to list them
+
+    dap
+    Serve the Debug Adapter Protocol on stdin and stdout, so that editors such as VS Code
+    can debug programs. The program to debug is given by the launch request of the editor
+
+    fmt
+    Format the aspen source files at the given paths, directories are searched for .aspen
+    files. The formatted source code is printed out unless one of these options is given:
+
+        -w          write the formatted source code back to the files that changed
+        --check     print out the paths of the files that are not formatted, and exit
+                    with code 1 if there are any
+
+    lint
+    Report the problems of the aspen source files at the given paths that the type checker
+    accepts, and exit with code 1 if there are any. Directories are searched for .aspen
+    files. A problem is not reported when its line, or the line before it, has a comment
+    // aspen:ignore <rules>, which ignores every rule when none are listed. The rules are:
+
+        unused-variable       a local variable is declared but never read
+        unused-parameter      a parameter is never read, unless its name starts with _
+        unused-function       a function is never called outside of its own body
+        shadowed-variable     a variable hides one declared in an enclosing scope
+        self-assignment       a variable or field is assigned to itself
+        float-equality        doubles are compared with == or !=
+        constant-condition    the condition of an if or while is always true or false
+        unreachable-code      a statement follows a return, break or continue
+
+        --enable <rules>      only report the comma separated rules
+        --disable <rules>     do not report the comma separated rules
 
 Options
     <path>
@@ -19,6 +70,12 @@ Options
     -i or --interpret
     Execute the program using the tree walk implementation
 
+    -b or --bytecode
+    Compile the program to bytecode and execute it on the bytecode vm
+
+    -d or --disassemble
+    Compile the program to bytecode and print out the disassembled bytecode
+
     -l or --lex
     Do lexical analysis on the source code and print out the tokens scanned
 
@@ -28,10 +85,56 @@ Options
     -t or --type-check
     Run the type checker on the program but do not execute it
 
-    --stdin
+    --dump-optimized
+    Optimize the program and print out the optimized ast as an S-expression, constant
+    expressions are folded into literals
+
+    --max-call-depth <n>
+    The maximum number of nested function calls, deeper recursion stops the program
+    with a stack overflow error. Defaults to 100000
+
+    --profile
+    Execute the program using the tree walk implementation and print out how many times
+    each function was called, the time spent in it and the most executed lines to stderr
+
+    --pprof <path>
+    Profile the program like --profile and write the profile to <path> in the format of
+    pprof, to be read with go tool pprof
+
+    --coverage
+    Execute the program using the tree walk implementation and print out how many of the
+    lines and branches of each function ran to stderr, followed by the lines that never
+    ran and the conditions of if and while statements that always went the same way
+
+    --lcov <path>
+    Record coverage like --coverage and add it to the LCOV file at <path>, which is created
+    if it does not exist. The counts of several runs are added up
+
+    --trace
+    Execute the program using the tree walk implementation and print out each statement
+    as it runs, each function call with its arguments and return value, and each
+    assignment with the old and new value of the variable to stderr
+
+    --trace-function <names>
+    Trace like --trace but only inside calls to the functions in <names>, a comma
+    separated list of function names
+
+    --max-steps <n>
+    Stop the program with an error after <n> steps, a step is an iteration of a loop
+    or a function call. Exits with code 3
+
+    --timeout <duration>
+    Stop the program with an error once it has run for <duration>, for example 2s or
+    500ms. Exits with code 4
+
+    --max-allocation <bytes>
+    Stop the program with an error once the strings, slices and maps it created add up
+    to more than roughly <bytes> bytes. Exits with code 5
+
+    --stdin or -
     Read source code from stdin. Note that the code is not executed until an <eof>
-    is read, as such this mode is not intended to be used as a REPL. Instead it is intended
-    to be used to redirect output to aspen.`
+    is read, as such this mode is not intended to be used as a REPL, see aspen repl.
+    Instead it is intended to be used to redirect output to aspen.`
 
 func OpenFile(path string) ([]rune, error) {
 	bytes, err := os.ReadFile(path)
@@ -88,35 +191,364 @@ func TypeCheckSource(source []rune) (Program, error) {
 	return ast, nil
 }
 
-func ExecuteSource(source []rune) error {
+func OptimizeSource(source []rune) (Program, error) {
 	ast, err := TypeCheckSource(source)
 
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	err = Interpret(ast)
-	return err
+	return Optimize(ast), nil
 }
 
-func ExecuteFile(path string) error {
-	source, err := OpenFile(path)
+func ExecuteSource(source []rune, options RuntimeOptions) error {
+	ast, err := OptimizeSource(source)
+
 	if err != nil {
 		return err
 	}
 
-	err = ExecuteSource(source)
+	return Interpret(ast, source, options)
+}
+
+func CompileSource(source []rune) (*CompiledProgram, error) {
+	ast, err := OptimizeSource(source)
+
+	if err != nil {
+		return nil, err
+	}
+
+	errorReporter := NewErrorReporter(source)
+	program, err := CompileToBytecode(ast, errorReporter)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return program, nil
+}
+
+func ExecuteSourceBytecode(source []rune, options RuntimeOptions) error {
+	program, err := CompileSource(source)
+
 	if err != nil {
 		return err
 	}
 
+	return RunBytecode(program, source, options)
+}
+
+// Options are the command line options of aspen
+type Options struct {
+	interpret   bool
+	bytecode    bool
+	disassemble bool
+	lex         bool
+	parse       bool
+	typeCheck   bool
+	optimized   bool
+	stdin       bool
+	path        string
+	runtime     RuntimeOptions
+
+	// the time the program may run for, zero means no limit
+	timeout time.Duration
+
+	// print a profile report, and write a pprof profile to `pprof` unless it is empty
+	profile bool
+	pprof   string
+
+	// print a coverage report, and merge the coverage into the LCOV file `lcov` unless it is empty
+	coverage bool
+	lcov     string
+
+	// run the program in the debugger, or serve the Debug Adapter Protocol
+	debug bool
+	dap   bool
+
+	// read the program line by line
+	repl bool
+
+	// format the source files at `paths`, writing them in place or only checking that they are formatted
+	format bool
+	write  bool
+	check  bool
+	paths  []string
+
+	// report the problems of the source files at `paths` found by the enabled rules
+	lint         bool
+	enableRules  []string
+	disableRules []string
+	lintRules    map[string]bool
+
+	// print a trace of the program, restricted to the calls to `traceFunctions` unless it is empty
+	trace          bool
+	traceFunctions []string
+}
+
+func ParseOptions(args []string) (*Options, error) {
+	options := &Options{runtime: DefaultRuntimeOptions()}
+
+	if len(args) == 0 || args[0] == "repl" {
+		options.repl = true
+		if len(args) != 0 {
+			args = args[1:]
+		}
+	} else if args[0] == "debug" {
+		options.debug = true
+		args = args[1:]
+	} else if args[0] == "dap" {
+		options.dap = true
+		args = args[1:]
+	} else if args[0] == "fmt" {
+		options.format = true
+		args = args[1:]
+	} else if args[0] == "lint" {
+		options.lint = true
+		args = args[1:]
+	}
+
+	flags := flag.NewFlagSet("aspen", flag.ContinueOnError)
+	flags.SetOutput(io.Discard)
+
+	boolFlag := func(value *bool, short string, long string) {
+		flags.BoolVar(value, short, false, "")
+		flags.BoolVar(value, long, false, "")
+	}
+
+	boolFlag(&options.interpret, "i", "interpret")
+	boolFlag(&options.bytecode, "b", "bytecode")
+	boolFlag(&options.disassemble, "d", "disassemble")
+	boolFlag(&options.lex, "l", "lex")
+	boolFlag(&options.parse, "p", "parse")
+	boolFlag(&options.typeCheck, "t", "type-check")
+	flags.BoolVar(&options.optimized, "dump-optimized", false, "")
+	flags.BoolVar(&options.stdin, "stdin", false, "")
+	flags.IntVar(&options.runtime.maxCallDepth, "max-call-depth", DEFAULT_MAX_CALL_DEPTH, "")
+	flags.Int64Var(&options.runtime.maxSteps, "max-steps", 0, "")
+	flags.Int64Var(&options.runtime.maxAllocation, "max-allocation", 0, "")
+	flags.DurationVar(&options.timeout, "timeout", 0, "")
+	flags.BoolVar(&options.profile, "profile", false, "")
+	flags.StringVar(&options.pprof, "pprof", "", "")
+	flags.BoolVar(&options.coverage, "coverage", false, "")
+	flags.StringVar(&options.lcov, "lcov", "", "")
+	flags.BoolVar(&options.trace, "trace", false, "")
+	flags.Func("trace-function", "", func(names string) error {
+		for _, name := range strings.Split(names, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				options.traceFunctions = append(options.traceFunctions, name)
+			}
+		}
+		options.trace = true
+		return nil
+	})
+
+	if options.format {
+		flags.BoolVar(&options.write, "w", false, "")
+		flags.BoolVar(&options.check, "check", false, "")
+	}
+
+	if options.lint {
+		ruleList := func(rules *[]string) func(string) error {
+			return func(ids string) error {
+				for _, id := range strings.Split(ids, ",") {
+					if id = strings.TrimSpace(id); id != "" {
+						*rules = append(*rules, id)
+					}
+				}
+				return nil
+			}
+		}
+		flags.Func("enable", "", ruleList(&options.enableRules))
+		flags.Func("disable", "", ruleList(&options.disableRules))
+	}
+
+	if err := flags.Parse(args); err != nil {
+		return nil, err
+	}
+
+	if options.format {
+		return options, ParseFormatOptions(options, flags)
+	}
+
+	if options.lint {
+		return options, ParseLintOptions(options, flags)
+	}
+
+	switch {
+	case options.repl:
+		if flags.NArg() != 0 || options.stdin {
+			return nil, errors.New("the repl reads the program from stdin line by line")
+		}
+	case options.dap:
+		if flags.NArg() != 0 || options.stdin {
+			return nil, errors.New("aspen dap debugs the program given by the launch request of the editor")
+		}
+	case flags.NArg() == 1 && flags.Arg(0) == "-":
+		// follow unix's convention that '-' represents stdin
+		options.stdin = true
+	case flags.NArg() == 1 && !options.stdin:
+		options.path = flags.Arg(0)
+	case flags.NArg() == 0 && options.stdin:
+	default:
+		return nil, errors.New("expected the path of one source file")
+	}
+
+	if options.runtime.maxCallDepth < 1 {
+		return nil, errors.New("--max-call-depth must be at least 1")
+	}
+
+	if options.runtime.maxSteps < 0 || options.runtime.maxAllocation < 0 || options.timeout < 0 {
+		return nil, errors.New("limits must not be negative")
+	}
+
+	if (options.profile || options.pprof != "") && options.bytecode {
+		return nil, errors.New("profiling is only supported by the tree walk interpreter")
+	}
+
+	if options.trace && options.bytecode {
+		return nil, errors.New("tracing is only supported by the tree walk interpreter")
+	}
+
+	covering := options.coverage || options.lcov != ""
+	if covering && options.bytecode {
+		return nil, errors.New("coverage is only supported by the tree walk interpreter")
+	}
+
+	observed := options.bytecode || options.profile || options.pprof != "" || options.trace || covering
+	if options.debug && observed {
+		return nil, errors.New("the debugger cannot be combined with -b, profiling, tracing or coverage")
+	}
+
+	if options.dap && observed {
+		return nil, errors.New("the debug adapter cannot be combined with -b, profiling, tracing or coverage")
+	}
+
+	if options.repl && observed {
+		return nil, errors.New("the repl cannot be combined with -b, profiling, tracing or coverage")
+	}
+
+	if options.debug && options.stdin {
+		return nil, errors.New("the debugger reads its commands from stdin, the program must be read from a file")
+	}
+
+	return options, nil
+}
+
+// ParseFormatOptions checks the options of aspen fmt, which takes any number of paths
+func ParseFormatOptions(options *Options, flags *flag.FlagSet) error {
+	if err := AcceptFlags("aspen fmt", flags, "w", "check", "stdin"); err != nil {
+		return err
+	}
+
+	if options.write && options.check {
+		return errors.New("-w and --check cannot be combined")
+	}
+
+	ParsePaths(options, flags)
+
+	switch {
+	case options.stdin && len(options.paths) != 0:
+		return errors.New("aspen fmt formats either stdin or files")
+	case options.stdin && options.write:
+		return errors.New("-w cannot be used with stdin")
+	case !options.stdin && len(options.paths) == 0:
+		return errors.New("expected the paths of the source files to format")
+	}
+	return nil
+}
+
+// ParseLintOptions checks the options of aspen lint, which takes any number of paths, and works out the rules it
+// reports: all of them unless --enable lists some, without the rules listed by --disable
+func ParseLintOptions(options *Options, flags *flag.FlagSet) error {
+	if err := AcceptFlags("aspen lint", flags, "enable", "disable", "stdin"); err != nil {
+		return err
+	}
+
+	for _, id := range append(options.enableRules, options.disableRules...) {
+		if !IsLintRule(id) {
+			return fmt.Errorf("unknown lint rule '%s'", id)
+		}
+	}
+
+	options.lintRules = make(map[string]bool)
+	for _, rule := range LintRules {
+		options.lintRules[rule.id] = len(options.enableRules) == 0
+	}
+	for _, id := range options.enableRules {
+		options.lintRules[id] = true
+	}
+	for _, id := range options.disableRules {
+		options.lintRules[id] = false
+	}
+
+	ParsePaths(options, flags)
+
+	switch {
+	case options.stdin && len(options.paths) != 0:
+		return errors.New("aspen lint checks either stdin or files")
+	case !options.stdin && len(options.paths) == 0:
+		return errors.New("expected the paths of the source files to lint")
+	}
+	return nil
+}
+
+// AcceptFlags returns an error if a flag other than the `accepted` flags of a command was given
+func AcceptFlags(command string, flags *flag.FlagSet, accepted ...string) error {
+	var other string
+	flags.Visit(func(flag *flag.Flag) {
+		for _, name := range accepted {
+			if flag.Name == name {
+				return
+			}
+		}
+
+		other = "--" + flag.Name
+		if len(flag.Name) == 1 {
+			other = "-" + flag.Name
+		}
+	})
+	if other != "" {
+		return fmt.Errorf("%s does not accept the option %s", command, other)
+	}
 	return nil
 }
 
+// ParsePaths sets the paths of the source files given to a command, '-' stands for stdin
+func ParsePaths(options *Options, flags *flag.FlagSet) {
+	for _, path := range flags.Args() {
+		if path == "-" {
+			options.stdin = true
+		} else {
+			options.paths = append(options.paths, path)
+		}
+	}
+}
+
+// the exit codes of aspen, a program that exceeded one of its limits exits with the code of that limit
+const (
+	EXIT_ERROR            = 1
+	EXIT_STEP_LIMIT       = 3
+	EXIT_TIME_LIMIT       = 4
+	EXIT_ALLOCATION_LIMIT = 5
+)
+
+func ExitCode(err error) int {
+	switch {
+	case errors.Is(err, ErrStepLimit):
+		return EXIT_STEP_LIMIT
+	case errors.Is(err, context.DeadlineExceeded):
+		return EXIT_TIME_LIMIT
+	case errors.Is(err, ErrAllocationLimit):
+		return EXIT_ALLOCATION_LIMIT
+	}
+	return EXIT_ERROR
+}
+
 func Check(err error) {
 	if err != nil {
 		fmt.Fprintln(os.Stderr, err)
-		os.Exit(1)
+		os.Exit(ExitCode(err))
 	}
 }
 
@@ -145,7 +577,7 @@ func Initialize() {
 
 	DefineNativeFunction(SimpleFunction(TYPE_I64, TYPE_STRING), "atoi", func(args []interface{}) interface{} {
 		arg0 := string(args[0].([]rune))
-		i, _ := strconv.Atoi(arg0)
+		i, _ := strconv.ParseInt(arg0, 10, 64)
 		return i
 	})
 
@@ -155,6 +587,10 @@ func Initialize() {
 		return f
 	})
 
+	// slice related functions
+
+	InitializeBuiltins()
+
 	// type casting
 
 	AddConversion(SimpleType(TYPE_I64), SimpleType(TYPE_U64), func(from interface{}) interface{} {
@@ -191,48 +627,295 @@ func Initialize() {
 func main() {
 	Initialize()
 
-	if len(os.Args) == 2 {
-		if os.Args[1] == "--stdin" || os.Args[1] == "-" /* follow unix's convention that '-' represents stdin */ {
-			bytes, err := ioutil.ReadAll(os.Stdin)
-			Check(err)
-			source := []rune(string(bytes))
-			err = ExecuteSource(source)
-			Check(err)
-		} else {
-			path := os.Args[1]
-			err := ExecuteFile(path)
-			Check(err)
+	options, err := ParseOptions(os.Args[1:])
+	if err == flag.ErrHelp {
+		fmt.Println(helpString)
+		return
+	} else if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n\n", err)
+		fmt.Println(helpString)
+		os.Exit(1)
+	}
+
+	if options.timeout != 0 {
+		ctx, cancel := context.WithTimeout(context.Background(), options.timeout)
+		defer cancel()
+		options.runtime.context = ctx
+	}
+
+	if options.dap {
+		Check(ServeDap(options.runtime))
+		return
+	}
+
+	if options.repl {
+		NewRepl(os.Stdin, os.Stdout, options.runtime).Run()
+		return
+	}
+
+	if options.format {
+		Check(FormatFiles(options))
+		return
+	}
+
+	if options.lint {
+		Check(LintFiles(options))
+		return
+	}
+
+	var source []rune
+	if options.stdin {
+		bytes, err := io.ReadAll(os.Stdin)
+		Check(err)
+		source = []rune(string(bytes))
+	} else {
+		source, err = OpenFile(options.path)
+		Check(err)
+	}
+
+	if options.trace {
+		options.runtime.observer = NewTracer(os.Stderr, source, options.traceFunctions)
+	}
+
+	switch {
+	case options.lex:
+		tokens, err := ScanSource(source)
+		Check(err)
+
+		fmt.Println(tokens)
+	case options.parse:
+		ast, err := ParseSource(source)
+		Check(err)
+
+		fmt.Println(ast)
+	case options.typeCheck:
+		_, err := TypeCheckSource(source)
+		Check(err)
+	case options.optimized:
+		ast, err := OptimizeSource(source)
+		Check(err)
+
+		fmt.Println(ast)
+	case options.disassemble:
+		program, err := CompileSource(source)
+		Check(err)
+
+		fmt.Print(program.Disassemble())
+	case options.bytecode:
+		err = ExecuteSourceBytecode(source, options.runtime)
+		Check(err)
+	case options.debug:
+		ast, err := TypeCheckSource(source)
+		Check(err)
+
+		err = NewDebugConsole(NewDebugger(source), os.Stdin, os.Stdout).Run(ast, options.runtime)
+		Check(err)
+	case options.coverage || options.lcov != "":
+		err = CoverSource(source, options)
+		Check(err)
+	case options.profile || options.pprof != "":
+		err = ProfileSource(source, options)
+		Check(err)
+	default:
+		err = ExecuteSource(source, options.runtime)
+		Check(err)
+	}
+}
+
+// ProfileSource executes a program with a profiler, the profile is reported even if the program fails
+func ProfileSource(source []rune, options *Options) error {
+	file := options.path
+	if options.stdin {
+		file = "<stdin>"
+	}
+
+	profiler := NewProfiler(source, file)
+	options.runtime.Observe(profiler)
+	err := ExecuteSource(source, options.runtime)
+	profiler.Finish()
+
+	if options.profile {
+		fmt.Fprint(os.Stderr, profiler.Report())
+	}
+
+	if options.pprof != "" {
+		output, createErr := os.Create(options.pprof)
+		if createErr != nil {
+			return fmt.Errorf("error: cannot create file %s", options.pprof)
 		}
-	} else if len(os.Args) == 3 {
-		flag := os.Args[1]
+		defer output.Close()
 
-		path := os.Args[2]
+		if writeErr := profiler.WritePprof(output); writeErr != nil {
+			return fmt.Errorf("error: cannot write profile to %s", options.pprof)
+		}
+	}
+
+	return err
+}
+
+// CoverSource executes a program while recording its coverage, the coverage is reported even if the program fails
+func CoverSource(source []rune, options *Options) error {
+	file := "<stdin>"
+	if !options.stdin {
+		file, _ = filepath.Abs(options.path)
+	}
+
+	coverage := NewCoverage(source, file)
+	options.runtime.Observe(coverage)
+
+	var err error
+	if options.profile || options.pprof != "" {
+		err = ProfileSource(source, options)
+	} else {
+		err = ExecuteSource(source, options.runtime)
+	}
+
+	if !coverage.attached {
+		return err
+	}
+
+	if options.coverage {
+		fmt.Fprint(os.Stderr, coverage.Report())
+	}
+
+	if options.lcov != "" {
+		if lcovErr := MergeLcovFile(options.lcov, coverage.Lcov()); lcovErr != nil {
+			return lcovErr
+		}
+	}
+
+	return err
+}
+
+// FormatFiles formats the source files, or stdin, given to aspen fmt
+func FormatFiles(options *Options) error {
+	if options.stdin {
+		bytes, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			return err
+		}
+
+		source := []rune(string(bytes))
+		formatted, err := FormatSource(source)
+		if err != nil {
+			return err
+		}
+
+		if !options.check {
+			fmt.Print(formatted)
+		} else if formatted != string(source) {
+			fmt.Println("<stdin>")
+			return errors.New("error: <stdin> is not formatted")
+		}
+		return nil
+	}
+
+	paths, err := SourceFiles(options.paths)
+	if err != nil {
+		return err
+	}
+
+	unformatted := 0
+	for _, path := range paths {
 		source, err := OpenFile(path)
-		Check(err)
+		if err != nil {
+			return err
+		}
 
-		switch flag {
-		case "-i", "--interpret":
-			err = ExecuteSource(source)
-			Check(err)
-		case "-l", "--lex":
-			tokens, err := ScanSource(source)
-			Check(err)
-
-			fmt.Println(tokens)
-		case "-p", "--parse":
-			ast, err := ParseSource(source)
-			Check(err)
-
-			fmt.Println(ast)
-		case "-t", "--type-check":
-			_, err := TypeCheckSource(source)
-			Check(err)
-
-			os.Exit(0)
+		formatted, err := FormatSource(source)
+		if err != nil {
+			return fmt.Errorf("%s: %v", path, err)
+		}
+
+		switch {
+		case options.check:
+			if formatted != string(source) {
+				fmt.Println(path)
+				unformatted++
+			}
+		case options.write:
+			if formatted != string(source) {
+				if err := os.WriteFile(path, []byte(formatted), 0644); err != nil {
+					return fmt.Errorf("error: cannot write file %s", path)
+				}
+			}
 		default:
-			fmt.Println(helpString)
+			fmt.Print(formatted)
+		}
+	}
+
+	if unformatted != 0 {
+		return fmt.Errorf("error: %d of %d files are not formatted", unformatted, len(paths))
+	}
+	return nil
+}
+
+// LintFiles prints the problems of the source files, or stdin, given to aspen lint
+func LintFiles(options *Options) error {
+	paths := []string{"<stdin>"}
+	if !options.stdin {
+		var err error
+		if paths, err = SourceFiles(options.paths); err != nil {
+			return err
+		}
+	}
+
+	problems := 0
+	for _, path := range paths {
+		var source []rune
+		if options.stdin {
+			bytes, err := io.ReadAll(os.Stdin)
+			if err != nil {
+				return err
+			}
+			source = []rune(string(bytes))
+		} else {
+			var err error
+			if source, err = OpenFile(path); err != nil {
+				return err
+			}
+		}
+
+		warnings, err := LintSource(source, options.lintRules)
+		if err != nil {
+			return fmt.Errorf("%s: %v", path, err)
+		}
+
+		for _, warning := range warnings {
+			fmt.Printf("%s:%d:%d: %s [%s]\n", path, warning.loc.line, warning.loc.col, warning.message, warning.rule)
+		}
+		problems += len(warnings)
+	}
+
+	if problems != 0 {
+		return fmt.Errorf("error: %d problems found", problems)
+	}
+	return nil
+}
+
+// SourceFiles returns the paths of the source files given to aspen fmt or aspen lint, with the .aspen files of directories
+func SourceFiles(paths []string) ([]string, error) {
+	files := []string{}
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, fmt.Errorf("error: cannot open file %s", path)
+		}
+
+		if !info.IsDir() {
+			files = append(files, path)
+			continue
+		}
+
+		err = filepath.WalkDir(path, func(file string, entry os.DirEntry, err error) error {
+			if err == nil && !entry.IsDir() && filepath.Ext(file) == ".aspen" {
+				files = append(files, file)
+			}
+			return err
+		})
+		if err != nil {
+			return nil, fmt.Errorf("error: cannot read directory %s", path)
 		}
-	} else {
-		fmt.Println(helpString)
 	}
+	return files, nil
 }
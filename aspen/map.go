@@ -0,0 +1,121 @@
+package main
+
+// MapValue is the runtime representation of a map. Entries are kept in insertion order so that
+// iterating over a map is deterministic.
+type MapValue struct {
+	keys   []interface{}
+	values []interface{}
+
+	// maps the hash key of an entry to its position in `keys` and `values`
+	index map[interface{}]int
+
+	// the number of deleted entries still in `keys` and `values`, whose keys are tombstones
+	deleted int
+}
+
+// tombstone replaces the key of a deleted entry until the entries are compacted, so that deleting an entry does not
+// move the entries after it
+type tombstone struct{}
+
+func NewMapValue() *MapValue {
+	return &MapValue{index: make(map[interface{}]int)}
+}
+
+// HashKey converts a key to a value that Go can use as a map key. Two keys have the same hash
+// key exactly when they are equal according to `ValuesEqual`.
+func HashKey(key interface{}) interface{} {
+	switch v := key.(type) {
+	case []rune:
+		return string(v)
+	case int64, uint64, bool, float64:
+		return v
+	}
+
+	Unreachable("map.go: HashKey")
+	return nil
+}
+
+func (m *MapValue) Len() int {
+	return len(m.index)
+}
+
+func (m *MapValue) Get(key interface{}) (interface{}, bool) {
+	if i, ok := m.index[HashKey(key)]; ok {
+		return m.values[i], true
+	}
+	return nil, false
+}
+
+func (m *MapValue) Has(key interface{}) bool {
+	_, ok := m.index[HashKey(key)]
+	return ok
+}
+
+// Set sets the value of a key, it reports whether the key is new to the map
+func (m *MapValue) Set(key interface{}, value interface{}) bool {
+	hash := HashKey(key)
+	if i, ok := m.index[hash]; ok {
+		m.values[i] = value
+		return false
+	}
+
+	m.index[hash] = len(m.keys)
+	m.keys = append(m.keys, key)
+	m.values = append(m.values, value)
+	return true
+}
+
+func (m *MapValue) Delete(key interface{}) {
+	hash := HashKey(key)
+	i, ok := m.index[hash]
+	if !ok {
+		return
+	}
+
+	delete(m.index, hash)
+	m.keys[i] = tombstone{}
+	m.values[i] = nil
+	m.deleted++
+
+	// compacting once half of the entries are deleted keeps deletion amortized constant time
+	if 2*m.deleted > len(m.keys) {
+		m.Compact()
+	}
+}
+
+// Compact removes the deleted entries, moving the other entries back
+func (m *MapValue) Compact() {
+	if m.deleted == 0 {
+		return
+	}
+
+	keys := make([]interface{}, 0, len(m.index))
+	values := make([]interface{}, 0, len(m.index))
+	for i, key := range m.keys {
+		if _, ok := key.(tombstone); ok {
+			continue
+		}
+
+		m.index[HashKey(key)] = len(keys)
+		keys = append(keys, key)
+		values = append(values, m.values[i])
+	}
+
+	m.keys = keys
+	m.values = values
+	m.deleted = 0
+}
+
+// Entries returns the keys and the values of the map in insertion order, which must not be modified
+func (m *MapValue) Entries() ([]interface{}, []interface{}) {
+	m.Compact()
+	return m.keys, m.values
+}
+
+// Keys returns a copy of the keys of the map in insertion order
+func (m *MapValue) Keys() []interface{} {
+	entries, _ := m.Entries()
+	keys := make([]interface{}, len(entries))
+	copy(keys, entries)
+	return keys
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMapDelete(t *testing.T) {
+	m := NewMapValue()
+	for i := int64(0); i < 10; i++ {
+		m.Set(i, i*i)
+	}
+
+	for _, key := range []int64{0, 3, 4, 5, 7, 8, 42} {
+		m.Delete(key)
+	}
+	m.Set(int64(3), int64(-3))
+
+	keys, values := m.Entries()
+	if expected := []interface{}{int64(1), int64(2), int64(6), int64(9), int64(3)}; !reflect.DeepEqual(keys, expected) {
+		t.Errorf("expected keys %v, got %v", expected, keys)
+	}
+	if expected := []interface{}{int64(1), int64(4), int64(36), int64(81), int64(-3)}; !reflect.DeepEqual(values, expected) {
+		t.Errorf("expected values %v, got %v", expected, values)
+	}
+
+	if m.Len() != 5 || m.Has(int64(4)) || !m.Has(int64(9)) {
+		t.Errorf("expected 5 entries without 4, got %s", FormatValue(m))
+	}
+	if value, _ := m.Get(int64(9)); value != int64(81) {
+		t.Errorf("expected 81, got %v", value)
+	}
+}
+
+func BenchmarkMapDelete(b *testing.B) {
+	for n := 0; n < b.N; n++ {
+		m := NewMapValue()
+		for i := int64(0); i < 10000; i++ {
+			m.Set(i, i)
+		}
+		for i := int64(0); i < 10000; i++ {
+			m.Delete(i)
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+// Observer is notified by the interpreter as it runs a program, tools such as the profiler watch a program
+// through it without changing how the program runs
+type Observer interface {
+	// Statement is called before a statement is executed
+	Statement(stmt Statement)
+
+	// Call is called before a function is called at `callSite`
+	Call(function AspenFunction, arguments []interface{}, callSite Token)
+
+	// TailCall is called when the innermost call is replaced by a tail call to `function`
+	TailCall(function AspenFunction, arguments []interface{}, callSite Token)
+
+	// Return is called when the innermost call returns `value`
+	Return(value interface{})
+
+	// Assign is called when the variable `name` is assigned `value` in place of `old`
+	Assign(name Token, old interface{}, value interface{})
+
+	// Branch is called each time the condition of an if or while statement is evaluated to `taken`
+	Branch(stmt Statement, taken bool)
+}
+
+// LayoutObserver is an observer that needs the layout of the program, with the scope of each statement, before
+// the program runs
+type LayoutObserver interface {
+	Observer
+
+	// Attach is called by the interpreter before it runs the program
+	Attach(interpreter *Interpreter, layout *ProgramLayout)
+}
+
+// StatementPosition returns the position of a statement, its line is 0 for statements that were not written in
+// the source code
+func StatementPosition(stmt Statement) Token {
+	switch s := stmt.(type) {
+	case *ExpressionStatement:
+		return ExpressionPosition(s.expr)
+	case *PrintStatement:
+		return s.loc
+	case *LetStatement:
+		if s.names != nil {
+			return s.names[0]
+		}
+		return s.name
+	case *IfStatement:
+		return s.loc
+	case *WhileStatement:
+		return s.loc
+	case *FunctionStatement:
+		return s.name
+	case *ReturnStatement:
+		return s.loc
+	case *BreakStatement:
+		return s.loc
+	case *ContinueStatement:
+		return s.loc
+	case *StructStatement:
+		return s.name
+	}
+	return Token{}
+}
+
+// ExpressionPosition returns the position of an expression, its line is 0 for expressions that were not written
+// in the source code
+func ExpressionPosition(expr Expression) Token {
+	switch e := expr.(type) {
+	case *BinaryExpression:
+		return ExpressionPosition(e.left)
+	case *UnaryExpression:
+		return e.operator
+	case *LiteralExpression:
+		return e.value
+	case *GroupingExpression:
+		return ExpressionPosition(e.expr)
+	case *IdentifierExpression:
+		return e.name
+	case *AssignmentExpression:
+		return e.name
+	case *CallExpression:
+		return ExpressionPosition(e.callee)
+	case *TypeCastExpression:
+		return e.loc
+	case *SubscriptExpression:
+		return ExpressionPosition(e.object)
+	case *SubscriptAssignmentExpression:
+		return ExpressionPosition(e.target)
+	case *SliceLiteralExpression:
+		return e.loc
+	case *FieldExpression:
+		return ExpressionPosition(e.object)
+	case *FieldAssignmentExpression:
+		return ExpressionPosition(e.target)
+	case *StructLiteralExpression:
+		return e.loc
+	case *MapLiteralExpression:
+		return e.loc
+	case *TupleExpression:
+		return e.loc
+	case *FunctionLiteralExpression:
+		return e.function.name
+	}
+	return Token{}
+}
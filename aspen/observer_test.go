@@ -0,0 +1,42 @@
+package main
+
+import (
+	"testing"
+)
+
+// ObserveSource runs a program with the tree walk interpreter while `observer` watches it
+func ObserveSource(t *testing.T, source string, observer Observer) {
+	ast, err := OptimizeSource([]rune(source))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	options := DefaultRuntimeOptions()
+	options.observer = observer
+	if err := Interpret(ast, []rune(source), options); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// BuggyObserver panics before the first statement, like a bug in the interpreter would
+type BuggyObserver struct {
+	*Profiler
+}
+
+func (o BuggyObserver) Statement(stmt Statement) {
+	panic("bug")
+}
+
+func TestInterpreterBug(t *testing.T) {
+	Initialize()
+
+	defer func() {
+		if r := recover(); r != "bug" {
+			t.Errorf("expected the panic of the bug to be raised again, got %v", r)
+		}
+	}()
+
+	source := "print 1;"
+	ObserveSource(t, source, BuggyObserver{NewProfiler([]rune(source), "bug.aspen")})
+	t.Error("expected the bug to stop the interpreter")
+}
@@ -0,0 +1,372 @@
+package main
+
+import "math"
+
+// Optimizer rewrites a type checked program so that it does less work at runtime. Operations whose operands are
+// constants are folded into literals with the same operators the interpreter uses, and arithmetic with an
+// identity element is simplified. Operations that fail at runtime, such as an integer division by zero, are left
+// for the interpreter to report.
+type Optimizer struct{}
+
+// Optimize rewrites a type checked program in place and returns it
+func Optimize(ast Program) Program {
+	optimizer := &Optimizer{}
+	for _, stmt := range ast {
+		optimizer.VisitStatementNode(stmt)
+	}
+	return ast
+}
+
+// Constant returns the value of an expression if it is a constant
+func Constant(expr Expression) (interface{}, bool) {
+	switch e := expr.(type) {
+	case *LiteralExpression:
+		switch e.value.tokenType {
+		case TOKEN_TRUE:
+			return true, true
+		case TOKEN_FALSE:
+			return false, true
+		case TOKEN_INT_LITERAL, TOKEN_FLOAT_LITERAL, TOKEN_STRING_LITERAL:
+			return e.value.value, true
+		}
+	case *TypeCastExpression:
+		// there are no u64 literals, u64 constants are i64 literals cast to u64
+		literal, ok := e.value.(*LiteralExpression)
+		if ok && literal.value.tokenType == TOKEN_INT_LITERAL && e.from.kind == TYPE_I64 && e.to.kind == TYPE_U64 {
+			return uint64(literal.value.value.(int64)), true
+		}
+	}
+	return nil, false
+}
+
+// ConstantExpression returns the expression of the constant `value`, at the position of `loc`
+func ConstantExpression(value interface{}, loc Token) Expression {
+	literal := func(tokenType TokenType, value interface{}) *LiteralExpression {
+		return &LiteralExpression{value: Token{tokenType: tokenType, line: loc.line, col: loc.col, value: value}}
+	}
+
+	switch v := value.(type) {
+	case bool:
+		if v {
+			return literal(TOKEN_TRUE, nil)
+		}
+		return literal(TOKEN_FALSE, nil)
+	case int64:
+		return literal(TOKEN_INT_LITERAL, v)
+	case uint64:
+		return &TypeCastExpression{
+			from:  SimpleType(TYPE_I64),
+			to:    SimpleType(TYPE_U64),
+			value: literal(TOKEN_INT_LITERAL, int64(v)),
+			loc:   loc,
+		}
+	case float64:
+		return literal(TOKEN_FLOAT_LITERAL, v)
+	case []rune:
+		return literal(TOKEN_STRING_LITERAL, v)
+	}
+
+	Unreachable("optimizer.go: ConstantExpression")
+	return nil
+}
+
+// BinaryOperators are the binary operators that can be folded, && and || are folded separately because they
+// short circuit
+var BinaryOperators = map[TokenType]func(lhs, rhs interface{}) interface{}{
+	TOKEN_EQUAL_EQUAL: func(lhs, rhs interface{}) interface{} {
+		return ValuesEqual(lhs, rhs)
+	},
+	TOKEN_BANG_EQUAL: func(lhs, rhs interface{}) interface{} {
+		return !ValuesEqual(lhs, rhs)
+	},
+	TOKEN_GREATER:       OperatorGreater,
+	TOKEN_GREATER_EQUAL: OperatorGreaterEqual,
+	TOKEN_LESS:          OperatorLess,
+	TOKEN_LESS_EQUAL:    OperatorLessEqual,
+	TOKEN_PIPE:          OperatorPipe,
+	TOKEN_CARET:         OperatorCaret,
+	TOKEN_PERCENT:       OperatorModulus,
+	TOKEN_AMP:           OperatorAmp,
+	TOKEN_MINUS:         OperatorMinus,
+	TOKEN_SLASH:         OperatorSlash,
+	TOKEN_STAR:          OperatorStar,
+	TOKEN_PLUS: func(lhs, rhs interface{}) interface{} {
+		if s, ok := lhs.([]rune); ok {
+			return AddString(s, rhs.([]rune))
+		}
+		return OperatorPlus(lhs, rhs)
+	},
+}
+
+func IsOne(value interface{}) bool {
+	switch v := value.(type) {
+	case int64:
+		return v == 1
+	case uint64:
+		return v == 1
+	case float64:
+		return v == 1
+	}
+	return false
+}
+
+// IsRightIdentity reports whether `x <operator> value` is x for every x
+func IsRightIdentity(operator TokenType, value interface{}) bool {
+	switch operator {
+	case TOKEN_PLUS, TOKEN_PIPE, TOKEN_CARET:
+		// x + 0.0 is not x when x is -0.0
+		return IsIntegerZero(value)
+	case TOKEN_MINUS:
+		if v, ok := value.(float64); ok {
+			return v == 0 && !math.Signbit(v)
+		}
+		return IsIntegerZero(value)
+	case TOKEN_STAR, TOKEN_SLASH:
+		return IsOne(value)
+	}
+	return false
+}
+
+// IsLeftIdentity reports whether `value <operator> x` is x for every x
+func IsLeftIdentity(operator TokenType, value interface{}) bool {
+	switch operator {
+	case TOKEN_PLUS, TOKEN_PIPE, TOKEN_CARET:
+		return IsIntegerZero(value)
+	case TOKEN_STAR:
+		return IsOne(value)
+	}
+	return false
+}
+
+// Fold optimizes an expression and returns the expression that replaces it
+func (o *Optimizer) Fold(expr Expression) Expression {
+	return expr.Accept(o).(Expression)
+}
+
+func (o *Optimizer) FoldAll(exprs []Expression) {
+	for i := range exprs {
+		exprs[i] = o.Fold(exprs[i])
+	}
+}
+
+func (o *Optimizer) VisitExpressionNode(expr Expression) interface{} {
+	return expr.Accept(o)
+}
+
+func (o *Optimizer) VisitStatementNode(stmt Statement) interface{} {
+	return stmt.Accept(o)
+}
+
+func (o *Optimizer) VisitBinary(expr *BinaryExpression) interface{} {
+	expr.left = o.Fold(expr.left)
+	expr.right = o.Fold(expr.right)
+
+	lhs, lhsConstant := Constant(expr.left)
+	rhs, rhsConstant := Constant(expr.right)
+
+	switch expr.operator.tokenType {
+	case TOKEN_AMP_AMP, TOKEN_PIPE_PIPE:
+		// `true && x` and `false || x` are x, `false && x` and `true || x` do not evaluate x
+		identity := expr.operator.tokenType == TOKEN_AMP_AMP
+		if lhsConstant && lhs.(bool) == identity {
+			return expr.right
+		} else if lhsConstant {
+			return expr.left
+		}
+
+		// x is still evaluated in `x && false` and `x || true`
+		if rhsConstant && rhs.(bool) == identity {
+			return expr.left
+		}
+		return expr
+	case TOKEN_SLASH, TOKEN_PERCENT:
+		if rhsConstant && IsIntegerZero(rhs) {
+			// the division fails at runtime
+			return expr
+		}
+	}
+
+	if lhsConstant && rhsConstant {
+		return ConstantExpression(BinaryOperators[expr.operator.tokenType](lhs, rhs), expr.operator)
+	}
+
+	if rhsConstant && IsRightIdentity(expr.operator.tokenType, rhs) {
+		return expr.left
+	}
+
+	if lhsConstant && IsLeftIdentity(expr.operator.tokenType, lhs) {
+		return expr.right
+	}
+
+	return expr
+}
+
+func (o *Optimizer) VisitUnary(expr *UnaryExpression) interface{} {
+	expr.operand = o.Fold(expr.operand)
+
+	if value, ok := Constant(expr.operand); ok {
+		switch expr.operator.tokenType {
+		case TOKEN_BANG:
+			return ConstantExpression(!value.(bool), expr.operator)
+		case TOKEN_MINUS:
+			return ConstantExpression(Negate(value), expr.operator)
+		}
+	}
+
+	// !!x and -(-x) are x
+	if operand, ok := expr.operand.(*UnaryExpression); ok && operand.operator.tokenType == expr.operator.tokenType {
+		return operand.operand
+	}
+
+	return expr
+}
+
+func (o *Optimizer) VisitLiteral(expr *LiteralExpression) interface{} {
+	return expr
+}
+
+func (o *Optimizer) VisitGrouping(expr *GroupingExpression) interface{} {
+	// the parser already took the grouping into account
+	return o.Fold(expr.expr)
+}
+
+func (o *Optimizer) VisitIdentifier(expr *IdentifierExpression) interface{} {
+	return expr
+}
+
+func (o *Optimizer) VisitAssignment(expr *AssignmentExpression) interface{} {
+	expr.value = o.Fold(expr.value)
+	return expr
+}
+
+func (o *Optimizer) VisitCall(expr *CallExpression) interface{} {
+	expr.callee = o.Fold(expr.callee)
+	o.FoldAll(expr.arguments)
+	return expr
+}
+
+func (o *Optimizer) VisitTypeCast(expr *TypeCastExpression) interface{} {
+	expr.value = o.Fold(expr.value)
+
+	if value, ok := Constant(expr.value); ok {
+		return ConstantExpression(GetHandler(expr.from, expr.to)(value), expr.loc)
+	}
+
+	return expr
+}
+
+func (o *Optimizer) VisitSubscript(expr *SubscriptExpression) interface{} {
+	expr.object = o.Fold(expr.object)
+	expr.index = o.Fold(expr.index)
+	return expr
+}
+
+func (o *Optimizer) VisitSubscriptAssignment(expr *SubscriptAssignmentExpression) interface{} {
+	o.VisitSubscript(expr.target)
+	expr.value = o.Fold(expr.value)
+	return expr
+}
+
+func (o *Optimizer) VisitSliceLiteral(expr *SliceLiteralExpression) interface{} {
+	o.FoldAll(expr.elements)
+	return expr
+}
+
+func (o *Optimizer) VisitField(expr *FieldExpression) interface{} {
+	expr.object = o.Fold(expr.object)
+	return expr
+}
+
+func (o *Optimizer) VisitFieldAssignment(expr *FieldAssignmentExpression) interface{} {
+	o.VisitField(expr.target)
+	expr.value = o.Fold(expr.value)
+	return expr
+}
+
+func (o *Optimizer) VisitStructLiteral(expr *StructLiteralExpression) interface{} {
+	o.FoldAll(expr.values)
+	return expr
+}
+
+func (o *Optimizer) VisitMapLiteral(expr *MapLiteralExpression) interface{} {
+	o.FoldAll(expr.keys)
+	o.FoldAll(expr.values)
+	return expr
+}
+
+func (o *Optimizer) VisitTuple(expr *TupleExpression) interface{} {
+	o.FoldAll(expr.elements)
+	return expr
+}
+
+func (o *Optimizer) VisitFunctionLiteral(expr *FunctionLiteralExpression) interface{} {
+	o.VisitFunction(expr.function)
+	return expr
+}
+
+func (o *Optimizer) VisitExpression(stmt *ExpressionStatement) interface{} {
+	stmt.expr = o.Fold(stmt.expr)
+	return nil
+}
+
+func (o *Optimizer) VisitPrint(stmt *PrintStatement) interface{} {
+	stmt.expr = o.Fold(stmt.expr)
+	return nil
+}
+
+func (o *Optimizer) VisitLet(stmt *LetStatement) interface{} {
+	stmt.initializer = o.Fold(stmt.initializer)
+	return nil
+}
+
+func (o *Optimizer) VisitBlock(stmt *BlockStatement) interface{} {
+	for _, stmt := range stmt.statements {
+		o.VisitStatementNode(stmt)
+	}
+	return nil
+}
+
+func (o *Optimizer) VisitIf(stmt *IfStatement) interface{} {
+	stmt.condition = o.Fold(stmt.condition)
+	o.VisitStatementNode(stmt.thenBranch)
+	if stmt.elseBranch != nil {
+		o.VisitStatementNode(stmt.elseBranch)
+	}
+	return nil
+}
+
+func (o *Optimizer) VisitWhile(stmt *WhileStatement) interface{} {
+	stmt.condition = o.Fold(stmt.condition)
+	o.VisitStatementNode(stmt.body)
+	if stmt.increment != nil {
+		stmt.increment = o.Fold(stmt.increment)
+	}
+	return nil
+}
+
+func (o *Optimizer) VisitBreak(stmt *BreakStatement) interface{} {
+	return nil
+}
+
+func (o *Optimizer) VisitContinue(stmt *ContinueStatement) interface{} {
+	return nil
+}
+
+func (o *Optimizer) VisitFunction(stmt *FunctionStatement) interface{} {
+	o.VisitBlock(stmt.body)
+	return nil
+}
+
+func (o *Optimizer) VisitReturn(stmt *ReturnStatement) interface{} {
+	if stmt.value != nil {
+		stmt.value = o.Fold(stmt.value)
+	}
+	return nil
+}
+
+func (o *Optimizer) VisitStruct(stmt *StructStatement) interface{} {
+	for _, method := range stmt.methods {
+		o.VisitFunction(method)
+	}
+	return nil
+}
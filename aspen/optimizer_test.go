@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+type OptimizerTestCase struct {
+	fileName string
+	source   string
+	expect   string
+}
+
+func (tc *OptimizerTestCase) Run(t *testing.T) {
+	if tc == nil {
+		return
+	}
+
+	ast, err := OptimizeSource([]rune(tc.source))
+
+	if err != nil {
+		t.Errorf("%s: failed to optimize source code\n %v", tc.fileName, err)
+		return
+	}
+
+	astString := ast.String()
+
+	if astString != tc.expect {
+		t.Errorf("%s: expected optimized ast to be %s, got %s", tc.fileName, tc.expect, astString)
+		return
+	}
+}
+
+func NewOptimizerTestCase(file string) TestCase {
+	data, err := os.ReadFile(file)
+	content := string(data)
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to open %s\n", file)
+		return nil
+	}
+
+	i := strings.Index(content, "\n")
+
+	return &OptimizerTestCase{file, content[i+1:], content[:i]}
+}
+
+func TestOptimizer(t *testing.T) {
+	Initialize()
+
+	matches, err := filepath.Glob("test_cases/optimizer/*.txt")
+
+	if err != nil {
+		t.Error("could not glob files")
+		return
+	}
+
+	for _, match := range matches {
+		fmt.Printf("%s\n", match)
+		tc := NewOptimizerTestCase(match)
+		tc.Run(t)
+	}
+}
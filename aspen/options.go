@@ -0,0 +1,42 @@
+package main
+
+import (
+	"context"
+	"fmt"
+)
+
+// DEFAULT_MAX_CALL_DEPTH is the default maximum number of nested calls to user defined functions. Deeper
+// recursion fails with a stack overflow error rather than exhausting the Go stack.
+const DEFAULT_MAX_CALL_DEPTH = 100000
+
+// RuntimeOptions configures the execution of a program by the interpreter or the bytecode vm
+type RuntimeOptions struct {
+	maxCallDepth int
+
+	// the limits enforced by a Budget, zero means no limit
+	maxSteps      int64
+	maxAllocation int64
+
+	// the program stops with a runtime error once the context is done, nil if it has no deadline
+	context context.Context
+
+	// watches the program as it runs, only the interpreter supports observers
+	observer Observer
+}
+
+func DefaultRuntimeOptions() RuntimeOptions {
+	return RuntimeOptions{maxCallDepth: DEFAULT_MAX_CALL_DEPTH}
+}
+
+// Observe adds an observer to the observers of the program
+func (o *RuntimeOptions) Observe(observer Observer) {
+	if o.observer != nil {
+		o.observer = Observers{o.observer, observer}
+	} else {
+		o.observer = observer
+	}
+}
+
+func StackOverflowMessage(maxCallDepth int) string {
+	return fmt.Sprintf("stack overflow, exceeded the maximum call depth of %d.", maxCallDepth)
+}
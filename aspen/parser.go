@@ -4,6 +4,12 @@ type Parser struct {
 	tokens        TokenStream
 	current       int
 	errorReporter ErrorReporter
+
+	// the syntax the program was written with, nil unless it is recorded
+	syntax *Syntax
+	// the comments before each token and whether an empty line precedes it, when the syntax is recorded
+	comments [][]Comment
+	blank    []bool
 }
 
 func (p *Parser) Synchronize() {
@@ -15,7 +21,7 @@ func (p *Parser) Synchronize() {
 		}
 
 		switch p.Peek().tokenType {
-		case TOKEN_FN, TOKEN_LET, TOKEN_FOR, TOKEN_IF, TOKEN_WHILE, TOKEN_PRINT, TOKEN_RETURN:
+		case TOKEN_FN, TOKEN_LET, TOKEN_STRUCT, TOKEN_FOR, TOKEN_IF, TOKEN_WHILE, TOKEN_PRINT, TOKEN_RETURN, TOKEN_BREAK, TOKEN_CONTINUE:
 			return
 		}
 
@@ -27,23 +33,41 @@ func (p *Parser) Synchronize() {
 
 // Statements
 
-func (p *Parser) Declaration() Statement {
+func (p *Parser) Declaration() (stmt Statement) {
 	defer func() {
 		if r := recover(); r != nil {
-			err := r.(ErrorData)
+			err, ok := r.(ErrorData)
+			if !ok {
+				panic(r)
+			}
 			p.errorReporter.Push(err.line, err.col, err.message)
 			p.Synchronize()
 		}
 	}()
 
+	if p.syntax != nil {
+		start := p.current
+		defer func() {
+			if stmt != nil {
+				p.Attach(stmt, start)
+			}
+		}()
+	}
+
 	if p.Match(TOKEN_LET) {
 		return p.LetStatement()
 	}
 
-	if p.Match(TOKEN_FN) {
+	// "fn" followed by "(" starts a function literal
+	if p.Check(TOKEN_FN) && !p.CheckNext(TOKEN_LEFT_PAREN) {
+		p.Advance()
 		return p.FunctionDeclaration()
 	}
 
+	if p.Match(TOKEN_STRUCT) {
+		return p.StructDeclaration()
+	}
+
 	return p.Statement()
 }
 
@@ -61,17 +85,29 @@ func (p *Parser) Statement() Statement {
 	}
 
 	if p.Match(TOKEN_WHILE) {
-		return p.WhileStatement()
+		return p.WhileStatement(nil)
 	}
 
 	if p.Match(TOKEN_FOR) {
-		return p.ForStatement()
+		return p.ForStatement(nil)
 	}
 
 	if p.Match(TOKEN_RETURN) {
 		return p.ReturnStatement()
 	}
 
+	if p.Match(TOKEN_BREAK) {
+		return p.BreakStatement()
+	}
+
+	if p.Match(TOKEN_CONTINUE) {
+		return p.ContinueStatement()
+	}
+
+	if p.Check(TOKEN_IDENTIFIER) && p.CheckNext(TOKEN_COLON) {
+		return p.LabeledStatement()
+	}
+
 	return p.ExpressionStatement()
 }
 
@@ -89,8 +125,14 @@ func (p *Parser) BlockStatement() Statement {
 		statements = append(statements, p.Declaration())
 	}
 
+	end := p.current
 	p.Consume(TOKEN_RIGHT_BRACE, "expected \"}\" after block.")
-	return &BlockStatement{statements: statements}
+
+	block := &BlockStatement{statements: statements}
+	if p.syntax != nil {
+		p.syntax.closing[block] = p.TakeComments(end)
+	}
+	return block
 }
 
 func (p *Parser) IfStatement() Statement {
@@ -114,19 +156,23 @@ func (p *Parser) IfStatement() Statement {
 	return &IfStatement{condition: expr, thenBranch: thenBranch, elseBranch: elseBranch, loc: *loc}
 }
 
-func (p *Parser) WhileStatement() Statement {
+func (p *Parser) WhileStatement(label *Token) Statement {
 	loc := p.Consume(TOKEN_LEFT_PAREN, "expected \"(\".")
 	expr := p.Expression()
 	p.Consume(TOKEN_RIGHT_PAREN, "expected \")\".")
 	p.Consume(TOKEN_LEFT_BRACE, "expected \"{\".")
 	body := p.BlockStatement()
 
-	return &WhileStatement{condition: expr, body: body, loc: *loc}
+	return &WhileStatement{condition: expr, body: body, label: label, loc: *loc}
 }
 
-func (p *Parser) ForStatement() Statement {
+func (p *Parser) ForStatement(label *Token) Statement {
 	p.Consume(TOKEN_LEFT_PAREN, "expected \"(\".")
 
+	if p.Check(TOKEN_IDENTIFIER) && (p.CheckNext(TOKEN_IN) || p.CheckNext(TOKEN_COMMA)) {
+		return p.ForInStatement(label)
+	}
+
 	var initializer Statement
 	var condition, increment Expression
 
@@ -155,21 +201,132 @@ func (p *Parser) ForStatement() Statement {
 
 	// desugar into a while loop
 
+	written := condition
 	if condition == nil {
 		condition = &LiteralExpression{Token{tokenType: TOKEN_TRUE}}
 	}
 
-	if increment != nil {
-		body.statements = append(body.statements, &ExpressionStatement{increment})
+	// the increment is kept separate from the body so that it is still evaluated after a `continue`
+	while := &WhileStatement{condition: condition, body: body, increment: increment, label: label, loc: *loc}
+
+	var loop Statement = while
+	if initializer != nil {
+		loop = &BlockStatement{statements: []Statement{initializer, while}}
 	}
 
-	while := &WhileStatement{condition: condition, body: body, loc: *loc}
+	if p.syntax != nil {
+		p.syntax.loops[loop] = &ForLoop{label: label, initializer: initializer, condition: written, increment: increment, body: body}
+	}
+	return loop
+}
 
-	if initializer == nil {
-		return while
-	} else {
-		return &BlockStatement{statements: []Statement{initializer, while}}
+// ForInStatement parses `for (key, value in m) { ... }` after the opening parenthesis
+func (p *Parser) ForInStatement(label *Token) Statement {
+	key := p.Consume(TOKEN_IDENTIFIER, "expected an identifier.")
+
+	var value *Token
+	if p.Match(TOKEN_COMMA) {
+		value = p.Consume(TOKEN_IDENTIFIER, "expected an identifier.")
+	}
+
+	loc := p.Consume(TOKEN_IN, "expected \"in\".")
+	iterable := p.Expression()
+	p.Consume(TOKEN_RIGHT_PAREN, "expected \")\".")
+
+	p.Consume(TOKEN_LEFT_BRACE, "expected \"{\".")
+	body := p.BlockStatement().(*BlockStatement)
+
+	/**
+	 * desugar into a while loop over the keys present when the loop starts
+	 *
+	 *     let $map = m;
+	 *     let $keys = $builtin_keys($map);
+	 *     let $i = 0;
+	 *     while ($i < $builtin_len($keys)) {
+	 *         let key = $keys[$i];
+	 *         if ($builtin_has($map, key)) {
+	 *             let value = $map[key];
+	 *             { body }
+	 *         }
+	 *     } $i = $i + 1
+	 *
+	 * the reads of key in the condition and the subscript are marked as generated, they are not reads of the program
+	 */
+
+	identifier := func(name string) Token {
+		return Token{tokenType: TOKEN_IDENTIFIER, line: loc.line, col: loc.col, value: name}
+	}
+
+	variable := func(name string) Expression {
+		return &IdentifierExpression{name: identifier(name)}
+	}
+
+	// the builtins are called by their reserved names, which the variables of the program cannot shadow
+	call := func(name string, arguments ...Expression) Expression {
+		return &CallExpression{callee: variable(ReservedBuiltin(name)), arguments: arguments, loc: *loc}
+	}
+
+	one := &LiteralExpression{value: Token{tokenType: TOKEN_INT_LITERAL, line: loc.line, col: loc.col, value: int64(1)}}
+	zero := &LiteralExpression{value: Token{tokenType: TOKEN_INT_LITERAL, line: loc.line, col: loc.col, value: int64(0)}}
+	less := Token{tokenType: TOKEN_LESS, line: loc.line, col: loc.col}
+	plus := Token{tokenType: TOKEN_PLUS, line: loc.line, col: loc.col}
+
+	entry := []Statement{}
+	if value != nil {
+		entry = append(entry, &LetStatement{
+			name:        *value,
+			initializer: &SubscriptExpression{object: variable("$map"), index: &IdentifierExpression{name: *key, generated: true}, loc: *loc},
+			inferred:    true,
+		})
+	}
+	entry = append(entry, body)
+
+	while := &WhileStatement{
+		condition: &BinaryExpression{left: variable("$i"), operator: less, right: call("len", variable("$keys"))},
+		body: &BlockStatement{statements: []Statement{
+			&LetStatement{
+				name:        *key,
+				initializer: &SubscriptExpression{object: variable("$keys"), index: variable("$i"), loc: *loc},
+				inferred:    true,
+			},
+			&IfStatement{
+				condition:  call("has", variable("$map"), &IdentifierExpression{name: *key, generated: true}),
+				thenBranch: &BlockStatement{statements: entry},
+				loc:        *loc,
+			},
+		}},
+		increment: &AssignmentExpression{name: identifier("$i"), value: &BinaryExpression{left: variable("$i"), operator: plus, right: one}},
+		label:     label,
+		loc:       *loc,
+	}
+
+	loop := &BlockStatement{statements: []Statement{
+		&LetStatement{name: identifier("$map"), initializer: iterable, inferred: true},
+		&LetStatement{name: identifier("$keys"), initializer: call("keys", variable("$map")), inferred: true},
+		&LetStatement{name: identifier("$i"), initializer: zero, inferred: true},
+		while,
+	}}
+
+	if p.syntax != nil {
+		p.syntax.loops[loop] = &ForLoop{label: label, key: key, value: value, iterable: iterable, body: body}
+	}
+	return loop
+}
+
+func (p *Parser) LabeledStatement() Statement {
+	label := p.Consume(TOKEN_IDENTIFIER, "")
+	p.Consume(TOKEN_COLON, "")
+
+	if p.Match(TOKEN_WHILE) {
+		return p.WhileStatement(label)
+	}
+
+	if p.Match(TOKEN_FOR) {
+		return p.ForStatement(label)
 	}
+
+	token := p.Peek()
+	panic(ErrorData{token.line, token.col, "expected a loop after label."})
 }
 
 func (p *Parser) ExpressionStatement() Statement {
@@ -179,7 +336,19 @@ func (p *Parser) ExpressionStatement() Statement {
 }
 
 func (p *Parser) LetStatement() Statement {
+	if p.Check(TOKEN_LEFT_PAREN) {
+		return p.DestructuringLetStatement()
+	}
+
 	name := p.Consume(TOKEN_IDENTIFIER, "expected variable name.")
+
+	if p.Match(TOKEN_EQUAL) {
+		// the type is inferred from the initializer by the type checker
+		initializer := p.Expression()
+		p.Consume(TOKEN_SEMICOLON, "expected \";\" after variable declaration.")
+		return &LetStatement{name: *name, initializer: initializer, inferred: true}
+	}
+
 	atype := p.Type()
 
 	var initializer Expression
@@ -192,8 +361,35 @@ func (p *Parser) LetStatement() Statement {
 	return &LetStatement{name: *name, initializer: initializer, atype: atype}
 }
 
+// DestructuringLetStatement parses `let (a, b) = expr;`, where the type of the tuple is optional
+func (p *Parser) DestructuringLetStatement() Statement {
+	loc := p.Consume(TOKEN_LEFT_PAREN, "expected \"(\".")
+
+	names := []Token{*p.Consume(TOKEN_IDENTIFIER, "expected variable name.")}
+	for p.Match(TOKEN_COMMA) {
+		names = append(names, *p.Consume(TOKEN_IDENTIFIER, "expected variable name."))
+	}
+	p.Consume(TOKEN_RIGHT_PAREN, "expected \")\" after variable names.")
+
+	var atype *Type
+	if !p.Check(TOKEN_EQUAL) {
+		atype = p.Type()
+	}
+
+	p.Consume(TOKEN_EQUAL, "expected \"=\", a destructured tuple must be initialized.")
+	initializer := p.Expression()
+	p.Consume(TOKEN_SEMICOLON, "expected \";\" after variable declaration.")
+
+	return &LetStatement{name: *loc, names: names, initializer: initializer, atype: atype, inferred: atype == nil}
+}
+
 func (p *Parser) FunctionDeclaration() Statement {
 	name := p.Consume(TOKEN_IDENTIFIER, "expected a function name.")
+	return p.FunctionDefinition(*name)
+}
+
+// FunctionDefinition parses the parameters, return type and body of a function declaration or function literal
+func (p *Parser) FunctionDefinition(name Token) *FunctionStatement {
 	p.Consume(TOKEN_LEFT_PAREN, "expected \"(\".")
 
 	parameters := make([]Token, 0)
@@ -216,7 +412,48 @@ func (p *Parser) FunctionDeclaration() Statement {
 	body := p.BlockStatement().(*BlockStatement)
 
 	atype := FunctionType{parameters: parameterTypes, returnType: returnType}
-	return &FunctionStatement{name: *name, parameters: parameters, body: body, atype: atype}
+	return &FunctionStatement{name: name, parameters: parameters, body: body, atype: atype}
+}
+
+func (p *Parser) StructDeclaration() Statement {
+	name := p.Consume(TOKEN_IDENTIFIER, "expected a struct name.")
+	p.Consume(TOKEN_LEFT_BRACE, "expected \"{\".")
+
+	fields := make([]Token, 0)
+	methods := make([]*FunctionStatement, 0)
+	atype := &StructType{name: name.String(), methods: make(map[string]*FunctionStatement)}
+
+	for !p.Check(TOKEN_RIGHT_BRACE) && !p.IsAtEnd() {
+		start := p.current
+		var member interface{}
+
+		if p.Match(TOKEN_FN) {
+			method := p.FunctionDeclaration().(*FunctionStatement)
+			methods = append(methods, method)
+			member = method
+		} else {
+			field := p.Consume(TOKEN_IDENTIFIER, "expected a field or method declaration.")
+			fieldType := p.Type()
+			p.Consume(TOKEN_SEMICOLON, "expected \";\" after field declaration.")
+
+			fields = append(fields, *field)
+			atype.fields = append(atype.fields, StructField{name: field.String(), atype: fieldType})
+			member = *field
+		}
+
+		if p.syntax != nil {
+			p.Attach(member, start)
+		}
+	}
+
+	end := p.current
+	p.Consume(TOKEN_RIGHT_BRACE, "expected \"}\" after struct declaration.")
+
+	stmt := &StructStatement{name: *name, fields: fields, methods: methods, atype: &Type{kind: TYPE_STRUCT, other: atype}}
+	if p.syntax != nil {
+		p.syntax.closing[stmt] = p.TakeComments(end)
+	}
+	return stmt
 }
 
 func (p *Parser) ReturnStatement() Statement {
@@ -225,12 +462,41 @@ func (p *Parser) ReturnStatement() Statement {
 	var value Expression
 	if !p.Check(TOKEN_SEMICOLON) {
 		value = p.Expression()
+
+		// `return a, b;` returns a tuple
+		if p.Check(TOKEN_COMMA) {
+			value = p.Tuple(value, *loc)
+		}
 	}
 
 	p.Consume(TOKEN_SEMICOLON, "expected \";\" after expression.")
 	return &ReturnStatement{loc: *loc, value: value}
 }
 
+func (p *Parser) BreakStatement() Statement {
+	loc := p.Previous()
+
+	var label *Token
+	if p.Match(TOKEN_IDENTIFIER) {
+		label = p.Previous()
+	}
+
+	p.Consume(TOKEN_SEMICOLON, "expected \";\" after break.")
+	return &BreakStatement{label: label, loc: *loc}
+}
+
+func (p *Parser) ContinueStatement() Statement {
+	loc := p.Previous()
+
+	var label *Token
+	if p.Match(TOKEN_IDENTIFIER) {
+		label = p.Previous()
+	}
+
+	p.Consume(TOKEN_SEMICOLON, "expected \";\" after continue.")
+	return &ContinueStatement{label: label, loc: *loc}
+}
+
 // Expressions
 
 func (p *Parser) Expression() Expression {
@@ -246,7 +512,23 @@ func (p *Parser) Assignment() Expression {
 		return &AssignmentExpression{name: *name, value: value}
 	}
 
-	return p.LogicOr()
+	expr := p.LogicOr()
+
+	if p.Match(TOKEN_EQUAL) {
+		equals := p.Previous()
+		value := p.Assignment()
+
+		switch target := expr.(type) {
+		case *SubscriptExpression:
+			return &SubscriptAssignmentExpression{target: target, value: value}
+		case *FieldExpression:
+			return &FieldAssignmentExpression{target: target, value: value}
+		}
+
+		panic(ErrorData{equals.line, equals.col, "invalid assignment target."})
+	}
+
+	return expr
 }
 
 func (p *Parser) LogicOr() Expression {
@@ -370,8 +652,20 @@ func (p *Parser) Unary() Expression {
 func (p *Parser) CallOrSubscript() Expression {
 	callee := p.Primary()
 
-	for p.Match(TOKEN_LEFT_PAREN) {
-		callee = p.Arguments(callee)
+	for {
+		if p.Match(TOKEN_LEFT_PAREN) {
+			callee = p.Arguments(callee)
+		} else if p.Match(TOKEN_LEFT_SQUARE) {
+			loc := p.Previous()
+			index := p.Expression()
+			p.Consume(TOKEN_RIGHT_SQUARE, "expected \"]\" after index.")
+			callee = &SubscriptExpression{object: callee, index: index, loc: *loc}
+		} else if p.Match(TOKEN_DOT) {
+			name := p.Consume(TOKEN_IDENTIFIER, "expected a field name after \".\".")
+			callee = &FieldExpression{object: callee, name: *name}
+		} else {
+			break
+		}
 	}
 
 	return callee
@@ -392,20 +686,45 @@ func (p *Parser) Arguments(callee Expression) Expression {
 }
 
 func (p *Parser) Primary() Expression {
-	if p.Match(TOKEN_FALSE, TOKEN_TRUE, TOKEN_INT_LITERAL, TOKEN_FLOAT_LITERAL, TOKEN_STRING_LITERAL) {
+	if p.Match(TOKEN_FALSE, TOKEN_TRUE, TOKEN_NIL, TOKEN_INT_LITERAL, TOKEN_FLOAT_LITERAL, TOKEN_STRING_LITERAL) {
 		return &LiteralExpression{value: *p.Previous()}
 	}
 
-	if p.Match(TOKEN_LEFT_PAREN) {
+	if p.Check(TOKEN_INTERPOLATION) {
+		return p.Interpolation()
+	}
+
+	// a parenthesized type followed by "[]" is the type of a slice literal, such as (i64, string)[]{(1, "a")}
+	if p.Check(TOKEN_LEFT_PAREN) && !p.IsSliceType() && p.Match(TOKEN_LEFT_PAREN) {
+		loc := p.Previous()
 		expr := p.Expression()
+
+		if p.Check(TOKEN_COMMA) {
+			expr = p.Tuple(expr, *loc)
+			p.Consume(TOKEN_RIGHT_PAREN, "expected \")\" after tuple.")
+			return expr
+		}
+
 		p.Consume(TOKEN_RIGHT_PAREN, "expected \")\" after expression.")
 		return &GroupingExpression{expr: expr}
 	}
 
-	if p.Match(TOKEN_IDENTIFIER) {
+	if p.Check(TOKEN_IDENTIFIER) && p.CheckNext(TOKEN_LEFT_BRACE) {
+		return p.StructLiteral()
+	}
+
+	// an identifier followed by "[]" or "?" is the type of a slice literal, not an identifier expression
+	isSliceType := p.Check(TOKEN_IDENTIFIER) && (p.CheckNext(TOKEN_QUESTION) || p.CheckNext(TOKEN_LEFT_SQUARE) && p.CheckAt(2, TOKEN_RIGHT_SQUARE))
+
+	if !isSliceType && p.Match(TOKEN_IDENTIFIER) {
 		return &IdentifierExpression{name: *p.Previous()}
 	}
 
+	if p.Check(TOKEN_FN) && p.IsFunctionLiteral() {
+		// a function literal is named after its "fn" keyword
+		return &FunctionLiteralExpression{function: p.FunctionDefinition(*p.Advance())}
+	}
+
 	// parse a type cast
 	loc := p.Peek()
 
@@ -413,7 +732,10 @@ func (p *Parser) Primary() Expression {
 		// attempt to parse a type but intercept errors
 		defer func() {
 			if r := recover(); r != nil {
-				err := r.(ErrorData)
+				err, ok := r.(ErrorData)
+				if !ok {
+					panic(r)
+				}
 				// change the error message to make more sense in this context
 				if err.message == "expected type definition." {
 					err.message = "expected expression."
@@ -424,6 +746,34 @@ func (p *Parser) Primary() Expression {
 		return p.Type()
 	}()
 
+	// parse a slice literal
+	if to.kind == TYPE_SLICE && p.Match(TOKEN_LEFT_BRACE) {
+		elements := make([]Expression, 0)
+		closing := p.LiteralEntries(func() Expression {
+			elements = append(elements, p.Expression())
+			return elements[len(elements)-1]
+		})
+
+		p.Consume(TOKEN_RIGHT_BRACE, "expected \"}\" after slice elements.")
+		return p.Closing(&SliceLiteralExpression{atype: to, elements: elements, loc: *loc}, closing)
+	}
+
+	// parse a map literal
+	if to.kind == TYPE_MAP && p.Match(TOKEN_LEFT_BRACE) {
+		keys := make([]Expression, 0)
+		values := make([]Expression, 0)
+
+		closing := p.LiteralEntries(func() Expression {
+			keys = append(keys, p.Expression())
+			p.Consume(TOKEN_COLON, "expected \":\" after map key.")
+			values = append(values, p.Expression())
+			return values[len(values)-1]
+		})
+
+		p.Consume(TOKEN_RIGHT_BRACE, "expected \"}\" after map entries.")
+		return p.Closing(&MapLiteralExpression{atype: to, keys: keys, values: values, loc: *loc}, closing)
+	}
+
 	p.Consume(TOKEN_LEFT_PAREN, "expected \"(\" after type.")
 	value := p.Expression()
 	p.Consume(TOKEN_RIGHT_PAREN, "expected \")\" after type.")
@@ -431,6 +781,171 @@ func (p *Parser) Primary() Expression {
 	return &TypeCastExpression{to: to, value: value, loc: *loc}
 }
 
+// Interpolation parses a string with interpolated expressions, "a ${x} b" is lowered to "a " + str(x) + " b" where
+// str is called by its reserved name
+func (p *Parser) Interpolation() (result Expression) {
+	interpolation := &Interpolation{}
+	if p.syntax != nil {
+		defer func() {
+			if result != nil {
+				p.syntax.interpolations[result] = interpolation
+			}
+		}()
+	}
+
+	concatenate := func(expr Expression, loc *Token) {
+		if result == nil {
+			result = expr
+		} else {
+			plus := Token{tokenType: TOKEN_PLUS, line: loc.line, col: loc.col}
+			result = &BinaryExpression{left: result, operator: plus, right: expr}
+		}
+	}
+
+	for {
+		segment := p.Advance()
+		interpolation.segments = append(interpolation.segments, *segment)
+		if len(segment.value.([]rune)) != 0 {
+			literal := *segment
+			literal.tokenType = TOKEN_STRING_LITERAL
+			concatenate(&LiteralExpression{value: literal}, segment)
+		}
+
+		if segment.tokenType == TOKEN_INTERPOLATION_END {
+			return result
+		}
+
+		loc := p.Peek()
+		value := p.Expression()
+		interpolation.values = append(interpolation.values, value)
+		str := &IdentifierExpression{name: Token{tokenType: TOKEN_IDENTIFIER, line: loc.line, col: loc.col, value: ReservedBuiltin("str")}}
+		concatenate(&CallExpression{callee: str, arguments: []Expression{value}, loc: *loc}, loc)
+
+		if !p.Check(TOKEN_INTERPOLATION) && !p.Check(TOKEN_INTERPOLATION_END) {
+			token := p.Peek()
+			panic(ErrorData{token.line, token.col, "expected \"}\" after interpolated expression."})
+		}
+	}
+}
+
+// Tuple parses the remaining elements of a tuple whose first element is `first`
+func (p *Parser) Tuple(first Expression, loc Token) Expression {
+	elements := []Expression{first}
+	for p.Match(TOKEN_COMMA) {
+		elements = append(elements, p.Expression())
+	}
+	return &TupleExpression{elements: elements, loc: loc}
+}
+
+func (p *Parser) StructLiteral() Expression {
+	loc := p.Peek()
+	atype := p.Type()
+	p.Consume(TOKEN_LEFT_BRACE, "expected \"{\".")
+
+	fields := make([]Token, 0)
+	values := make([]Expression, 0)
+
+	closing := p.LiteralEntries(func() Expression {
+		fields = append(fields, *p.Consume(TOKEN_IDENTIFIER, "expected a field name."))
+		p.Consume(TOKEN_COLON, "expected \":\" after field name.")
+		values = append(values, p.Expression())
+		return values[len(values)-1]
+	})
+
+	p.Consume(TOKEN_RIGHT_BRACE, "expected \"}\" after struct fields.")
+	return p.Closing(&StructLiteralExpression{atype: atype, fields: fields, values: values, loc: *loc}, closing)
+}
+
+// LiteralEntries parses the comma separated entries of a slice, map or struct literal up to its closing brace.
+// `entry` parses an entry and returns its value, which the comments of the entry are attached to. The comments
+// before the closing brace are returned.
+func (p *Parser) LiteralEntries(entry func() Expression) []Comment {
+	more := !p.Check(TOKEN_RIGHT_BRACE)
+	for more {
+		start := p.current
+		value := entry()
+		more = p.Match(TOKEN_COMMA)
+		if p.syntax != nil {
+			p.Attach(value, start)
+		}
+	}
+
+	if p.syntax == nil {
+		return nil
+	}
+	return p.TakeComments(p.current)
+}
+
+// Closing attaches the comments before the closing brace of a literal to it
+func (p *Parser) Closing(literal Expression, closing []Comment) Expression {
+	if len(closing) != 0 {
+		p.syntax.closing[literal] = closing
+	}
+	return literal
+}
+
+// MatchingParen returns the offset from the current token of the ")" matching the "(" at `offset`, or -1 if it is
+// not closed
+func (p *Parser) MatchingParen(offset int) int {
+	depth := 0
+	for ; p.current+offset < len(p.tokens); offset++ {
+		switch p.tokens[p.current+offset].tokenType {
+		case TOKEN_LEFT_PAREN:
+			depth++
+		case TOKEN_RIGHT_PAREN:
+			if depth--; depth == 0 {
+				return offset
+			}
+		}
+	}
+	return -1
+}
+
+// IsSliceType reports whether the "(" at the current token starts a parenthesized type followed by "[]", rather
+// than a grouping or a tuple, which cannot be followed by an empty subscript
+func (p *Parser) IsSliceType() bool {
+	closing := p.MatchingParen(0)
+	return closing >= 0 && p.CheckAt(closing+1, TOKEN_LEFT_SQUARE) && p.CheckAt(closing+2, TOKEN_RIGHT_SQUARE)
+}
+
+// IsFunctionLiteral reports whether the "fn" at the current token starts a function literal rather than a function
+// type. The return type follows the ")" that closes the parameters, and only a function literal has a "{" body
+// after it, a function type in an expression is followed by the "(" of a type cast or by what follows the cast.
+func (p *Parser) IsFunctionLiteral() bool {
+	if !p.CheckNext(TOKEN_LEFT_PAREN) {
+		return false
+	}
+
+	closing := p.MatchingParen(1)
+	if closing < 0 {
+		return false
+	}
+
+	depth := 0
+	for offset := closing + 1; p.current+offset < len(p.tokens); offset++ {
+		switch p.tokens[p.current+offset].tokenType {
+		case TOKEN_LEFT_BRACE:
+			return depth == 0
+		case TOKEN_LEFT_PAREN, TOKEN_LEFT_SQUARE:
+			depth++
+		case TOKEN_RIGHT_PAREN, TOKEN_RIGHT_SQUARE:
+			if depth == 0 {
+				return false
+			}
+			depth--
+		case TOKEN_COMMA:
+			if depth == 0 {
+				return false
+			}
+		case TOKEN_I64, TOKEN_U64, TOKEN_BOOL, TOKEN_STRING, TOKEN_DOUBLE, TOKEN_IDENTIFIER, TOKEN_VOID, TOKEN_FN,
+			TOKEN_MAP, TOKEN_QUESTION:
+		default:
+			return false
+		}
+	}
+	return false
+}
+
 // Types
 
 func (p *Parser) Type() *Type {
@@ -456,6 +971,17 @@ func (p *Parser) Function() *Type {
 		return &Type{kind: TYPE_FUNCTION, other: FunctionType{parameters: parameters, returnType: returnType}}
 	}
 
+	if p.Match(TOKEN_MAP) {
+		p.Consume(TOKEN_LEFT_SQUARE, "expected \"[\" after map.")
+		key := p.Type()
+		p.Consume(TOKEN_RIGHT_SQUARE, "expected \"]\" after map key type.")
+
+		// like the return type of a function, the value type extends as far as possible
+		value := p.Type()
+
+		return &Type{kind: TYPE_MAP, other: MapType{key: key, value: value}}
+	}
+
 	return p.Slice()
 }
 
@@ -470,7 +996,17 @@ func (p *Parser) ReturnType() *Type {
 func (p *Parser) Slice() *Type {
 	atype := p.Primitive()
 
-	for p.Match(TOKEN_LEFT_SQUARE) {
+	for p.Check(TOKEN_LEFT_SQUARE) || p.Check(TOKEN_QUESTION) {
+		if p.Match(TOKEN_QUESTION) {
+			if atype.IsOptional() {
+				token := p.Previous()
+				panic(ErrorData{token.line, token.col, "type is already optional."})
+			}
+			atype = &Type{kind: TYPE_OPTIONAL, other: OptionalType{of: atype}}
+			continue
+		}
+
+		p.Advance()
 		p.Consume(TOKEN_RIGHT_SQUARE, "expected \"]\" after type definition.")
 		atype = &Type{kind: TYPE_SLICE, other: SliceType{of: atype}}
 	}
@@ -499,8 +1035,22 @@ func (p *Parser) Primitive() *Type {
 		return SimpleType(convert(p.Previous().tokenType))
 	}
 
+	if p.Match(TOKEN_IDENTIFIER) {
+		// the struct is resolved by the type checker
+		return &Type{kind: TYPE_STRUCT, other: &StructType{name: p.Previous().String()}}
+	}
+
 	if p.Match(TOKEN_LEFT_PAREN) {
 		atype := p.Type()
+
+		if p.Check(TOKEN_COMMA) {
+			elements := []*Type{atype}
+			for p.Match(TOKEN_COMMA) {
+				elements = append(elements, p.Type())
+			}
+			atype = &Type{kind: TYPE_TUPLE, other: TupleType{elements: elements}}
+		}
+
 		p.Consume(TOKEN_RIGHT_PAREN, "expected \")\" after type definition.")
 		return atype
 	}
@@ -545,6 +1095,14 @@ func (p *Parser) CheckNext(tokenType TokenType) bool {
 	return p.Next().tokenType == tokenType
 }
 
+func (p *Parser) CheckAt(offset int, tokenType TokenType) bool {
+	if p.current+offset >= len(p.tokens) {
+		return false
+	}
+
+	return p.tokens[p.current+offset].tokenType == tokenType
+}
+
 func (p *Parser) Advance() *Token {
 	if !p.IsAtEnd() {
 		p.current++
@@ -578,15 +1136,34 @@ func Parse(tokens TokenStream, errorReporter ErrorReporter) (Program, error) {
 	}
 
 	parser := Parser{tokens: filteredTokens, current: 0, errorReporter: errorReporter}
+	return parser.Program()
+}
+
+// ParseSyntax parses a program like Parse, recording the comments and the syntax the program was written with
+func ParseSyntax(tokens TokenStream, errorReporter ErrorReporter) (Program, *Syntax, error) {
+	filteredTokens, comments, blank := SeparateComments(tokens)
+	parser := Parser{tokens: filteredTokens, current: 0, errorReporter: errorReporter, syntax: NewSyntax(), comments: comments, blank: blank}
 
+	statements, err := parser.Program()
+	if err != nil {
+		return nil, nil, err
+	}
+	return statements, parser.syntax, nil
+}
+
+func (p *Parser) Program() (Program, error) {
 	statements := make(Program, 0)
 
-	for !parser.IsAtEnd() {
-		statements = append(statements, parser.Declaration())
+	for !p.IsAtEnd() {
+		statements = append(statements, p.Declaration())
+	}
+
+	if p.syntax != nil {
+		p.syntax.end = p.TakeComments(p.current)
 	}
 
-	if errorReporter.HadError() {
-		return nil, errorReporter
+	if p.errorReporter.HadError() {
+		return nil, p.errorReporter
 	} else {
 		return statements, nil
 	}
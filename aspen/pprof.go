@@ -0,0 +1,211 @@
+package main
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// ProtoBuffer encodes a protocol buffer message, it supports the subset of the wire format used by the pprof
+// profile format, see https://github.com/google/pprof/blob/main/proto/profile.proto
+type ProtoBuffer struct {
+	data []byte
+}
+
+func (b *ProtoBuffer) Varint(x uint64) {
+	for x >= 0x80 {
+		b.data = append(b.data, byte(x)|0x80)
+		x >>= 7
+	}
+	b.data = append(b.data, byte(x))
+}
+
+func (b *ProtoBuffer) Tag(field int, wireType int) {
+	b.Varint(uint64(field)<<3 | uint64(wireType))
+}
+
+func (b *ProtoBuffer) Int(field int, x int64) {
+	if x == 0 {
+		// zero is the default value of a field
+		return
+	}
+	b.Tag(field, 0)
+	b.Varint(uint64(x))
+}
+
+func (b *ProtoBuffer) Bytes(field int, data []byte) {
+	b.Tag(field, 2)
+	b.Varint(uint64(len(data)))
+	b.data = append(b.data, data...)
+}
+
+func (b *ProtoBuffer) String(field int, s string) {
+	b.Bytes(field, []byte(s))
+}
+
+// Packed encodes a repeated integer field
+func (b *ProtoBuffer) Packed(field int, xs []int64) {
+	packed := ProtoBuffer{}
+	for _, x := range xs {
+		packed.Varint(uint64(x))
+	}
+	b.Bytes(field, packed.data)
+}
+
+func (b *ProtoBuffer) Message(field int, encode func(message *ProtoBuffer)) {
+	message := ProtoBuffer{}
+	encode(&message)
+	b.Bytes(field, message.data)
+}
+
+// MAX_PPROF_STACK_DEPTH is the number of the innermost calls kept in the samples of a pprof profile
+const MAX_PPROF_STACK_DEPTH = 64
+
+// StringTable numbers the strings of a profile, the first string of the table must be ""
+type StringTable struct {
+	strings []string
+	indices map[string]int64
+}
+
+func (t *StringTable) Index(s string) int64 {
+	if index, ok := t.indices[s]; ok {
+		return index
+	}
+	t.indices[s] = int64(len(t.strings))
+	t.strings = append(t.strings, s)
+	return t.indices[s]
+}
+
+// PprofFunctionName returns the name of a function in a pprof profile. pprof removes the parts of names between
+// angle brackets, as they are template arguments in C++, so names such as "<anonymous fn>" lose their brackets.
+func PprofFunctionName(function *FunctionProfile) string {
+	if !strings.HasPrefix(function.name, "<") {
+		return function.name
+	}
+
+	name := strings.Trim(function.name, "<>")
+	if function.line != 0 {
+		// anonymous functions are told apart by the line they are declared on
+		name = fmt.Sprintf("%s (line %d)", name, function.line)
+	}
+	return name
+}
+
+// WritePprof writes the recorded call tree as a gzip compressed pprof profile, each path of calls is a sample
+// with the number of calls along the path and the time spent at the end of the path
+func (p *Profiler) WritePprof(w io.Writer) error {
+	table := &StringTable{indices: make(map[string]int64)}
+	table.Index("")
+
+	profile := ProtoBuffer{}
+
+	valueType := func(field int, name string, unit string) {
+		profile.Message(field, func(message *ProtoBuffer) {
+			message.Int(1, table.Index(name))
+			message.Int(2, table.Index(unit))
+		})
+	}
+	valueType(1, "calls", "count")
+	valueType(1, "time", "nanoseconds")
+
+	// number the functions in a stable order
+	functions := []*FunctionProfile{}
+	for _, function := range p.functions {
+		functions = append(functions, function)
+	}
+	sort.Slice(functions, func(i, j int) bool {
+		if functions[i].line != functions[j].line {
+			return functions[i].line < functions[j].line
+		}
+		return functions[i].name < functions[j].name
+	})
+	functionIds := make(map[*FunctionProfile]int64)
+	for i, function := range functions {
+		functionIds[function] = int64(i + 1)
+	}
+
+	// a location is a line of a function, the line a function is declared on for time spent in the function
+	// itself and the line of the call site for time spent in the functions it calls
+	locationIds := make(map[CallKey]int64)
+	locations := []CallKey{}
+	location := func(key CallKey) int64 {
+		if id, ok := locationIds[key]; ok {
+			return id
+		}
+		locations = append(locations, key)
+		locationIds[key] = int64(len(locations))
+		return locationIds[key]
+	}
+
+	// the locations of the calls leading to a node, innermost first
+	stack := func(node *CallNode) []int64 {
+		ids := []int64{location(CallKey{function: node.function, line: node.function.line})}
+		for child := node; child.parent != nil && len(ids) < MAX_PPROF_STACK_DEPTH; child = child.parent {
+			ids = append(ids, location(CallKey{function: child.parent.function, line: child.line}))
+		}
+		return ids
+	}
+
+	nodes := []*CallNode{p.root}
+	for len(nodes) != 0 {
+		node := nodes[len(nodes)-1]
+		nodes = nodes[:len(nodes)-1]
+
+		profile.Message(2, func(message *ProtoBuffer) {
+			message.Packed(1, stack(node))
+			message.Packed(2, []int64{node.calls, int64(node.self)})
+		})
+
+		// visit the children in a stable order
+		children := []*CallNode{}
+		for _, child := range node.children {
+			children = append(children, child)
+		}
+		sort.Slice(children, func(i, j int) bool {
+			if children[i].line != children[j].line {
+				return children[i].line > children[j].line
+			}
+			return functionIds[children[i].function] > functionIds[children[j].function]
+		})
+		nodes = append(nodes, children...)
+	}
+
+	for i, key := range locations {
+		profile.Message(4, func(message *ProtoBuffer) {
+			message.Int(1, int64(i+1))
+			message.Message(4, func(line *ProtoBuffer) {
+				line.Int(1, functionIds[key.function])
+				line.Int(2, int64(key.line))
+			})
+		})
+	}
+
+	for _, function := range functions {
+		name := PprofFunctionName(function)
+		profile.Message(5, func(message *ProtoBuffer) {
+			message.Int(1, functionIds[function])
+			message.Int(2, table.Index(name))
+			message.Int(3, table.Index(name))
+			message.Int(4, table.Index(p.file))
+			message.Int(5, int64(function.line))
+		})
+	}
+
+	profile.Int(9, p.start.UnixNano())
+	profile.Int(10, int64(p.duration))
+	valueType(11, "time", "nanoseconds")
+	profile.Int(12, 1)
+
+	// the string table is written last, once every string has been numbered
+	for _, s := range table.strings {
+		profile.String(6, s)
+	}
+
+	writer := gzip.NewWriter(w)
+	if _, err := writer.Write(profile.data); err != nil {
+		return err
+	}
+	return writer.Close()
+}
@@ -0,0 +1,244 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// MAX_PROFILED_LINES is the number of the most executed lines shown in a profile report
+const MAX_PROFILED_LINES = 20
+
+// FunctionProfile is what the profiler recorded about a function
+type FunctionProfile struct {
+	name  string
+	line  int // the line the function is declared on, 0 for native and builtin functions
+	calls int64
+
+	// the time spent in calls to the function, with and without the time spent in the functions it called
+	inclusive time.Duration
+	exclusive time.Duration
+
+	// the number of calls to the function in progress, only the outermost of recursive calls counts towards
+	// `inclusive`
+	active int
+}
+
+// CallKey identifies the calls to a function made from one line of its caller
+type CallKey struct {
+	function *FunctionProfile
+	line     int
+}
+
+// CallNode is a node of the call tree, it records the calls made along one path of calls from the top level
+type CallNode struct {
+	CallKey
+	parent   *CallNode
+	children map[CallKey]*CallNode
+	calls    int64
+	self     time.Duration
+}
+
+// ProfileFrame is a call in progress
+type ProfileFrame struct {
+	node  *CallNode
+	start time.Time
+
+	// the time spent in the calls made by the call
+	children time.Duration
+
+	// the last line the call executed a statement on, the statements that follow on the same line are part of the
+	// same execution of the line
+	line int
+}
+
+// Profiler is an observer that records call counts, the time spent in each function and how many times each
+// line was executed
+type Profiler struct {
+	source    []rune
+	file      string
+	functions map[interface{}]*FunctionProfile
+	root      *CallNode
+	stack     []ProfileFrame
+	lines     map[int]int64
+	start     time.Time
+	duration  time.Duration
+}
+
+// NewProfiler returns a profiler for the program `source` read from `file`, the top level code of the program
+// counts as a call that starts right away
+func NewProfiler(source []rune, file string) *Profiler {
+	p := &Profiler{
+		source:    source,
+		file:      file,
+		functions: make(map[interface{}]*FunctionProfile),
+		lines:     make(map[int]int64),
+	}
+
+	main := &FunctionProfile{name: "<top level>", calls: 1, active: 1}
+	p.functions[nil] = main
+	p.root = &CallNode{CallKey: CallKey{function: main}, children: make(map[CallKey]*CallNode), calls: 1}
+	p.start = time.Now()
+	p.stack = append(p.stack, ProfileFrame{node: p.root, start: p.start})
+	return p
+}
+
+// Function returns the profile of a function
+func (p *Profiler) Function(function AspenFunction) *FunctionProfile {
+	var key interface{} = function
+	line := 0
+	if f, ok := function.(*UserFunction); ok {
+		// closures created from the same declaration are the same function
+		key = f.declaration
+		line = f.declaration.name.line
+	}
+
+	profile, ok := p.functions[key]
+	if !ok {
+		profile = &FunctionProfile{name: function.Name(), line: line}
+		p.functions[key] = profile
+	}
+	return profile
+}
+
+func (p *Profiler) Statement(stmt Statement) {
+	frame := &p.stack[len(p.stack)-1]
+	if line := StatementPosition(stmt).line; line != 0 && line != frame.line {
+		p.lines[line]++
+		frame.line = line
+	}
+}
+
+func (p *Profiler) Call(function AspenFunction, arguments []interface{}, callSite Token) {
+	profile := p.Function(function)
+	profile.calls++
+	profile.active++
+
+	parent := p.stack[len(p.stack)-1].node
+	key := CallKey{function: profile, line: callSite.line}
+	node, ok := parent.children[key]
+	if !ok {
+		node = &CallNode{CallKey: key, parent: parent, children: make(map[CallKey]*CallNode)}
+		parent.children[key] = node
+	}
+	node.calls++
+
+	p.stack = append(p.stack, ProfileFrame{node: node, start: time.Now()})
+}
+
+func (p *Profiler) TailCall(function AspenFunction, arguments []interface{}, callSite Token) {
+	p.Return(nil)
+	p.Call(function, arguments, callSite)
+}
+
+func (p *Profiler) Return(value interface{}) {
+	frame := p.stack[len(p.stack)-1]
+	p.stack = p.stack[:len(p.stack)-1]
+
+	elapsed := time.Since(frame.start)
+	self := elapsed - frame.children
+	frame.node.self += self
+
+	profile := frame.node.function
+	profile.exclusive += self
+	profile.active--
+	if profile.active == 0 {
+		profile.inclusive += elapsed
+	}
+
+	if len(p.stack) != 0 {
+		p.stack[len(p.stack)-1].children += elapsed
+	}
+}
+
+func (p *Profiler) Assign(name Token, old interface{}, value interface{}) {}
+
+func (p *Profiler) Branch(stmt Statement, taken bool) {
+	// each iteration of a loop executes the lines of its body again, even when the loop fits on one line
+	if _, ok := stmt.(*WhileStatement); ok {
+		p.stack[len(p.stack)-1].line = 0
+	}
+}
+
+// Finish ends the calls still in progress when the program stopped, including the top level code
+func (p *Profiler) Finish() {
+	for len(p.stack) != 0 {
+		p.Return(nil)
+	}
+	p.duration = time.Since(p.start)
+}
+
+// SourceLine returns the text of a line of the program
+func (p *Profiler) SourceLine(line int) string {
+	lines := strings.Split(string(p.source), "\n")
+	if line < 1 || line > len(lines) {
+		return ""
+	}
+	return strings.TrimSpace(lines[line-1])
+}
+
+// Report returns the recorded functions sorted by the time spent in them, and the most executed lines
+func (p *Profiler) Report() string {
+	builder := strings.Builder{}
+
+	functions := []*FunctionProfile{}
+	for _, function := range p.functions {
+		functions = append(functions, function)
+	}
+	sort.Slice(functions, func(i, j int) bool {
+		if functions[i].exclusive != functions[j].exclusive {
+			return functions[i].exclusive > functions[j].exclusive
+		}
+		return functions[i].name < functions[j].name
+	})
+
+	fmt.Fprintf(&builder, "profile: %.3fms\n\n", Milliseconds(p.duration))
+	fmt.Fprintf(&builder, "%12s %7s %12s %7s %10s  %s\n", "self", "self%", "total", "total%", "calls", "function")
+	for _, function := range functions {
+		name := function.name
+		if function.line != 0 {
+			name = fmt.Sprintf("%s (line %d)", name, function.line)
+		}
+		fmt.Fprintf(&builder, "%10.3fms %6.2f%% %10.3fms %6.2f%% %10d  %s\n",
+			Milliseconds(function.exclusive),
+			p.Percentage(function.exclusive),
+			Milliseconds(function.inclusive),
+			p.Percentage(function.inclusive),
+			function.calls,
+			name)
+	}
+
+	lines := []int{}
+	for line := range p.lines {
+		lines = append(lines, line)
+	}
+	sort.Slice(lines, func(i, j int) bool {
+		if p.lines[lines[i]] != p.lines[lines[j]] {
+			return p.lines[lines[i]] > p.lines[lines[j]]
+		}
+		return lines[i] < lines[j]
+	})
+
+	fmt.Fprintf(&builder, "\n%10s %6s\n", "hits", "line")
+	for i, line := range lines {
+		if i == MAX_PROFILED_LINES {
+			fmt.Fprintf(&builder, "    ... %d more\n", len(lines)-i)
+			break
+		}
+		fmt.Fprintf(&builder, "%10d %6d | %s\n", p.lines[line], line, p.SourceLine(line))
+	}
+
+	return builder.String()
+}
+
+func (p *Profiler) Percentage(duration time.Duration) float64 {
+	if p.duration == 0 {
+		return 0
+	}
+	return 100 * float64(duration) / float64(p.duration)
+}
+
+func Milliseconds(duration time.Duration) float64 {
+	return float64(duration) / float64(time.Millisecond)
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"testing"
+)
+
+func TestProfiler(t *testing.T) {
+	Initialize()
+
+	source := `fn fib(n i64) i64 {
+    if (n < 2) {
+        return n;
+    }
+    return fib(n - 1) + fib(n - 2);
+}
+
+fn count(n i64) i64 {
+    if (n == 0) {
+        return 0;
+    }
+    return count(n - 1);
+}
+
+fib(10);
+count(100);
+itoa(1);
+
+fn sum(n i64) i64 {
+    let s = 0; let i = 0;
+    while (i < n) { s = s + i; i = i + 1; }
+    if (s > 0) { return s; }
+    return 0;
+}
+sum(5);
+`
+
+	profiler := NewProfiler([]rune(source), "profile.aspen")
+	ObserveSource(t, source, profiler)
+	profiler.Finish()
+
+	calls := make(map[string]int64)
+	for _, function := range profiler.functions {
+		calls[function.name] = function.calls
+		if function.active != 0 {
+			t.Errorf("%s: expected no calls in progress, got %d", function.name, function.active)
+		}
+		if function.inclusive < function.exclusive {
+			t.Errorf("%s: expected the inclusive time to include the exclusive time", function.name)
+		}
+	}
+
+	// tail calls count as calls
+	expected := map[string]int64{"<top level>": 1, "fib": 177, "count": 101, "itoa": 1, "sum": 1}
+	for name, count := range expected {
+		if calls[name] != count {
+			t.Errorf("%s: expected %d calls, got %d", name, count, calls[name])
+		}
+	}
+
+	// a line counts once each time it runs, however many statements it has, and a loop on one line runs its line
+	// again on each iteration
+	lines := map[int]int64{2: 177, 20: 1, 21: 6, 22: 1, 23: 0}
+	for line, hits := range lines {
+		if profiler.lines[line] != hits {
+			t.Errorf("expected line %d to be executed %d times, got %d", line, hits, profiler.lines[line])
+		}
+	}
+
+	buffer := bytes.Buffer{}
+	if err := profiler.WritePprof(&buffer); err != nil {
+		t.Fatal(err)
+	}
+
+	reader, err := gzip.NewReader(&buffer)
+	if err != nil {
+		t.Fatalf("expected a gzip compressed profile: %v", err)
+	}
+	if data, err := io.ReadAll(reader); err != nil || len(data) == 0 {
+		t.Errorf("expected a pprof profile, got %d bytes (%v)", len(data), err)
+	}
+}
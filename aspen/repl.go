@@ -0,0 +1,338 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+const replHelp = `enter declarations, statements and expressions, the value and type of an expression are printed.
+input continues on the next line while a brace, parenthesis or bracket is left open.
+commands:
+    :type <expr>    print the type of an expression without evaluating it
+    :ast <code>     print the syntax tree of code without running it
+    :help           print this help
+    :quit           leave the repl, as does the end of the input`
+
+// Repl reads declarations, statements and expressions a line at a time and runs them. The variables, functions
+// and structs declared by an input can be used by the inputs that follow, so the type checker, the resolver and
+// the interpreter are kept between inputs.
+type Repl struct {
+	input  *bufio.Reader
+	output io.Writer
+
+	typeChecker *TypeChecker
+	resolver    *Resolver
+	interpreter *Interpreter
+}
+
+// TypeCheckerState is what an input changes in the type checker, an input with errors is undone
+type TypeCheckerState struct {
+	values     map[string]interface{}
+	functions  map[string]*FunctionStatement
+	structs    map[string]*Type
+	narrowings int
+}
+
+// NewRepl returns a repl reading inputs from `input` and writing the results to `output`
+func NewRepl(input io.Reader, output io.Writer, options RuntimeOptions) *Repl {
+	typeChecker := NewTypeChecker(NewErrorReporter(nil))
+	typeChecker.DefineBuiltins()
+
+	resolver := NewResolver(false)
+
+	return &Repl{
+		input:       bufio.NewReader(input),
+		output:      output,
+		typeChecker: typeChecker,
+		resolver:    resolver,
+		interpreter: NewInterpreter(resolver.Layout(), options),
+	}
+}
+
+// Run reads and runs inputs until the end of the input or the :quit command
+func (r *Repl) Run() {
+	for {
+		text, ok := r.Read()
+		if !ok {
+			fmt.Fprintln(r.output)
+			return
+		}
+
+		if strings.HasPrefix(text, ":") {
+			if !r.Command(text) {
+				return
+			}
+		} else if text != "" {
+			r.Execute(text)
+		}
+	}
+}
+
+// Read reads an input, which continues over several lines while it has unbalanced braces, parentheses or
+// brackets. It returns false at the end of the input.
+func (r *Repl) Read() (string, bool) {
+	lines := []string{}
+
+	fmt.Fprint(r.output, "aspen> ")
+	for {
+		line, ok := r.ReadLine()
+		if !ok {
+			break
+		}
+
+		lines = append(lines, line)
+		text := strings.TrimSpace(strings.Join(lines, "\n"))
+		if strings.HasPrefix(text, ":") || !Unbalanced(text) {
+			return text, true
+		}
+		fmt.Fprint(r.output, "...    ")
+	}
+
+	if len(lines) != 0 {
+		return strings.TrimSpace(strings.Join(lines, "\n")), true
+	}
+	return "", false
+}
+
+// ReadLine reads a line of any length without its line ending, it returns false at the end of the input
+func (r *Repl) ReadLine() (string, bool) {
+	line, err := r.input.ReadString('\n')
+	if err != nil && line == "" {
+		if err != io.EOF {
+			fmt.Fprintf(r.output, "\nerror: %v.", err)
+		}
+		return "", false
+	}
+	return strings.TrimSuffix(strings.TrimSuffix(line, "\n"), "\r"), true
+}
+
+// Unbalanced reports whether code opens more braces, parentheses or brackets than it closes
+func Unbalanced(code string) bool {
+	tokens, err := ScanTokens([]rune(code), NewErrorReporter([]rune(code)))
+	if err != nil {
+		// the error is reported once the input is run
+		return false
+	}
+
+	depth := 0
+	for _, token := range tokens {
+		switch token.tokenType {
+		case TOKEN_LEFT_BRACE, TOKEN_LEFT_PAREN, TOKEN_LEFT_SQUARE:
+			depth++
+		case TOKEN_RIGHT_BRACE, TOKEN_RIGHT_PAREN, TOKEN_RIGHT_SQUARE:
+			depth--
+		}
+	}
+	return depth > 0
+}
+
+// Command runs a command, it returns false once the user quits
+func (r *Repl) Command(text string) bool {
+	command, argument := text, ""
+	if i := strings.IndexAny(text, " \t\n"); i >= 0 {
+		command, argument = text[:i], strings.TrimSpace(text[i+1:])
+	}
+
+	switch command {
+	case ":type", ":t":
+		r.PrintType(argument)
+	case ":ast":
+		r.PrintAst(argument)
+	case ":help", ":h":
+		fmt.Fprintln(r.output, replHelp)
+	case ":quit", ":q":
+		return false
+	default:
+		fmt.Fprintf(r.output, "unknown command '%s', type :help to list the commands.\n", command)
+	}
+	return true
+}
+
+// Parse parses an input, the semicolon ending its last statement may be left out
+func (r *Repl) Parse(text string) (Program, []rune, error) {
+	if strings.HasSuffix(text, ";") {
+		ast, err := ParseSource([]rune(text))
+		return ast, []rune(text), err
+	}
+
+	source := []rune(text + ";")
+	ast, err := ParseSource(source)
+	if err != nil && strings.HasSuffix(text, "}") {
+		// statements ending with a block, such as declarations, take no semicolon
+		if blockAst, blockErr := ParseSource([]rune(text)); blockErr == nil {
+			return blockAst, []rune(text), nil
+		}
+	}
+	return ast, source, err
+}
+
+// Save returns the state of the type checker before an input is checked
+func (r *Repl) Save() TypeCheckerState {
+	tc := r.typeChecker
+	state := TypeCheckerState{
+		values:     make(map[string]interface{}),
+		functions:  make(map[string]*FunctionStatement),
+		structs:    make(map[string]*Type),
+		narrowings: len(tc.narrowings),
+	}
+
+	for name, value := range tc.environment.values {
+		state.values[name] = value
+	}
+	for name, function := range tc.scopes[0] {
+		state.functions[name] = function
+	}
+	for name, atype := range tc.structs {
+		state.structs[name] = atype
+	}
+	return state
+}
+
+func (r *Repl) Restore(state TypeCheckerState) {
+	tc := r.typeChecker
+	tc.environment.values, tc.scopes[0], tc.structs = state.values, state.functions, state.structs
+	tc.narrowings = tc.narrowings[:state.narrowings]
+	tc.currentFunction, tc.loops = nil, nil
+}
+
+// Check type checks an input, returning the types of its expression statements. An input with errors is undone.
+func (r *Repl) Check(ast Program, source []rune) (map[Statement]*Type, error) {
+	state := r.Save()
+
+	tc := r.typeChecker
+	tc.errorReporter = NewErrorReporter(source)
+	tc.DeclareGlobals(ast)
+
+	types := make(map[Statement]*Type)
+	for _, stmt := range ast {
+		if expression, ok := stmt.(*ExpressionStatement); ok {
+			types[stmt] = CheckExpression(tc, expression.expr)
+		} else {
+			tc.VisitStatementNode(stmt)
+		}
+	}
+
+	if tc.errorReporter.HadError() {
+		r.Restore(state)
+		return nil, tc.errorReporter
+	}
+	return types, nil
+}
+
+// Execute runs an input, printing the value and the type of its expressions
+func (r *Repl) Execute(text string) {
+	ast, source, err := r.Parse(text)
+	if err == nil {
+		err = r.RunProgram(ast, source)
+	}
+
+	if err != nil {
+		fmt.Fprintln(r.output, strings.TrimSpace(err.Error()))
+	}
+}
+
+// RunProgram checks and runs the statements of an input, a runtime error stops them
+func (r *Repl) RunProgram(ast Program, source []rune) error {
+	types, err := r.Check(ast, source)
+	if err != nil {
+		return err
+	}
+
+	r.resolver.ResolveProgram(ast)
+	r.interpreter.Grow(r.resolver.Layout())
+
+	for i, stmt := range ast {
+		atype := types[stmt]
+		if !IsPrinted(stmt, atype) {
+			err = r.interpreter.Run(Program{stmt}, source)
+		} else if value, evaluateErr := r.interpreter.Evaluate(stmt.(*ExpressionStatement).expr, source); evaluateErr == nil {
+			fmt.Fprintf(r.output, "%s (%v)\n", QuoteValue(value), atype)
+		} else {
+			err = evaluateErr
+		}
+
+		if err != nil {
+			r.Forget(ast[i:])
+			return err
+		}
+	}
+	return nil
+}
+
+// IsPrinted reports whether the value of a statement is printed, which is the case for expressions that have a
+// value, except for assignments
+func IsPrinted(stmt Statement, atype *Type) bool {
+	expression, ok := stmt.(*ExpressionStatement)
+	if !ok || atype.IsVoid() {
+		return false
+	}
+
+	switch expression.expr.(type) {
+	case *AssignmentExpression, *SubscriptAssignmentExpression, *FieldAssignmentExpression:
+		return false
+	}
+	return true
+}
+
+// Forget undeclares the variables and functions of statements that did not run because of a runtime error, so
+// that they can be declared again, both in the type checker and in the resolver
+func (r *Repl) Forget(ast Program) {
+	tc := r.typeChecker
+	variables := []*Variable{}
+	for _, stmt := range ast {
+		switch s := stmt.(type) {
+		case *LetStatement:
+			delete(tc.environment.values, s.name.String())
+			for _, name := range s.names {
+				delete(tc.environment.values, name.String())
+			}
+			variables = append(variables, s.variables...)
+		case *FunctionStatement:
+			delete(tc.environment.values, s.name.String())
+			delete(tc.scopes[0], s.name.String())
+			variables = append(variables, s.variable)
+		}
+	}
+	r.resolver.Forget(variables)
+}
+
+// PrintType prints the type of an expression without evaluating it
+func (r *Repl) PrintType(text string) {
+	ast, source, err := r.Parse(text)
+	if err != nil {
+		fmt.Fprintln(r.output, strings.TrimSpace(err.Error()))
+		return
+	}
+
+	var expression *ExpressionStatement
+	if len(ast) == 1 {
+		expression, _ = ast[0].(*ExpressionStatement)
+	}
+	if expression == nil {
+		fmt.Fprintln(r.output, errors.New("error: expected an expression."))
+		return
+	}
+
+	state := r.Save()
+	types, err := r.Check(ast, source)
+	r.Restore(state)
+
+	if err != nil {
+		fmt.Fprintln(r.output, strings.TrimSpace(err.Error()))
+		return
+	}
+	fmt.Fprintln(r.output, types[expression])
+}
+
+// PrintAst prints the syntax tree of code as an S-expression, like the -p option
+func (r *Repl) PrintAst(text string) {
+	ast, _, err := r.Parse(text)
+	if err != nil {
+		fmt.Fprintln(r.output, strings.TrimSpace(err.Error()))
+		return
+	}
+	fmt.Fprintln(r.output, ast)
+}
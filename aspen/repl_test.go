@@ -0,0 +1,112 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRepl(t *testing.T) {
+	Initialize()
+
+	input := `let x = 1 + 2
+x * 10
+fn square(n i64) i64 {
+    return n * n;
+}
+square(x)
+:type square
+:ast x * (2 + 1)
+let y = 1 / 0;
+let y = 5
+struct Point { x i64; y i64; }
+Point{x: y, y: x}
+let z bool = 1
+z
+x = 7
+x
+:quit
+`
+
+	expected := `aspen> aspen> 30 (i64)
+aspen> ...    ...    aspen> 9 (i64)
+aspen> fn(i64)i64
+aspen> ((expr (* (identifier x) (group (+ 2 1)))))
+aspen> error: integer division by zero.
+
+    1 | let y = 1 / 0;
+                  ^-- here.
+aspen> aspen> aspen> Point{x: 5, y: 3} (Point)
+aspen> error: cannot assign expression of type i64 to 'z', which has type bool.
+
+    1 | let z bool = 1;
+            ^-- here.
+aspen> error: undeclared identifier 'z'.
+
+    1 | z;
+        ^-- here.
+aspen> aspen> 7 (i64)
+aspen> `
+
+	output := strings.Builder{}
+	NewRepl(strings.NewReader(input), &output, DefaultRuntimeOptions()).Run()
+
+	if output.String() != expected {
+		t.Errorf("expected\n%s\ngot\n%s", expected, output.String())
+	}
+}
+
+func TestUnbalanced(t *testing.T) {
+	tests := []struct {
+		code       string
+		unbalanced bool
+	}{
+		{"let x = 1;", false},
+		{"fn f() i64 {", true},
+		{"fn f() i64 {\n    return 1;\n}", false},
+		{"let s = \"{\";", false},
+		{"f(1,", true},
+		{"let xs = i64[]{1, 2}", false},
+	}
+
+	for _, test := range tests {
+		if unbalanced := Unbalanced(test.code); unbalanced != test.unbalanced {
+			t.Errorf("%q: expected %v, got %v", test.code, test.unbalanced, unbalanced)
+		}
+	}
+}
+
+func TestReplForget(t *testing.T) {
+	Initialize()
+
+	output := strings.Builder{}
+	repl := NewRepl(strings.NewReader(""), &output, DefaultRuntimeOptions())
+	repl.Execute("let x = 1")
+	globals := len(repl.resolver.globals)
+
+	for i := 0; i < 3; i++ {
+		repl.Execute("let y = 1 / 0; fn f() i64 { return 1; }")
+		repl.Execute("let (a, b) = (1 / 0, 2)")
+	}
+	if len(repl.resolver.globals) != globals {
+		t.Errorf("expected %d globals after forgetting the failed inputs, got %d", globals, len(repl.resolver.globals))
+	}
+
+	output.Reset()
+	repl.Execute("let y = 2; fn f() i64 { return y; }")
+	repl.Execute("x + f()")
+	if output.String() != "3 (i64)\n" {
+		t.Errorf("expected the forgotten names to be declared again, got\n%s", output.String())
+	}
+}
+
+func TestReplLongLine(t *testing.T) {
+	Initialize()
+
+	input := "\"" + strings.Repeat("a", 100000) + "\"\n1 + 1\n"
+	output := strings.Builder{}
+	NewRepl(strings.NewReader(input), &output, DefaultRuntimeOptions()).Run()
+
+	if !strings.HasSuffix(output.String(), "aspen> 2 (i64)\naspen> \n") {
+		t.Errorf("expected the input after a long line to run, got\n%.200s", output.String())
+	}
+}
@@ -0,0 +1,522 @@
+package main
+
+import "sort"
+
+// Variable is a variable declared in a function, in a block or at the top level
+type Variable struct {
+	name     string
+	global   bool
+	index    int             // the index of the variable in the globals, or its slot in the frame of its function
+	function *FunctionLayout // the function the variable is declared in, nil for globals
+	atype    *Type           // nil for native and builtin functions
+
+	// whether a closure refers to the variable, captured variables are stored in a `Cell` that the closures share
+	captured bool
+}
+
+type BindingKind int
+
+const (
+	BINDING_GLOBAL   BindingKind = iota
+	BINDING_LOCAL                // a slot of the current frame
+	BINDING_CELL                 // a slot of the current frame holding a captured variable
+	BINDING_CAPTURED             // a variable of an enclosing function captured by the current closure
+)
+
+// Binding is where an identifier finds its variable at runtime
+type Binding struct {
+	kind     BindingKind
+	index    int
+	variable *Variable
+}
+
+// Capture describes a variable captured by a closure when the closure is created, either a slot of the
+// frame of the enclosing function or one of the variables captured by the enclosing function
+type Capture struct {
+	local bool
+	index int
+}
+
+// FunctionLayout describes the frame of a function
+type FunctionLayout struct {
+	enclosing   *FunctionLayout
+	declaration *FunctionStatement // nil for the top level code and the receivers of methods
+	size        int                // the number of slots in the frame
+	parameters  []*Variable
+	captures    []Capture
+
+	// the variables captured by the function, in the same order as `captures`
+	captured []*Variable
+
+	// the first free slot, the slots of a block are reused once the block ends
+	next int
+
+	// the bindings to the function's own variables, a binding becomes a BINDING_CELL once the function has
+	// been resolved if a closure captures its variable
+	locals []*Binding
+}
+
+// Capture returns the index of `variable` in the variables captured by the function
+func (f *FunctionLayout) Capture(variable *Variable) int {
+	for i, captured := range f.captured {
+		if captured == variable {
+			return i
+		}
+	}
+
+	capture := Capture{local: true, index: variable.index}
+	if f.enclosing != variable.function {
+		capture = Capture{local: false, index: f.enclosing.Capture(variable)}
+	}
+
+	f.captures = append(f.captures, capture)
+	f.captured = append(f.captured, variable)
+	return len(f.captures) - 1
+}
+
+// ProgramLayout is the result of resolving a program
+type ProgramLayout struct {
+	globals []string // the names of the global variables, indexed by their index
+	script  *FunctionLayout
+
+	// the position of each statement in its scope, only recorded for the debugger
+	positions map[Statement]ScopePosition
+}
+
+type Scope struct {
+	enclosing *Scope
+	variables map[string]*Variable
+	function  *FunctionLayout // nil for the global scope
+	start     int             // the first slot of the scope
+
+	// the variables declared in the scope in the order of their declarations
+	declared []*Variable
+
+	// the number of variables of the enclosing scope declared when the scope began
+	visible int
+}
+
+// ScopePosition is a point in a scope, the variables in scope there are those declared in the scope before that
+// point and those in scope in the enclosing scope when the scope began
+type ScopePosition struct {
+	scope    *Scope
+	declared int
+}
+
+// Variables returns the variables in scope at a position, innermost first, leaving out the variables shadowed by
+// an inner variable of the same name
+func (p ScopePosition) Variables() []*Variable {
+	variables := []*Variable{}
+	shadowed := make(map[string]bool)
+
+	scope, declared := p.scope, p.declared
+	for scope != nil {
+		for i := declared - 1; i >= 0; i-- {
+			variable := scope.declared[i]
+			if !shadowed[variable.name] {
+				variables = append(variables, variable)
+				shadowed[variable.name] = true
+			}
+		}
+		scope, declared = scope.enclosing, scope.visible
+	}
+
+	return variables
+}
+
+// Resolver assigns each variable of a type checked program a global index or a slot in the frame of its
+// function, and binds each identifier to the variable the type checker resolved it to
+type Resolver struct {
+	scope    *Scope
+	function *FunctionLayout
+	globals  []string
+
+	// the position of each statement in its scope, nil unless positions are recorded
+	positions map[Statement]ScopePosition
+}
+
+func NewScope(enclosing *Scope, function *FunctionLayout) *Scope {
+	scope := &Scope{enclosing: enclosing, variables: make(map[string]*Variable), function: function}
+	if enclosing != nil {
+		scope.visible = len(enclosing.declared)
+	}
+	if function != nil {
+		scope.start = function.next
+	}
+	return scope
+}
+
+func (r *Resolver) Declare(name string, atype *Type) *Variable {
+	variable := &Variable{name: name, atype: atype}
+
+	if function := r.scope.function; function == nil {
+		variable.global = true
+		variable.index = len(r.globals)
+		r.globals = append(r.globals, name)
+	} else {
+		variable.function = function
+		variable.index = function.next
+		function.next++
+		if function.next > function.size {
+			function.size = function.next
+		}
+	}
+
+	r.scope.variables[name] = variable
+	r.scope.declared = append(r.scope.declared, variable)
+	return variable
+}
+
+// Bind resolves the identifier `name`, which the type checker found `depth` scopes up
+func (r *Resolver) Bind(name string, depth int, binding *Binding) {
+	scope := r.scope
+	for i := 0; i < depth; i++ {
+		scope = scope.enclosing
+	}
+
+	variable, ok := scope.variables[name]
+	if !ok {
+		Unreachable("Resolver::Bind")
+	}
+
+	switch {
+	case variable.global:
+		*binding = Binding{kind: BINDING_GLOBAL, index: variable.index}
+	case variable.function == r.function:
+		*binding = Binding{kind: BINDING_LOCAL, index: variable.index, variable: variable}
+		r.function.locals = append(r.function.locals, binding)
+	default:
+		variable.captured = true
+		*binding = Binding{kind: BINDING_CAPTURED, index: r.function.Capture(variable)}
+	}
+}
+
+func (r *Resolver) BeginScope() {
+	r.scope = NewScope(r.scope, r.function)
+}
+
+func (r *Resolver) EndScope() {
+	r.function.next = r.scope.start
+	r.scope = r.scope.enclosing
+}
+
+// ResolveFunction resolves the body of a function declared in the current scope
+func (r *Resolver) ResolveFunction(stmt *FunctionStatement) {
+	layout := &FunctionLayout{enclosing: r.function, declaration: stmt}
+
+	enclosingScope, enclosingFunction := r.scope, r.function
+	r.function = layout
+	r.scope = NewScope(r.scope, layout)
+
+	// the parameters are the first slots, the body of the function shares their scope
+	for i, parameter := range stmt.parameters {
+		layout.parameters = append(layout.parameters, r.Declare(parameter.String(), stmt.atype.parameters[i]))
+	}
+
+	for _, stmt := range stmt.body.statements {
+		r.VisitStatementNode(stmt)
+	}
+
+	for _, binding := range layout.locals {
+		if binding.variable.captured {
+			binding.kind = BINDING_CELL
+		}
+	}
+
+	stmt.layout = layout
+	r.scope, r.function = enclosingScope, enclosingFunction
+}
+
+// Resolve resolves a type checked program, recording the position of each statement in its scope if `positions`
+// is set
+func Resolve(ast Program, positions bool) *ProgramLayout {
+	resolver := NewResolver(positions)
+	resolver.ResolveProgram(ast)
+	return resolver.Layout()
+}
+
+// NewResolver returns a resolver for the top level code of a program, with the native and builtin functions
+// declared
+func NewResolver(positions bool) *Resolver {
+	resolver := &Resolver{function: &FunctionLayout{}}
+	resolver.scope = NewScope(nil, nil)
+	if positions {
+		resolver.positions = make(map[Statement]ScopePosition)
+	}
+
+	// native and builtin functions are the first globals
+	names := []string{}
+	for name := range NativeFunctions {
+		names = append(names, name)
+	}
+	for name := range BuiltinFunctions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		var atype *Type
+		if native, ok := NativeFunctions[name]; ok {
+			atype = &Type{kind: TYPE_FUNCTION, other: native.atype}
+		}
+		resolver.Declare(name, atype)
+	}
+
+	return resolver
+}
+
+// ResolveProgram resolves the statements of a type checked program, a program can be resolved in several parts
+func (r *Resolver) ResolveProgram(ast Program) {
+	// global functions are declared ahead of time, like the type checker does
+	for _, stmt := range ast {
+		if fn, ok := stmt.(*FunctionStatement); ok {
+			fn.variable = r.Declare(fn.name.String(), &Type{kind: TYPE_FUNCTION, other: fn.atype})
+		}
+	}
+
+	for _, stmt := range ast {
+		r.VisitStatementNode(stmt)
+	}
+
+	for _, binding := range r.function.locals {
+		if binding.variable.captured {
+			binding.kind = BINDING_CELL
+		}
+	}
+}
+
+// Forget undeclares global variables, so that they can be declared again. The globals at the end of the
+// globals are given back, so that declaring them again reuses their indices.
+func (r *Resolver) Forget(variables []*Variable) {
+	forgotten := make(map[int]bool)
+	for _, variable := range variables {
+		if r.scope.variables[variable.name] == variable {
+			delete(r.scope.variables, variable.name)
+		}
+		forgotten[variable.index] = true
+	}
+
+	declared := []*Variable{}
+	for _, variable := range r.scope.declared {
+		if !forgotten[variable.index] {
+			declared = append(declared, variable)
+		}
+	}
+	r.scope.declared = declared
+
+	for len(r.globals) > 0 && forgotten[len(r.globals)-1] {
+		r.globals = r.globals[:len(r.globals)-1]
+	}
+}
+
+// Layout returns the layout of the program resolved so far
+func (r *Resolver) Layout() *ProgramLayout {
+	return &ProgramLayout{globals: r.globals, script: r.function, positions: r.positions}
+}
+
+func (r *Resolver) VisitExpressionNode(expr Expression) interface{} {
+	return expr.Accept(r)
+}
+
+func (r *Resolver) VisitStatementNode(stmt Statement) interface{} {
+	if r.positions != nil {
+		r.positions[stmt] = ScopePosition{scope: r.scope, declared: len(r.scope.declared)}
+	}
+	return stmt.Accept(r)
+}
+
+func (r *Resolver) VisitBinary(expr *BinaryExpression) interface{} {
+	r.VisitExpressionNode(expr.left)
+	r.VisitExpressionNode(expr.right)
+	return nil
+}
+
+func (r *Resolver) VisitUnary(expr *UnaryExpression) interface{} {
+	r.VisitExpressionNode(expr.operand)
+	return nil
+}
+
+func (r *Resolver) VisitLiteral(expr *LiteralExpression) interface{} {
+	return nil
+}
+
+func (r *Resolver) VisitGrouping(expr *GroupingExpression) interface{} {
+	r.VisitExpressionNode(expr.expr)
+	return nil
+}
+
+func (r *Resolver) VisitIdentifier(expr *IdentifierExpression) interface{} {
+	r.Bind(expr.name.String(), expr.depth, &expr.binding)
+	return nil
+}
+
+func (r *Resolver) VisitAssignment(expr *AssignmentExpression) interface{} {
+	r.Bind(expr.name.String(), expr.depth, &expr.binding)
+	r.VisitExpressionNode(expr.value)
+	return nil
+}
+
+func (r *Resolver) VisitCall(expr *CallExpression) interface{} {
+	r.VisitExpressionNode(expr.callee)
+	for _, argument := range expr.arguments {
+		r.VisitExpressionNode(argument)
+	}
+	return nil
+}
+
+func (r *Resolver) VisitTypeCast(expr *TypeCastExpression) interface{} {
+	r.VisitExpressionNode(expr.value)
+	return nil
+}
+
+func (r *Resolver) VisitSubscript(expr *SubscriptExpression) interface{} {
+	r.VisitExpressionNode(expr.object)
+	r.VisitExpressionNode(expr.index)
+	return nil
+}
+
+func (r *Resolver) VisitSubscriptAssignment(expr *SubscriptAssignmentExpression) interface{} {
+	r.VisitExpressionNode(expr.target)
+	r.VisitExpressionNode(expr.value)
+	return nil
+}
+
+func (r *Resolver) VisitSliceLiteral(expr *SliceLiteralExpression) interface{} {
+	for _, element := range expr.elements {
+		r.VisitExpressionNode(element)
+	}
+	return nil
+}
+
+func (r *Resolver) VisitField(expr *FieldExpression) interface{} {
+	r.VisitExpressionNode(expr.object)
+	return nil
+}
+
+func (r *Resolver) VisitFieldAssignment(expr *FieldAssignmentExpression) interface{} {
+	r.VisitExpressionNode(expr.target)
+	r.VisitExpressionNode(expr.value)
+	return nil
+}
+
+func (r *Resolver) VisitStructLiteral(expr *StructLiteralExpression) interface{} {
+	for _, value := range expr.values {
+		r.VisitExpressionNode(value)
+	}
+	return nil
+}
+
+func (r *Resolver) VisitMapLiteral(expr *MapLiteralExpression) interface{} {
+	for i := range expr.keys {
+		r.VisitExpressionNode(expr.keys[i])
+		r.VisitExpressionNode(expr.values[i])
+	}
+	return nil
+}
+
+func (r *Resolver) VisitTuple(expr *TupleExpression) interface{} {
+	for _, element := range expr.elements {
+		r.VisitExpressionNode(element)
+	}
+	return nil
+}
+
+func (r *Resolver) VisitFunctionLiteral(expr *FunctionLiteralExpression) interface{} {
+	r.ResolveFunction(expr.function)
+	return nil
+}
+
+func (r *Resolver) VisitExpression(stmt *ExpressionStatement) interface{} {
+	r.VisitExpressionNode(stmt.expr)
+	return nil
+}
+
+func (r *Resolver) VisitPrint(stmt *PrintStatement) interface{} {
+	r.VisitExpressionNode(stmt.expr)
+	return nil
+}
+
+func (r *Resolver) VisitLet(stmt *LetStatement) interface{} {
+	r.VisitExpressionNode(stmt.initializer)
+
+	if stmt.names == nil {
+		stmt.variables = []*Variable{r.Declare(stmt.name.String(), stmt.atype)}
+		return nil
+	}
+
+	elements := stmt.atype.other.(TupleType).elements
+	stmt.variables = make([]*Variable, len(stmt.names))
+	for i, name := range stmt.names {
+		stmt.variables[i] = r.Declare(name.String(), elements[i])
+	}
+	return nil
+}
+
+func (r *Resolver) VisitBlock(stmt *BlockStatement) interface{} {
+	r.BeginScope()
+	for _, stmt := range stmt.statements {
+		r.VisitStatementNode(stmt)
+	}
+	r.EndScope()
+	return nil
+}
+
+func (r *Resolver) VisitIf(stmt *IfStatement) interface{} {
+	r.VisitExpressionNode(stmt.condition)
+	r.VisitStatementNode(stmt.thenBranch)
+	if stmt.elseBranch != nil {
+		r.VisitStatementNode(stmt.elseBranch)
+	}
+	return nil
+}
+
+func (r *Resolver) VisitWhile(stmt *WhileStatement) interface{} {
+	r.VisitExpressionNode(stmt.condition)
+	r.VisitStatementNode(stmt.body)
+	if stmt.increment != nil {
+		r.VisitExpressionNode(stmt.increment)
+	}
+	return nil
+}
+
+func (r *Resolver) VisitBreak(stmt *BreakStatement) interface{} {
+	return nil
+}
+
+func (r *Resolver) VisitContinue(stmt *ContinueStatement) interface{} {
+	return nil
+}
+
+func (r *Resolver) VisitFunction(stmt *FunctionStatement) interface{} {
+	if r.scope.function != nil {
+		// declare the function first so that it can refer to itself, global functions were declared ahead of time
+		stmt.variable = r.Declare(stmt.name.String(), &Type{kind: TYPE_FUNCTION, other: stmt.atype})
+	}
+
+	r.ResolveFunction(stmt)
+	return nil
+}
+
+func (r *Resolver) VisitReturn(stmt *ReturnStatement) interface{} {
+	if stmt.value != nil {
+		r.VisitExpressionNode(stmt.value)
+	}
+	return nil
+}
+
+func (r *Resolver) VisitStruct(stmt *StructStatement) interface{} {
+	// methods are resolved as if they were declared in a function whose only variable is `self`, binding a
+	// method to its receiver supplies that variable
+	receiver := &FunctionLayout{}
+
+	enclosingScope, enclosingFunction := r.scope, r.function
+	r.function = receiver
+	r.scope = NewScope(r.scope, receiver)
+	r.Declare("self", stmt.atype)
+
+	for _, method := range stmt.methods {
+		r.ResolveFunction(method)
+	}
+
+	r.scope, r.function = enclosingScope, enclosingFunction
+	return nil
+}
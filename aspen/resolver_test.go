@@ -0,0 +1,176 @@
+package main
+
+import (
+	"testing"
+)
+
+// ResolveSource type checks and resolves a program
+func ResolveSource(t *testing.T, source string) Program {
+	ast, err := TypeCheckSource([]rune(source))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	Resolve(ast, false)
+	return ast
+}
+
+// LocalBindings returns the kinds of the bindings of a function to its own variables by variable name
+func LocalBindings(layout *FunctionLayout) map[string]BindingKind {
+	kinds := make(map[string]BindingKind)
+	for _, binding := range layout.locals {
+		kinds[binding.variable.name] = binding.kind
+	}
+	return kinds
+}
+
+func TestResolveSlots(t *testing.T) {
+	Initialize()
+
+	ast := ResolveSource(t, `fn outer(a i64, b i64) fn()i64 {
+    let c = a + b;
+    {
+        let d = c;
+        print d;
+    }
+    let e = 1;
+    fn inner() i64 {
+        return a + e;
+    }
+    return inner;
+}
+print outer(1, 2)();`)
+
+	outer := ast[0].(*FunctionStatement)
+	inner := outer.body.statements[3].(*FunctionStatement)
+
+	// the slot of d is reused by e once the block of d ends
+	if outer.layout.size != 5 {
+		t.Errorf("expected a frame of 5 slots, got %d", outer.layout.size)
+	}
+	if a, b := outer.layout.parameters[0], outer.layout.parameters[1]; a.index != 0 || b.index != 1 {
+		t.Errorf("expected the parameters in slots 0 and 1, got %d and %d", a.index, b.index)
+	}
+	if inner.variable.index != 4 {
+		t.Errorf("expected inner in slot 4, got %d", inner.variable.index)
+	}
+
+	expected := map[string]BindingKind{"a": BINDING_CELL, "b": BINDING_LOCAL, "c": BINDING_LOCAL, "d": BINDING_LOCAL, "inner": BINDING_LOCAL}
+	for name, kind := range LocalBindings(outer.layout) {
+		if expected[name] != kind {
+			t.Errorf("expected %s to be bound as %d, got %d", name, expected[name], kind)
+		}
+	}
+
+	if !outer.variable.global || outer.variable.index < len(NativeFunctions)+len(BuiltinFunctions) {
+		t.Errorf("expected outer to be a global after the native and builtin functions, got %+v", outer.variable)
+	}
+}
+
+func TestResolveCaptures(t *testing.T) {
+	Initialize()
+
+	ast := ResolveSource(t, `fn outer(a i64, b i64) fn()fn()i64 {
+    let c = b;
+    let d = 4;
+    return fn() fn()i64 {
+        print c;
+        return fn() i64 {
+            a = a + d;
+            return a;
+        };
+    };
+}
+print outer(1, 2)()();`)
+
+	outer := ast[0].(*FunctionStatement)
+	middle := outer.body.statements[2].(*ReturnStatement).value.(*FunctionLiteralExpression).function
+	inner := middle.body.statements[1].(*ReturnStatement).value.(*FunctionLiteralExpression).function
+
+	// only the variables a closure uses are captured, b is not
+	for _, variable := range outer.layout.parameters {
+		if captured := variable.name == "a"; variable.captured != captured {
+			t.Errorf("expected %s to be captured: %v", variable.name, captured)
+		}
+	}
+
+	// the middle closure captures c for itself and a and d on behalf of the inner closure, which captures them
+	// from the middle closure rather than from the frame of outer
+	expectedMiddle := []Capture{{local: true, index: 2}, {local: true, index: 0}, {local: true, index: 3}}
+	expectedInner := []Capture{{local: false, index: 1}, {local: false, index: 2}}
+
+	if got := middle.layout.captures; !EqualCaptures(got, expectedMiddle) {
+		t.Errorf("expected the middle closure to capture %v, got %v", expectedMiddle, got)
+	}
+	if got := inner.layout.captures; !EqualCaptures(got, expectedInner) {
+		t.Errorf("expected the inner closure to capture %v, got %v", expectedInner, got)
+	}
+
+	if kinds := LocalBindings(inner.layout); len(kinds) != 0 {
+		t.Errorf("expected the inner closure to have no variables of its own, got %v", kinds)
+	}
+}
+
+func EqualCaptures(a, b []Capture) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func BenchmarkFib(b *testing.B) {
+	Initialize()
+
+	source := []rune(`fn fib(n i64) i64 {
+    if (n <= 1) {
+        return n;
+    }
+    return fib(n - 1) + fib(n - 2);
+}
+fib(20);`)
+
+	ast, err := OptimizeSource(source)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := Interpret(ast, source, DefaultRuntimeOptions()); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkClosure(b *testing.B) {
+	Initialize()
+
+	source := []rune(`fn counter() fn()i64 {
+    let count = 0;
+    return fn() i64 {
+        count = count + 1;
+        return count;
+    };
+}
+let next = counter();
+for (let i = 0; i < 10000; i = i + 1) {
+    next();
+}`)
+
+	ast, err := OptimizeSource(source)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := Interpret(ast, source, DefaultRuntimeOptions()); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,127 @@
+package main
+
+import "strings"
+
+// Comment is a comment of the source code, `blank` is true if an empty line separates it from what precedes it
+type Comment struct {
+	token Token
+	blank bool
+}
+
+// ForLoop is a for loop as it was written, before it was desugared into a while loop. A loop over a map has a
+// `key` and an `iterable`, other loops have an optional initializer, condition and increment.
+type ForLoop struct {
+	label       *Token
+	initializer Statement
+	condition   Expression
+	increment   Expression
+	key         *Token
+	value       *Token
+	iterable    Expression
+	body        *BlockStatement
+}
+
+// Interpolation is an interpolated string as it was written, the expressions are found between its segments
+type Interpolation struct {
+	segments []Token
+	values   []Expression
+}
+
+// Syntax records what the syntax tree of a program leaves out of its source code, which the formatter needs to
+// print the program as it was written. Comments are attached to the statements, the struct fields and methods,
+// the entries of slice, map and struct literals, and the blocks and literals they belong to. Any other comment
+// within an expression is attached to the statement of the expression.
+type Syntax struct {
+	// the comments on the lines before a node, the nodes are statements, struct field names and the values of the
+	// entries of literals
+	leading map[interface{}][]Comment
+	// the comment following a node on its last line
+	trailing map[interface{}]Comment
+	// the comments before the closing brace of a block, struct declaration or literal
+	closing map[interface{}][]Comment
+	// the comments after the last statement of the program
+	end []Comment
+
+	// the nodes separated by an empty line from their leading comment or from what precedes them
+	blank map[interface{}]bool
+
+	// the for loops keyed by the statement they were desugared into
+	loops map[Statement]*ForLoop
+	// the interpolated strings keyed by the expression they were lowered to
+	interpolations map[Expression]*Interpolation
+}
+
+func NewSyntax() *Syntax {
+	return &Syntax{
+		leading:        make(map[interface{}][]Comment),
+		trailing:       make(map[interface{}]Comment),
+		closing:        make(map[interface{}][]Comment),
+		blank:          make(map[interface{}]bool),
+		loops:          make(map[Statement]*ForLoop),
+		interpolations: make(map[Expression]*Interpolation),
+	}
+}
+
+// LastLine returns the line a token ends on, a multi-line comment spans several lines
+func LastLine(token Token) int {
+	if token.tokenType == TOKEN_COMMENT {
+		return token.line + strings.Count(token.value.(string), "\n")
+	}
+	return token.line
+}
+
+// SeparateComments removes the comments from a token stream, returning the comments found before each of the
+// remaining tokens and whether an empty line precedes each token
+func SeparateComments(tokens TokenStream) (TokenStream, [][]Comment, []bool) {
+	filtered := make(TokenStream, 0, len(tokens))
+	comments := [][]Comment{}
+	blank := []bool{}
+
+	pending := []Comment{}
+	last := 0
+	for _, token := range tokens {
+		separated := last != 0 && token.line > last+1
+		last = LastLine(token)
+
+		if token.tokenType == TOKEN_COMMENT {
+			pending = append(pending, Comment{token: token, blank: separated})
+			continue
+		}
+
+		filtered = append(filtered, token)
+		comments = append(comments, pending)
+		blank = append(blank, separated)
+		pending = []Comment{}
+	}
+
+	return filtered, comments, blank
+}
+
+// TakeComments returns the comments before the token at `index`, which are then no longer available
+func (p *Parser) TakeComments(index int) []Comment {
+	comments := p.comments[index]
+	p.comments[index] = nil
+	return comments
+}
+
+// Attach attaches the comments of a node starting at the token at `start` and ending at the previous token: the
+// comments before it, the comments within it that were not attached to the nodes it contains and the comment
+// following it on its last line
+func (p *Parser) Attach(node interface{}, start int) {
+	leading := p.TakeComments(start)
+	for i := start + 1; i < p.current; i++ {
+		leading = append(leading, p.TakeComments(i)...)
+	}
+	if len(leading) != 0 {
+		p.syntax.leading[node] = leading
+	}
+
+	if following := p.comments[p.current]; len(following) != 0 && following[0].token.line == p.Previous().line {
+		p.syntax.trailing[node] = following[0]
+		p.comments[p.current] = following[1:]
+	}
+
+	if p.blank[start] {
+		p.syntax.blank[node] = true
+	}
+}
@@ -132,18 +132,23 @@ func GenerateASTCode() {
 	exprNodes.defineNode("Identifier", Fields{
 		{"name", "Token"},
 		{"depth", "int"},
+		{"narrowed", "bool"},
+		{"binding", "Binding"},
+		{"generated", "bool"},
 	})
 
 	exprNodes.defineNode("Assignment", Fields{
 		{"name", "Token"},
 		{"value", "Expression"},
 		{"depth", "int"},
+		{"binding", "Binding"},
 	})
 
 	exprNodes.defineNode("Call", Fields{
 		{"callee", "Expression"},
 		{"arguments", "[]Expression"},
 		{"loc", "Token"},
+		{"tail", "bool"},
 	})
 
 	exprNodes.defineNode("TypeCast", Fields{
@@ -153,6 +158,56 @@ func GenerateASTCode() {
 		{"loc", "Token"},
 	})
 
+	exprNodes.defineNode("Subscript", Fields{
+		{"object", "Expression"},
+		{"index", "Expression"},
+		{"loc", "Token"},
+	})
+
+	exprNodes.defineNode("SubscriptAssignment", Fields{
+		{"target", "*SubscriptExpression"},
+		{"value", "Expression"},
+	})
+
+	exprNodes.defineNode("SliceLiteral", Fields{
+		{"atype", "*Type"},
+		{"elements", "[]Expression"},
+		{"loc", "Token"},
+	})
+
+	exprNodes.defineNode("Field", Fields{
+		{"object", "Expression"},
+		{"name", "Token"},
+	})
+
+	exprNodes.defineNode("FieldAssignment", Fields{
+		{"target", "*FieldExpression"},
+		{"value", "Expression"},
+	})
+
+	exprNodes.defineNode("StructLiteral", Fields{
+		{"atype", "*Type"},
+		{"fields", "[]Token"},
+		{"values", "[]Expression"},
+		{"loc", "Token"},
+	})
+
+	exprNodes.defineNode("MapLiteral", Fields{
+		{"atype", "*Type"},
+		{"keys", "[]Expression"},
+		{"values", "[]Expression"},
+		{"loc", "Token"},
+	})
+
+	exprNodes.defineNode("Tuple", Fields{
+		{"elements", "[]Expression"},
+		{"loc", "Token"},
+	})
+
+	exprNodes.defineNode("FunctionLiteral", Fields{
+		{"function", "*FunctionStatement"},
+	})
+
 	exprNodes.defineMethod("Accept", Fields{
 		{"visitor", "ExpressionVisitor"},
 	}, "interface{}", func(w io.Writer, nodeName string) {
@@ -178,8 +233,11 @@ func GenerateASTCode() {
 
 	stmtNodes.defineNode("Let", Fields{
 		{"name", "Token"},
+		{"names", "[]Token"},
 		{"initializer", "Expression"},
 		{"atype", "*Type"},
+		{"inferred", "bool"},
+		{"variables", "[]*Variable"},
 	})
 
 	stmtNodes.defineNode("Block", Fields{
@@ -196,6 +254,8 @@ func GenerateASTCode() {
 	stmtNodes.defineNode("While", Fields{
 		{"condition", "Expression"},
 		{"body", "Statement"},
+		{"increment", "Expression"},
+		{"label", "*Token"},
 		{"loc", "Token"},
 	})
 
@@ -204,6 +264,8 @@ func GenerateASTCode() {
 		{"parameters", "[]Token"},
 		{"body", "*BlockStatement"},
 		{"atype", "FunctionType"},
+		{"variable", "*Variable"},
+		{"layout", "*FunctionLayout"},
 	})
 
 	stmtNodes.defineNode("Return", Fields{
@@ -211,6 +273,25 @@ func GenerateASTCode() {
 		{"loc", "Token"},
 	})
 
+	stmtNodes.defineNode("Break", Fields{
+		{"label", "*Token"},
+		{"target", "*WhileStatement"},
+		{"loc", "Token"},
+	})
+
+	stmtNodes.defineNode("Continue", Fields{
+		{"label", "*Token"},
+		{"target", "*WhileStatement"},
+		{"loc", "Token"},
+	})
+
+	stmtNodes.defineNode("Struct", Fields{
+		{"name", "Token"},
+		{"fields", "[]Token"},
+		{"methods", "[]*FunctionStatement"},
+		{"atype", "*Type"},
+	})
+
 	stmtNodes.defineMethod("Accept", Fields{
 		{"visitor", "StatementVisitor"},
 	}, "interface{}", func(w io.Writer, nodeName string) {
@@ -28,7 +28,17 @@ func writeBinaryOperatorFunction(w io.Writer, operator string, functionName stri
 	fmt.Fprintln(w, "}")
 }
 
-const code = `import "fmt"
+const code = `import (
+	"fmt"
+	"strings"
+)
+
+type TupleValue []interface{}
+
+type StructValue struct {
+	atype  *StructType
+	fields []interface{}
+}
 
 func IsValueType(iface interface{}) bool {
 	switch iface.(type) {
@@ -39,17 +49,69 @@ func IsValueType(iface interface{}) bool {
 	}
 }
 
-func PrintValue(iface interface{}) {
+func FormatValue(iface interface{}) string {
 	switch v := iface.(type) {
+	case nil:
+		return "nil"
 	case []rune:
-		fmt.Println(string(v))
+		return string(v)
+	case []interface{}:
+		builder := strings.Builder{}
+		builder.WriteRune('[')
+		for i, element := range v {
+			builder.WriteString(FormatValue(element))
+			if i != len(v)-1 {
+				builder.WriteRune(' ')
+			}
+		}
+		builder.WriteRune(']')
+		return builder.String()
+	case TupleValue:
+		builder := strings.Builder{}
+		builder.WriteRune('(')
+		for i, element := range v {
+			builder.WriteString(FormatValue(element))
+			if i != len(v)-1 {
+				builder.WriteString(", ")
+			}
+		}
+		builder.WriteRune(')')
+		return builder.String()
+	case *MapValue:
+		builder := strings.Builder{}
+		builder.WriteString("map[")
+		keys, values := v.Entries()
+		for i := range keys {
+			fmt.Fprintf(&builder, "%s:%s", FormatValue(keys[i]), FormatValue(values[i]))
+			if i != len(keys)-1 {
+				builder.WriteRune(' ')
+			}
+		}
+		builder.WriteRune(']')
+		return builder.String()
+	case *StructValue:
+		builder := strings.Builder{}
+		fmt.Fprintf(&builder, "%s{", v.atype.name)
+		for i, field := range v.fields {
+			fmt.Fprintf(&builder, "%s: %s", v.atype.fields[i].name, FormatValue(field))
+			if i != len(v.fields)-1 {
+				builder.WriteString(", ")
+			}
+		}
+		builder.WriteRune('}')
+		return builder.String()
 	default:
-		fmt.Println(v)
+		return fmt.Sprint(v)
 	}
 }
 
+func PrintValue(iface interface{}) {
+	fmt.Println(FormatValue(iface))
+}
+
 func ValuesEqual(lhs, rhs interface{}) bool {
-	if IsValueType(lhs) {
+	if IsValueType(lhs) || rhs == nil {
+		// nil is only equal to itself
 		return lhs == rhs
 	}
 
@@ -66,6 +128,48 @@ func ValuesEqual(lhs, rhs interface{}) bool {
 			}
 		}
 		return true
+	case []interface{}:
+		rhsV := rhs.([]interface{})
+		if len(rhsV) != len(lhsV) {
+			return false
+		}
+
+		for i := range lhsV {
+			if !ValuesEqual(lhsV[i], rhsV[i]) {
+				return false
+			}
+		}
+		return true
+	case TupleValue:
+		rhsV := rhs.(TupleValue)
+		for i := range lhsV {
+			if !ValuesEqual(lhsV[i], rhsV[i]) {
+				return false
+			}
+		}
+		return true
+	case *MapValue:
+		rhsV := rhs.(*MapValue)
+		if lhsV.Len() != rhsV.Len() {
+			return false
+		}
+
+		keys, values := lhsV.Entries()
+		for i := range keys {
+			value, ok := rhsV.Get(keys[i])
+			if !ok || !ValuesEqual(values[i], value) {
+				return false
+			}
+		}
+		return true
+	case *StructValue:
+		rhsV := rhs.(*StructValue)
+		for i := range lhsV.fields {
+			if !ValuesEqual(lhsV.fields[i], rhsV.fields[i]) {
+				return false
+			}
+		}
+		return true
 	case *NativeFunction:
 		rhsV, ok := rhs.(*NativeFunction)
 		if !ok {
@@ -78,6 +182,12 @@ func ValuesEqual(lhs, rhs interface{}) bool {
 			return false
 		}
 		return lhsV == rhsV
+	case *Closure:
+		rhsV, ok := rhs.(*Closure)
+		if !ok {
+			return false
+		}
+		return lhsV == rhsV
 	}
 
 	Unreachable("ValuesEqual")
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// MAX_TRACE_INDENT is the deepest call nesting shown by the indentation of a trace
+const MAX_TRACE_INDENT = 32
+
+// MAX_TRACE_VALUE_LENGTH is the number of characters of a value shown in a trace, longer values are cut short
+const MAX_TRACE_VALUE_LENGTH = 80
+
+// TraceFrame is a call in progress
+type TraceFrame struct {
+	name   string
+	void   bool // whether the function returns nothing
+	traced bool // whether the call is to one of the functions the tracer is restricted to
+}
+
+// Tracer is an observer that writes each statement executed, each call with its arguments, each return with its
+// value and each assignment with the old and the new value of the variable
+type Tracer struct {
+	writer io.Writer
+	lines  []string // the lines of the source code
+
+	// the names of the functions whose calls are traced, everything is traced if it is nil
+	functions map[string]bool
+
+	stack []TraceFrame
+
+	// the number of calls in `stack` to functions in `functions`
+	traced int
+}
+
+// NewTracer returns a tracer writing the trace of the program `source` to `writer`, restricted to the calls to
+// `functions` and the calls they make unless `functions` is empty
+func NewTracer(writer io.Writer, source []rune, functions []string) *Tracer {
+	t := &Tracer{writer: writer, lines: strings.Split(string(source), "\n")}
+
+	if len(functions) != 0 {
+		t.functions = make(map[string]bool)
+		for _, name := range functions {
+			t.functions[name] = true
+		}
+	}
+
+	return t
+}
+
+// Tracing reports whether events are traced at this point of the program
+func (t *Tracer) Tracing() bool {
+	return t.functions == nil || t.traced != 0
+}
+
+func (t *Tracer) Print(position Token, depth int, format string, args ...interface{}) {
+	location := ""
+	if position.line != 0 {
+		location = fmt.Sprintf("%d:%d", position.line, position.col)
+	}
+
+	if depth > MAX_TRACE_INDENT {
+		depth = MAX_TRACE_INDENT
+	}
+
+	fmt.Fprintf(t.writer, "trace %-8s %s%s\n", location, strings.Repeat("  ", depth), fmt.Sprintf(format, args...))
+}
+
+// QuoteValue formats a value, strings are quoted to tell them apart from other values
+func QuoteValue(value interface{}) string {
+	if runes, ok := value.([]rune); ok {
+		return strconv.Quote(string(runes))
+	}
+	return FormatValue(value)
+}
+
+// TraceValue formats a value for a trace
+func TraceValue(value interface{}) string {
+	s := QuoteValue(value)
+	if len(s) > MAX_TRACE_VALUE_LENGTH {
+		return s[:MAX_TRACE_VALUE_LENGTH] + "..."
+	}
+	return s
+}
+
+func TraceArguments(arguments []interface{}) string {
+	values := make([]string, len(arguments))
+	for i, argument := range arguments {
+		values[i] = TraceValue(argument)
+	}
+	return strings.Join(values, ", ")
+}
+
+// Frame returns the frame of a call to `function`
+func (t *Tracer) Frame(function AspenFunction) TraceFrame {
+	frame := TraceFrame{name: function.Name(), traced: t.functions[function.Name()]}
+
+	switch f := function.(type) {
+	case *UserFunction:
+		frame.void = f.declaration.atype.returnType.IsVoid()
+	case *NativeFunction:
+		frame.void = f.atype.returnType.IsVoid()
+	}
+
+	return frame
+}
+
+func (t *Tracer) Push(frame TraceFrame) {
+	t.stack = append(t.stack, frame)
+	if frame.traced {
+		t.traced++
+	}
+}
+
+func (t *Tracer) Pop() TraceFrame {
+	frame := t.stack[len(t.stack)-1]
+	t.stack = t.stack[:len(t.stack)-1]
+	if frame.traced {
+		t.traced--
+	}
+	return frame
+}
+
+func (t *Tracer) Statement(stmt Statement) {
+	position := StatementPosition(stmt)
+	if position.line == 0 || !t.Tracing() {
+		return
+	}
+
+	t.Print(position, len(t.stack), "%s", strings.TrimSpace(t.lines[position.line-1]))
+}
+
+func (t *Tracer) Call(function AspenFunction, arguments []interface{}, callSite Token) {
+	t.Push(t.Frame(function))
+
+	if t.Tracing() {
+		t.Print(callSite, len(t.stack)-1, "call %s(%s)", function.Name(), TraceArguments(arguments))
+	}
+}
+
+func (t *Tracer) TailCall(function AspenFunction, arguments []interface{}, callSite Token) {
+	tracing := t.Tracing()
+	t.Pop()
+	t.Push(t.Frame(function))
+
+	if tracing || t.Tracing() {
+		t.Print(callSite, len(t.stack)-1, "tail call %s(%s)", function.Name(), TraceArguments(arguments))
+	}
+}
+
+func (t *Tracer) Return(value interface{}) {
+	tracing := t.Tracing()
+	frame := t.Pop()
+
+	if !tracing {
+		return
+	}
+
+	if frame.void {
+		t.Print(Token{}, len(t.stack), "%s returned", frame.name)
+	} else {
+		t.Print(Token{}, len(t.stack), "%s returned %s", frame.name, TraceValue(value))
+	}
+}
+
+func (t *Tracer) Assign(name Token, old interface{}, value interface{}) {
+	if t.Tracing() {
+		t.Print(name, len(t.stack), "%s = %s (was %s)", name, TraceValue(value), TraceValue(old))
+	}
+}
+
+func (t *Tracer) Branch(stmt Statement, taken bool) {}
+
+// Observers notifies each of its observers in turn
+type Observers []Observer
+
+func (o Observers) Statement(stmt Statement) {
+	for _, observer := range o {
+		observer.Statement(stmt)
+	}
+}
+
+func (o Observers) Call(function AspenFunction, arguments []interface{}, callSite Token) {
+	for _, observer := range o {
+		observer.Call(function, arguments, callSite)
+	}
+}
+
+func (o Observers) TailCall(function AspenFunction, arguments []interface{}, callSite Token) {
+	for _, observer := range o {
+		observer.TailCall(function, arguments, callSite)
+	}
+}
+
+func (o Observers) Return(value interface{}) {
+	for _, observer := range o {
+		observer.Return(value)
+	}
+}
+
+func (o Observers) Assign(name Token, old interface{}, value interface{}) {
+	for _, observer := range o {
+		observer.Assign(name, old, value)
+	}
+}
+
+func (o Observers) Branch(stmt Statement, taken bool) {
+	for _, observer := range o {
+		observer.Branch(stmt, taken)
+	}
+}
+
+func (o Observers) Attach(interpreter *Interpreter, layout *ProgramLayout) {
+	for _, observer := range o {
+		if attached, ok := observer.(LayoutObserver); ok {
+			attached.Attach(interpreter, layout)
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestTracer(t *testing.T) {
+	Initialize()
+
+	source := `fn fib(n i64) i64 {
+    if (n < 2) {
+        return n;
+    }
+    return fib(n - 1) + fib(n - 2);
+}
+
+fn greet(name string) void {
+    let greeting = "hi";
+    greeting = greeting + " " + name;
+}
+
+let x = 1;
+x = fib(2);
+greet("bob");
+`
+
+	tests := []struct {
+		functions []string
+		expected  string
+	}{
+		{nil, `trace 1:4      fn fib(n i64) i64 {
+trace 8:4      fn greet(name string) void {
+trace 13:5     let x = 1;
+trace 14:1     x = fib(2);
+trace 14:10    call fib(2)
+trace 2:8        if (n < 2) {
+trace 5:5        return fib(n - 1) + fib(n - 2);
+trace 5:21       call fib(1)
+trace 2:8          if (n < 2) {
+trace 3:9          return n;
+trace            fib returned 1
+trace 5:34       call fib(0)
+trace 2:8          if (n < 2) {
+trace 3:9          return n;
+trace            fib returned 0
+trace          fib returned 1
+trace 14:1     x = 1 (was 1)
+trace 15:1     greet("bob");
+trace 15:12    call greet("bob")
+trace 9:9        let greeting = "hi";
+trace 10:5       greeting = greeting + " " + name;
+trace 10:5       greeting = "hi bob" (was "hi")
+trace          greet returned
+`},
+		{[]string{"greet"}, `trace 15:12    call greet("bob")
+trace 9:9        let greeting = "hi";
+trace 10:5       greeting = greeting + " " + name;
+trace 10:5       greeting = "hi bob" (was "hi")
+trace          greet returned
+`},
+	}
+
+	for _, test := range tests {
+		output := strings.Builder{}
+		ObserveSource(t, source, NewTracer(&output, []rune(source), test.functions))
+
+		if output.String() != test.expected {
+			t.Errorf("functions %v: expected the trace\n%s\ngot\n%s", test.functions, test.expected, output.String())
+		}
+	}
+}
+
+func TestTraceValue(t *testing.T) {
+	long := []rune(strings.Repeat("a", 100))
+	expected := `"` + strings.Repeat("a", MAX_TRACE_VALUE_LENGTH-1) + "..."
+
+	if value := TraceValue(long); value != expected {
+		t.Errorf("expected %s, got %s", expected, value)
+	}
+
+	if value := TraceValue(int64(42)); value != "42" {
+		t.Errorf("expected 42, got %s", value)
+	}
+}
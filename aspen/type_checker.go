@@ -199,13 +199,96 @@ func (s Scopes) Define(name string, fn *FunctionStatement) {
 	s[len(s)-1][name] = fn
 }
 
+// NarrowedType replaces the declared type of an optional variable in the environment while the variable is known not to be nil
+type NarrowedType struct {
+	declared *Type
+	narrowed *Type
+}
+
+type Narrowing struct {
+	environment Environment
+	name        string
+	previous    interface{}
+}
+
+// NonNilWhen returns the identifiers that cannot be nil when `condition` evaluates to `value`
+func NonNilWhen(condition Expression, value bool) []*IdentifierExpression {
+	switch expr := condition.(type) {
+	case *GroupingExpression:
+		return NonNilWhen(expr.expr, value)
+	case *UnaryExpression:
+		if expr.operator.tokenType == TOKEN_BANG {
+			return NonNilWhen(expr.operand, !value)
+		}
+	case *BinaryExpression:
+		switch expr.operator.tokenType {
+		case TOKEN_AMP_AMP:
+			if value {
+				return append(NonNilWhen(expr.left, true), NonNilWhen(expr.right, true)...)
+			}
+		case TOKEN_PIPE_PIPE:
+			if !value {
+				return append(NonNilWhen(expr.left, false), NonNilWhen(expr.right, false)...)
+			}
+		case TOKEN_EQUAL_EQUAL, TOKEN_BANG_EQUAL:
+			// `x != nil` is true or `x == nil` is false
+			if (expr.operator.tokenType == TOKEN_BANG_EQUAL) != value {
+				return nil
+			}
+
+			identifier, ok := expr.left.(*IdentifierExpression)
+			other := expr.right
+			if !ok {
+				identifier, ok = expr.right.(*IdentifierExpression)
+				other = expr.left
+			}
+
+			if literal, isLiteral := other.(*LiteralExpression); ok && isLiteral && literal.value.tokenType == TOKEN_NIL {
+				return []*IdentifierExpression{identifier}
+			}
+		}
+	}
+
+	return nil
+}
+
+// Terminates reports whether control never flows past `stmt`
+func Terminates(stmt Statement) bool {
+	switch stmt := stmt.(type) {
+	case *ReturnStatement, *BreakStatement, *ContinueStatement:
+		return true
+	case *BlockStatement:
+		for _, statement := range stmt.statements {
+			if Terminates(statement) {
+				return true
+			}
+		}
+	case *IfStatement:
+		return stmt.elseBranch != nil && Terminates(stmt.thenBranch) && Terminates(stmt.elseBranch)
+	}
+
+	return false
+}
+
 type TypeChecker struct {
 	environment     Environment
 	errorReporter   ErrorReporter
 	currentFunction *FunctionStatement
 
+	// the loops enclosing the current statement within the current function, innermost last
+	loops []*WhileStatement
+
 	referenceGraph *ReferenceGraph
 	scopes         Scopes
+
+	// maps the name of a struct to its type
+	structs map[string]*Type
+
+	// the optional variables currently known not to be nil, innermost last
+	narrowings []Narrowing
+
+	// the type of each expression, nil unless the types are recorded for the linter
+	types map[Expression]*Type
 }
 
 func (tc *TypeChecker) FatalError(token Token, message string) {
@@ -216,8 +299,88 @@ func (tc *TypeChecker) Error(token Token, message string) {
 	tc.errorReporter.Push(token.line, token.col, message)
 }
 
+// ReportedError aborts type checking the current statement without reporting an error, because the
+// cause was already reported
+type ReportedError struct{}
+
+// ResolveType replaces references to structs in `atype` with their declarations, reporting an error at `loc`
+// and returning false if a struct is not declared
+func (tc *TypeChecker) ResolveType(atype *Type, loc Token) bool {
+	switch atype.kind {
+	case TYPE_SLICE:
+		return tc.ResolveType(atype.other.(SliceType).of, loc)
+	case TYPE_OPTIONAL:
+		return tc.ResolveType(atype.other.(OptionalType).of, loc)
+	case TYPE_MAP:
+		other := atype.other.(MapType)
+		if !other.key.kind.IsHashable() {
+			tc.Error(loc, fmt.Sprintf("invalid map key type %v.", other.key))
+			return false
+		}
+		return tc.ResolveType(other.value, loc)
+	case TYPE_TUPLE:
+		ok := true
+		for _, element := range atype.other.(TupleType).elements {
+			if element.IsVoid() {
+				tc.Error(loc, fmt.Sprintf("invalid tuple element type %v.", element))
+				ok = false
+				continue
+			}
+			ok = tc.ResolveType(element, loc) && ok
+		}
+		return ok
+	case TYPE_FUNCTION:
+		return tc.ResolveFunctionType(atype.other.(FunctionType), loc)
+	case TYPE_STRUCT:
+		declaration, ok := tc.structs[atype.other.(*StructType).name]
+		if !ok {
+			tc.Error(loc, fmt.Sprintf("undefined type '%v'.", atype))
+			return false
+		}
+		atype.other = declaration.other
+	}
+	return true
+}
+
+func (tc *TypeChecker) ResolveFunctionType(atype FunctionType, loc Token) bool {
+	ok := true
+	for _, parameter := range atype.parameters {
+		ok = tc.ResolveType(parameter, loc) && ok
+	}
+	return tc.ResolveType(atype.returnType, loc) && ok
+}
+
+// Narrow gives the optional variables referred to by `identifiers` their non-optional type, until RestoreNarrowings is called
+func (tc *TypeChecker) Narrow(identifiers []*IdentifierExpression) {
+	for _, identifier := range identifiers {
+		name := identifier.name.String()
+		environment := tc.environment.Ancestor(identifier.depth)
+
+		atype, ok := environment.values[name].(*Type)
+		if !ok || !atype.IsOptional() {
+			continue
+		}
+
+		tc.narrowings = append(tc.narrowings, Narrowing{environment: environment, name: name, previous: atype})
+		environment.Define(name, &NarrowedType{declared: atype, narrowed: atype.other.(OptionalType).of})
+	}
+}
+
+// RestoreNarrowings undoes the narrowings made since there were `mark` of them
+func (tc *TypeChecker) RestoreNarrowings(mark int) {
+	for i := len(tc.narrowings) - 1; i >= mark; i-- {
+		narrowing := tc.narrowings[i]
+		narrowing.environment.Define(narrowing.name, narrowing.previous)
+	}
+	tc.narrowings = tc.narrowings[:mark]
+}
+
 func (tc *TypeChecker) VisitExpressionNode(expr Expression) interface{} {
-	return expr.Accept(tc)
+	result := expr.Accept(tc)
+	if atype, ok := result.(*Type); ok && tc.types != nil {
+		tc.types[expr] = atype
+	}
+	return result
 }
 
 func (tc *TypeChecker) VisitStatementNode(stmt Statement) interface{} {
@@ -227,6 +390,7 @@ func (tc *TypeChecker) VisitStatementNode(stmt Statement) interface{} {
 			case ErrorData:
 				// recover from any calls to panic with an argument of type `ErrorData` and push the error to the reporter
 				tc.errorReporter.Push(v.line, v.col, v.message)
+			case ReportedError:
 			default:
 				// else re-panic
 				panic(v)
@@ -240,7 +404,20 @@ func (tc *TypeChecker) VisitStatementNode(stmt Statement) interface{} {
 
 func (tc *TypeChecker) VisitBinary(expr *BinaryExpression) interface{} {
 	leftType := tc.VisitExpressionNode(expr.left).(*Type)
-	rightType := tc.VisitExpressionNode(expr.right).(*Type)
+
+	// the right operand of && and || is only evaluated depending on the value of the left operand
+	mark := len(tc.narrowings)
+	switch expr.operator.tokenType {
+	case TOKEN_AMP_AMP:
+		tc.Narrow(NonNilWhen(expr.left, true))
+	case TOKEN_PIPE_PIPE:
+		tc.Narrow(NonNilWhen(expr.left, false))
+	}
+
+	rightType := func() *Type {
+		defer tc.RestoreNarrowings(mark)
+		return tc.VisitExpressionNode(expr.right).(*Type)
+	}()
 
 	check := func(condition bool) {
 		if !condition {
@@ -261,7 +438,7 @@ func (tc *TypeChecker) VisitBinary(expr *BinaryExpression) interface{} {
 		check(leftType.kind == TYPE_BOOL && rightType.kind == TYPE_BOOL)
 		return SimpleType(TYPE_BOOL)
 	case TOKEN_EQUAL_EQUAL, TOKEN_BANG_EQUAL:
-		check(TypesEqual(leftType, rightType))
+		check(IsAssignable(leftType, rightType) || IsAssignable(rightType, leftType))
 		return SimpleType(TYPE_BOOL)
 	case TOKEN_GREATER, TOKEN_GREATER_EQUAL, TOKEN_LESS, TOKEN_LESS_EQUAL:
 		check(bothNumeric())
@@ -313,6 +490,8 @@ func (tc *TypeChecker) VisitLiteral(expr *LiteralExpression) interface{} {
 		return SimpleType(TYPE_DOUBLE)
 	case TOKEN_STRING_LITERAL:
 		return SimpleType(TYPE_STRING)
+	case TOKEN_NIL:
+		return SimpleType(TYPE_NIL)
 	}
 
 	Unreachable("TypeChecker::VisitLiteral")
@@ -329,6 +508,21 @@ func (tc *TypeChecker) VisitIdentifier(expr *IdentifierExpression) interface{} {
 	expr.depth = tc.environment.GetDepth(name)
 	atype := tc.environment.GetAt(name, expr.depth)
 
+	if atype == nil {
+		// the type of the variable could not be inferred
+		panic(ReportedError{})
+	}
+
+	if _, ok := atype.(*BuiltinFunction); ok {
+		tc.FatalError(expr.name, fmt.Sprintf("builtin function '%s' must be called.", name))
+	}
+
+	if narrowed, ok := atype.(*NarrowedType); ok {
+		// the interpreter checks that the variable is still not nil when it is read
+		expr.narrowed = true
+		atype = narrowed.narrowed
+	}
+
 	if atype.(*Type).kind == TYPE_FUNCTION {
 		if fn := tc.scopes.GetAt(name, expr.depth); fn != nil {
 			if tc.currentFunction == nil {
@@ -365,17 +559,66 @@ func (tc *TypeChecker) VisitAssignment(expr *AssignmentExpression) interface{} {
 
 	expr.depth = tc.environment.GetDepth(name)
 
-	identifierType := tc.environment.GetAt(name, expr.depth).(*Type)
+	if _, ok := tc.environment.GetAt(name, expr.depth).(*BuiltinFunction); ok {
+		tc.FatalError(expr.name, fmt.Sprintf("cannot assign to builtin function '%s'.", name))
+	}
+
 	valueType := tc.VisitExpressionNode(expr.value).(*Type)
 
-	if !TypesEqual(identifierType, valueType) {
+	var identifierType *Type
+	switch atype := tc.environment.GetAt(name, expr.depth).(type) {
+	case nil:
+		// the type of the variable could not be inferred
+		panic(ReportedError{})
+	case *NarrowedType:
+		identifierType = atype.declared
+		if !TypesEqual(atype.narrowed, valueType) {
+			// the variable may be nil from now on
+			tc.environment.Ancestor(expr.depth).Define(name, atype.declared)
+		}
+	case *Type:
+		identifierType = atype
+	}
+
+	if !IsAssignable(identifierType, valueType) {
 		tc.FatalError(expr.name, fmt.Sprintf("cannot assign expression of type %v to '%s', which has type %v.", valueType, name, identifierType))
 	}
 
 	return identifierType
 }
 
+// GetBuiltin returns the builtin function that `callee` refers to, or nil if `callee` is not a builtin
+func (tc *TypeChecker) GetBuiltin(callee Expression) *BuiltinFunction {
+	identifier, ok := callee.(*IdentifierExpression)
+	if !ok {
+		return nil
+	}
+
+	name := identifier.name.String()
+	if !tc.environment.IsDefined(name) {
+		return nil
+	}
+
+	depth := tc.environment.GetDepth(name)
+	builtin, ok := tc.environment.GetAt(name, depth).(*BuiltinFunction)
+	if !ok {
+		return nil
+	}
+
+	identifier.depth = depth
+	return builtin
+}
+
 func (tc *TypeChecker) VisitCall(expr *CallExpression) interface{} {
+	if builtin := tc.GetBuiltin(expr.callee); builtin != nil {
+		arguments := make([]*Type, len(expr.arguments))
+		for i := range expr.arguments {
+			arguments[i] = tc.VisitExpressionNode(expr.arguments[i]).(*Type)
+		}
+
+		return builtin.check(tc, expr, arguments)
+	}
+
 	callee := tc.VisitExpressionNode(expr.callee).(*Type)
 
 	if callee.kind != TYPE_FUNCTION {
@@ -395,7 +638,7 @@ func (tc *TypeChecker) VisitCall(expr *CallExpression) interface{} {
 
 	for i := range expr.arguments {
 		arg := tc.VisitExpressionNode(expr.arguments[i]).(*Type)
-		if !TypesEqual(arg, other.parameters[i]) {
+		if !IsAssignable(other.parameters[i], arg) {
 			tc.Error(expr.loc,
 				fmt.Sprintf("cannot use argument of type %v as the %s parameter to function call (expected %v).",
 					arg,
@@ -418,6 +661,228 @@ func (tc *TypeChecker) VisitTypeCast(expr *TypeCastExpression) interface{} {
 	return expr.to
 }
 
+// CheckSubscript type checks `expr` and returns the type of the indexed object and of its elements
+func (tc *TypeChecker) CheckSubscript(expr *SubscriptExpression) (*Type, *Type) {
+	object := tc.VisitExpressionNode(expr.object).(*Type)
+	index := tc.VisitExpressionNode(expr.index).(*Type)
+
+	switch object.kind {
+	case TYPE_SLICE:
+		if !index.kind.IsIntegral() {
+			tc.FatalError(expr.loc, fmt.Sprintf("slice index must be an integer, got %v.", index))
+		}
+		return object, object.other.(SliceType).of
+	case TYPE_MAP:
+		other := object.other.(MapType)
+		if !IsAssignable(other.key, index) {
+			tc.FatalError(expr.loc, fmt.Sprintf("cannot use expression of type %v as key of %v.", index, object))
+		}
+		return object, other.value
+	}
+
+	tc.FatalError(expr.loc, fmt.Sprintf("cannot index expression of type %v.", object))
+	return nil, nil
+}
+
+func (tc *TypeChecker) VisitSubscript(expr *SubscriptExpression) interface{} {
+	_, elementType := tc.CheckSubscript(expr)
+	return elementType
+}
+
+func (tc *TypeChecker) VisitSubscriptAssignment(expr *SubscriptAssignmentExpression) interface{} {
+	object, elementType := tc.CheckSubscript(expr.target)
+	valueType := tc.VisitExpressionNode(expr.value).(*Type)
+
+	if !IsAssignable(elementType, valueType) {
+		element := "slice element"
+		if object.kind == TYPE_MAP {
+			element = "map value"
+		}
+		tc.FatalError(expr.target.loc, fmt.Sprintf("cannot assign expression of type %v to %s of type %v.", valueType, element, elementType))
+	}
+
+	return elementType
+}
+
+func (tc *TypeChecker) VisitSliceLiteral(expr *SliceLiteralExpression) interface{} {
+	tc.ResolveType(expr.atype, expr.loc)
+	elementType := expr.atype.other.(SliceType).of
+
+	for i := range expr.elements {
+		atype := tc.VisitExpressionNode(expr.elements[i]).(*Type)
+		if !IsAssignable(elementType, atype) {
+			tc.Error(expr.loc,
+				fmt.Sprintf("cannot use expression of type %v as the %s element of %v literal.",
+					atype,
+					OrdinalSuffixOf(i+1),
+					expr.atype))
+		}
+	}
+
+	return expr.atype
+}
+
+func (tc *TypeChecker) VisitMapLiteral(expr *MapLiteralExpression) interface{} {
+	tc.ResolveType(expr.atype, expr.loc)
+	other := expr.atype.other.(MapType)
+
+	for i := range expr.keys {
+		key := tc.VisitExpressionNode(expr.keys[i]).(*Type)
+		if !IsAssignable(other.key, key) {
+			tc.Error(expr.loc,
+				fmt.Sprintf("cannot use expression of type %v as the %s key of %v literal.",
+					key,
+					OrdinalSuffixOf(i+1),
+					expr.atype))
+		}
+
+		value := tc.VisitExpressionNode(expr.values[i]).(*Type)
+		if !IsAssignable(other.value, value) {
+			tc.Error(expr.loc,
+				fmt.Sprintf("cannot use expression of type %v as the %s value of %v literal.",
+					value,
+					OrdinalSuffixOf(i+1),
+					expr.atype))
+		}
+	}
+
+	return expr.atype
+}
+
+func (tc *TypeChecker) VisitTuple(expr *TupleExpression) interface{} {
+	elements := make([]*Type, len(expr.elements))
+
+	for i, element := range expr.elements {
+		elements[i] = tc.VisitExpressionNode(element).(*Type)
+		if elements[i].IsVoid() {
+			tc.FatalError(expr.loc, fmt.Sprintf("cannot use expression of type void as the %s element of a tuple.", OrdinalSuffixOf(i+1)))
+		}
+	}
+
+	return &Type{kind: TYPE_TUPLE, other: TupleType{elements: elements}}
+}
+
+func (tc *TypeChecker) VisitField(expr *FieldExpression) interface{} {
+	object := tc.VisitExpressionNode(expr.object).(*Type)
+	name := expr.name.String()
+
+	if object.kind != TYPE_STRUCT {
+		tc.FatalError(expr.name, fmt.Sprintf("cannot access field '%s' of expression of type %v.", name, object))
+	}
+
+	other := object.other.(*StructType)
+
+	if i := other.FieldIndex(name); i >= 0 {
+		return other.fields[i].atype
+	}
+
+	if method, ok := other.methods[name]; ok {
+		if tc.currentFunction == nil {
+			// make sure the method does not reference an undefined function
+			if err, chain := tc.referenceGraph.ReferencesUndefinedNode(method); err {
+				var location Token
+				if len(chain) > 1 {
+					location = *chain[0]
+				} else {
+					location = expr.name
+				}
+				tc.Error(location, UnresolvedErrorMessage(chain, &expr.name))
+			}
+		} else {
+			tc.referenceGraph.AddEdge(tc.currentFunction, method, &expr.name)
+		}
+
+		return &Type{kind: TYPE_FUNCTION, other: method.atype}
+	}
+
+	tc.FatalError(expr.name, fmt.Sprintf("%v has no field or method '%s'.", object, name))
+	return nil
+}
+
+func (tc *TypeChecker) VisitFieldAssignment(expr *FieldAssignmentExpression) interface{} {
+	object := tc.VisitExpressionNode(expr.target.object).(*Type)
+	name := expr.target.name.String()
+
+	if object.kind == TYPE_STRUCT {
+		if _, ok := object.other.(*StructType).methods[name]; ok {
+			tc.FatalError(expr.target.name, fmt.Sprintf("cannot assign to method '%s'.", name))
+		}
+	}
+
+	fieldType := tc.VisitExpressionNode(expr.target).(*Type)
+	valueType := tc.VisitExpressionNode(expr.value).(*Type)
+
+	if !IsAssignable(fieldType, valueType) {
+		tc.FatalError(expr.target.name, fmt.Sprintf("cannot assign expression of type %v to field '%s', which has type %v.", valueType, name, fieldType))
+	}
+
+	return fieldType
+}
+
+func (tc *TypeChecker) VisitStructLiteral(expr *StructLiteralExpression) interface{} {
+	if !tc.ResolveType(expr.atype, expr.loc) {
+		return expr.atype
+	}
+
+	if expr.atype.kind != TYPE_STRUCT {
+		tc.FatalError(expr.loc, fmt.Sprintf("%v is not a struct.", expr.atype))
+	}
+
+	other := expr.atype.other.(*StructType)
+	initialized := make(map[string]bool)
+
+	for i, field := range expr.fields {
+		name := field.String()
+		valueType := tc.VisitExpressionNode(expr.values[i]).(*Type)
+
+		j := other.FieldIndex(name)
+		if j < 0 {
+			tc.Error(field, fmt.Sprintf("%v has no field '%s'.", expr.atype, name))
+			continue
+		}
+
+		if initialized[name] {
+			tc.Error(field, fmt.Sprintf("duplicate field '%s' in struct literal.", name))
+		}
+		initialized[name] = true
+
+		if !IsAssignable(other.fields[j].atype, valueType) {
+			tc.Error(field, fmt.Sprintf("cannot use expression of type %v as field '%s', which has type %v.", valueType, name, other.fields[j].atype))
+		}
+	}
+
+	for _, field := range other.fields {
+		// optional fields default to nil
+		if !initialized[field.name] && !field.atype.IsOptional() {
+			tc.Error(expr.loc, fmt.Sprintf("missing field '%s' in %v literal.", field.name, expr.atype))
+		}
+	}
+
+	return expr.atype
+}
+
+func (tc *TypeChecker) VisitFunctionLiteral(expr *FunctionLiteralExpression) interface{} {
+	fn := expr.function
+	tc.ResolveFunctionType(fn.atype, fn.name)
+
+	// the function literal is a node of its own, referenced by the function that creates it
+	tc.referenceGraph.AddNode(fn)
+	if tc.currentFunction != nil {
+		tc.referenceGraph.AddEdge(tc.currentFunction, fn, &fn.name)
+	}
+
+	tc.CheckFunction(fn, tc.environment)
+
+	if tc.currentFunction == nil {
+		// a function literal in top level code may be called right away, so it must not reference undefined functions
+		if err, chain := tc.referenceGraph.ReferencesUndefinedNode(fn); err {
+			tc.Error(*chain[0], UnresolvedErrorMessage(chain, &fn.name))
+		}
+	}
+
+	return &Type{kind: TYPE_FUNCTION, other: fn.atype}
+}
+
 func (tc *TypeChecker) VisitExpression(stmt *ExpressionStatement) interface{} {
 	tc.VisitExpressionNode(stmt.expr)
 	return nil
@@ -431,14 +896,101 @@ func (tc *TypeChecker) VisitPrint(stmt *PrintStatement) interface{} {
 	return nil
 }
 
+// CheckDestructuringLet checks `let (a, b) = expr;`, the initializer must be a tuple with one element per name
+func (tc *TypeChecker) CheckDestructuringLet(stmt *LetStatement) {
+	defer func() {
+		if r := recover(); r != nil {
+			// define the variables without a type, so that their uses do not report more errors
+			for _, name := range stmt.names {
+				if !tc.environment.IsDefinedLocally(name.String()) {
+					tc.environment.Define(name.String(), nil)
+				}
+			}
+			panic(r)
+		}
+	}()
+
+	for i, name := range stmt.names {
+		if tc.environment.IsDefinedLocally(name.String()) {
+			tc.FatalError(name, fmt.Sprintf("cannot redefine '%s'.", name.String()))
+		}
+		for _, other := range stmt.names[:i] {
+			if other.String() == name.String() {
+				tc.FatalError(name, fmt.Sprintf("'%s' is declared more than once.", name.String()))
+			}
+		}
+	}
+
+	if !stmt.inferred && !tc.ResolveType(stmt.atype, stmt.name) {
+		panic(ReportedError{})
+	}
+
+	atype := tc.VisitExpressionNode(stmt.initializer).(*Type)
+
+	if stmt.inferred {
+		stmt.atype = atype
+	} else if !IsAssignable(stmt.atype, atype) {
+		tc.FatalError(stmt.name, fmt.Sprintf("cannot assign expression of type %v to a tuple of type %v.", atype, stmt.atype))
+	}
+
+	if stmt.atype.kind != TYPE_TUPLE {
+		tc.FatalError(stmt.name, fmt.Sprintf("cannot destructure an expression of type %v.", stmt.atype))
+	}
+
+	elements := stmt.atype.other.(TupleType).elements
+	if len(elements) != len(stmt.names) {
+		tc.FatalError(stmt.name, fmt.Sprintf("cannot destructure a tuple of type %v into %d variables.", stmt.atype, len(stmt.names)))
+	}
+
+	for i, name := range stmt.names {
+		if elements[i].kind == TYPE_NIL {
+			tc.FatalError(name, fmt.Sprintf("cannot infer the type of '%s' from an expression of type %v.", name.String(), elements[i]))
+		}
+	}
+
+	for i, name := range stmt.names {
+		tc.environment.Define(name.String(), elements[i])
+	}
+}
+
 func (tc *TypeChecker) VisitLet(stmt *LetStatement) interface{} {
+	if stmt.names != nil {
+		tc.CheckDestructuringLet(stmt)
+		return nil
+	}
+
 	name := stmt.name.String()
 	if tc.environment.IsDefinedLocally(name) {
 		tc.FatalError(stmt.name, fmt.Sprintf("cannot redefine '%s'.", name))
 	}
 
-	// Slice and function types must be initialized
-	if stmt.initializer == nil && (stmt.atype.kind == TYPE_SLICE || stmt.atype.kind == TYPE_FUNCTION) {
+	if stmt.inferred {
+		defer func() {
+			if r := recover(); r != nil {
+				// define the variable without a type, so that its uses do not report more errors
+				tc.environment.Define(name, nil)
+				panic(r)
+			}
+		}()
+
+		atype := tc.VisitExpressionNode(stmt.initializer).(*Type)
+		if atype.IsVoid() || atype.kind == TYPE_NIL {
+			tc.FatalError(stmt.name, fmt.Sprintf("cannot infer the type of '%s' from an expression of type %v.", name, atype))
+		}
+
+		stmt.atype = atype
+		tc.environment.Define(name, stmt.atype)
+		return nil
+	}
+
+	if !tc.ResolveType(stmt.atype, stmt.name) {
+		// define the variable anyway to avoid reporting undeclared identifiers later on
+		tc.environment.Define(name, stmt.atype)
+		return nil
+	}
+
+	// Function, struct and tuple types must be initialized
+	if stmt.initializer == nil && (stmt.atype.kind == TYPE_FUNCTION || stmt.atype.kind == TYPE_STRUCT || stmt.atype.kind == TYPE_TUPLE) {
 		tc.FatalError(stmt.name, fmt.Sprintf("'%s' must be initialized.", stmt.name.value))
 	}
 
@@ -459,13 +1011,19 @@ func (tc *TypeChecker) VisitLet(stmt *LetStatement) interface{} {
 			stmt.initializer = &LiteralExpression{value: Token{tokenType: TOKEN_STRING_LITERAL, value: []rune("")}}
 		case TYPE_DOUBLE:
 			stmt.initializer = &LiteralExpression{value: Token{tokenType: TOKEN_FLOAT_LITERAL, value: float64(0)}}
+		case TYPE_SLICE:
+			stmt.initializer = &SliceLiteralExpression{atype: stmt.atype, elements: []Expression{}}
+		case TYPE_MAP:
+			stmt.initializer = &MapLiteralExpression{atype: stmt.atype, keys: []Expression{}, values: []Expression{}}
+		case TYPE_OPTIONAL:
+			stmt.initializer = &LiteralExpression{value: Token{tokenType: TOKEN_NIL}}
 		default:
 			Unreachable("TypeChecker::VisitLet")
 		}
 	} else {
 		// Type check the initializer
 		atype := tc.VisitExpressionNode(stmt.initializer).(*Type)
-		if !TypesEqual(stmt.atype, atype) {
+		if !IsAssignable(stmt.atype, atype) {
 			tc.FatalError(stmt.name, fmt.Sprintf("cannot assign expression of type %v to '%s', which has type %v.", atype, stmt.name.value, stmt.atype))
 		}
 	}
@@ -480,11 +1038,13 @@ func (tc *TypeChecker) CheckBlock(stmt *BlockStatement, environment Environment)
 	tc.environment = environment
 
 	tc.scopes = append(tc.scopes, make(map[string]*FunctionStatement))
+	mark := len(tc.narrowings)
 
 	for _, stmt := range stmt.statements {
 		tc.VisitStatementNode(stmt)
 	}
 
+	tc.RestoreNarrowings(mark)
 	tc.scopes = tc.scopes[:len(tc.scopes)-1]
 
 	tc.environment = enclosing
@@ -503,10 +1063,23 @@ func (tc *TypeChecker) VisitIf(stmt *IfStatement) interface{} {
 		tc.Error(stmt.loc, "expected an expression of type bool.")
 	}
 
-	// visit the then and else block
+	// visit the then and else block, narrowing the optionals the condition checks against nil
+	mark := len(tc.narrowings)
+	tc.Narrow(NonNilWhen(stmt.condition, true))
 	tc.VisitStatementNode(stmt.thenBranch)
+	tc.RestoreNarrowings(mark)
+
 	if stmt.elseBranch != nil {
+		tc.Narrow(NonNilWhen(stmt.condition, false))
 		tc.VisitStatementNode(stmt.elseBranch)
+		tc.RestoreNarrowings(mark)
+	}
+
+	// if a branch never completes, the rest of the block is only reached through the other branch
+	if Terminates(stmt.thenBranch) {
+		tc.Narrow(NonNilWhen(stmt.condition, false))
+	} else if stmt.elseBranch != nil && Terminates(stmt.elseBranch) {
+		tc.Narrow(NonNilWhen(stmt.condition, true))
 	}
 
 	return nil
@@ -519,7 +1092,58 @@ func (tc *TypeChecker) VisitWhile(stmt *WhileStatement) interface{} {
 		tc.Error(stmt.loc, "expected an expression of type bool.")
 	}
 
+	if stmt.label != nil {
+		for _, loop := range tc.loops {
+			if loop.label != nil && loop.label.String() == stmt.label.String() {
+				tc.Error(*stmt.label, fmt.Sprintf("label '%v' is already defined.", stmt.label))
+				break
+			}
+		}
+	}
+
+	mark := len(tc.narrowings)
+	tc.Narrow(NonNilWhen(stmt.condition, true))
+
+	tc.loops = append(tc.loops, stmt)
 	tc.VisitStatementNode(stmt.body)
+	tc.loops = tc.loops[:len(tc.loops)-1]
+
+	tc.RestoreNarrowings(mark)
+
+	if stmt.increment != nil {
+		tc.VisitExpressionNode(stmt.increment)
+	}
+
+	return nil
+}
+
+// ResolveLoop finds the loop targeted by a break or continue statement
+func (tc *TypeChecker) ResolveLoop(label *Token, loc Token) *WhileStatement {
+	if len(tc.loops) == 0 {
+		tc.FatalError(loc, fmt.Sprintf("%v statement not within a loop.", loc))
+	}
+
+	if label == nil {
+		return tc.loops[len(tc.loops)-1]
+	}
+
+	for i := len(tc.loops) - 1; i >= 0; i-- {
+		if tc.loops[i].label != nil && tc.loops[i].label.String() == label.String() {
+			return tc.loops[i]
+		}
+	}
+
+	tc.FatalError(*label, fmt.Sprintf("undefined loop label '%v'.", label))
+	return nil
+}
+
+func (tc *TypeChecker) VisitBreak(stmt *BreakStatement) interface{} {
+	stmt.target = tc.ResolveLoop(stmt.label, stmt.loc)
+	return nil
+}
+
+func (tc *TypeChecker) VisitContinue(stmt *ContinueStatement) interface{} {
+	stmt.target = tc.ResolveLoop(stmt.label, stmt.loc)
 	return nil
 }
 
@@ -537,6 +1161,7 @@ func (tc *TypeChecker) VisitFunction(stmt *FunctionStatement) interface{} {
 
 	if tc.environment.enclosing != nil {
 		// skip defining global functions, they were defined in the first pass
+		tc.ResolveFunctionType(stmt.atype, stmt.name)
 		if !tc.DefineFunction(name, stmt.atype) {
 			tc.Error(stmt.name, fmt.Sprintf("cannot redefine '%s'.", name))
 		}
@@ -548,7 +1173,12 @@ func (tc *TypeChecker) VisitFunction(stmt *FunctionStatement) interface{} {
 
 	tc.scopes.Define(name, stmt)
 
-	enclosing := tc.environment
+	tc.CheckFunction(stmt, tc.environment)
+	return nil
+}
+
+// CheckFunction type checks the body of a function declared in `enclosing`
+func (tc *TypeChecker) CheckFunction(stmt *FunctionStatement, enclosing Environment) {
 	environment := NewEnvironment(&enclosing)
 
 	for i := range stmt.parameters {
@@ -567,13 +1197,71 @@ func (tc *TypeChecker) VisitFunction(stmt *FunctionStatement) interface{} {
 		}
 	}
 
-	enclosingFn := tc.currentFunction
-	tc.currentFunction = stmt
+	// loops do not extend across function boundaries
+	enclosingFn, enclosingLoops := tc.currentFunction, tc.loops
+	tc.currentFunction, tc.loops = stmt, nil
 	tc.CheckBlock(stmt.body, environment)
-	tc.currentFunction = enclosingFn
+	tc.currentFunction, tc.loops = enclosingFn, enclosingLoops
+}
+
+func (tc *TypeChecker) VisitStruct(stmt *StructStatement) interface{} {
+	if tc.environment.enclosing != nil {
+		tc.FatalError(stmt.name, "structs can only be declared at the top level.")
+	}
+
+	if tc.structs[stmt.name.String()] != stmt.atype {
+		// the struct is a redefinition, which was reported in the first pass
+		return nil
+	}
+
+	other := stmt.atype.other.(*StructType)
+
+	// methods are declared in an environment where `self` refers to the receiver
+	enclosing := tc.environment
+	environment := NewEnvironment(&enclosing)
+	environment.Define("self", stmt.atype)
+
+	tc.scopes = append(tc.scopes, make(map[string]*FunctionStatement))
+
+	for _, method := range stmt.methods {
+		if other.methods[method.name.String()] != method {
+			// the method is a redefinition, which was reported in the first pass
+			continue
+		}
+
+		tc.referenceGraph.MarkNodeAsDefined(method)
+		tc.CheckFunction(method, environment)
+	}
+
+	tc.scopes = tc.scopes[:len(tc.scopes)-1]
+
 	return nil
 }
 
+// DeclareStruct checks the fields and method signatures of a top level struct declaration
+func (tc *TypeChecker) DeclareStruct(stmt *StructStatement) {
+	other := stmt.atype.other.(*StructType)
+
+	for i, field := range other.fields {
+		if other.FieldIndex(field.name) != i {
+			tc.Error(stmt.fields[i], fmt.Sprintf("duplicate field '%s'.", field.name))
+		}
+		tc.ResolveType(field.atype, stmt.fields[i])
+	}
+
+	for _, method := range stmt.methods {
+		name := method.name.String()
+		if _, ok := other.methods[name]; ok || other.FieldIndex(name) >= 0 {
+			tc.Error(method.name, fmt.Sprintf("cannot redefine '%s'.", name))
+			continue
+		}
+
+		tc.ResolveFunctionType(method.atype, method.name)
+		other.methods[name] = method
+		tc.referenceGraph.AddUndefinedNode(method)
+	}
+}
+
 func (tc *TypeChecker) VisitReturn(stmt *ReturnStatement) interface{} {
 	if tc.currentFunction == nil {
 		tc.FatalError(stmt.loc, "cannot return from top level code.")
@@ -587,13 +1275,20 @@ func (tc *TypeChecker) VisitReturn(stmt *ReturnStatement) interface{} {
 
 		returnType := tc.currentFunction.atype.returnType
 
-		if returnType.IsVoid() && !value.IsVoid() {
+		if count, expected := TupleArity(value), TupleArity(returnType); !returnType.IsVoid() && !value.IsVoid() && count != expected {
+			tc.Error(stmt.loc, fmt.Sprintf("wrong number of return values (%d expected, got %d).", expected, count))
+		} else if returnType.IsVoid() && !value.IsVoid() {
 			tc.Error(stmt.loc, "no return values expected.")
 		} else if !returnType.IsVoid() && value.IsVoid() {
 			tc.Error(stmt.loc, fmt.Sprintf("function must return an expression of type %v.", returnType))
-		} else if !TypesEqual(value, returnType) {
+		} else if !IsAssignable(returnType, value) {
 			tc.Error(stmt.loc, fmt.Sprintf("cannot return an expression of type %v (%v expected).", value, returnType))
 		}
+
+		// the function returns the value of the call right away, so the call can reuse its frame
+		if call, ok := stmt.value.(*CallExpression); ok && tc.GetBuiltin(call.callee) == nil {
+			call.tail = true
+		}
 	}
 	return nil
 }
@@ -603,6 +1298,7 @@ func NewTypeChecker(errorReporter ErrorReporter) *TypeChecker {
 		errorReporter:  errorReporter,
 		scopes:         make(Scopes, 1),
 		referenceGraph: NewReferenceGraph(),
+		structs:        make(map[string]*Type),
 	}
 
 	typeChecker.scopes[0] = make(map[string]*FunctionStatement)
@@ -610,34 +1306,70 @@ func NewTypeChecker(errorReporter ErrorReporter) *TypeChecker {
 	return &typeChecker
 }
 
-func TypeCheck(ast Program, errorReporter ErrorReporter) (err error) {
-	typeChecker := NewTypeChecker(errorReporter)
+func TypeCheck(ast Program, errorReporter ErrorReporter) error {
+	return NewTypeChecker(errorReporter).Check(ast)
+}
+
+// Check type checks a whole program
+func (tc *TypeChecker) Check(ast Program) error {
+	tc.DefineBuiltins()
+	tc.DeclareGlobals(ast)
+
+	for _, stmt := range ast {
+		tc.VisitStatementNode(stmt)
+	}
+
+	if tc.errorReporter.HadError() {
+		return tc.errorReporter
+	}
+
+	return nil
+}
 
+// DefineBuiltins defines the native and builtin functions
+func (tc *TypeChecker) DefineBuiltins() {
 	// define native functions
 	for name, fn := range NativeFunctions {
-		typeChecker.DefineFunction(name, fn.atype)
+		tc.DefineFunction(name, fn.atype)
 	}
 
-	// define global functions
+	// define builtin functions
+	for name, fn := range BuiltinFunctions {
+		tc.environment.Define(name, fn)
+	}
+}
+
+// DeclareGlobals declares the structs and the functions of a program, which can be used before their declarations
+func (tc *TypeChecker) DeclareGlobals(ast Program) {
+	// declare structs
 	for _, stmt := range ast {
-		fn, ok := stmt.(*FunctionStatement)
-		if ok {
-			name := fn.name.String()
-			if !typeChecker.DefineFunction(name, fn.atype) {
-				typeChecker.Error(fn.name, fmt.Sprintf("cannot redefine '%s'.", name))
+		if st, ok := stmt.(*StructStatement); ok {
+			name := st.name.String()
+			if _, ok := tc.structs[name]; ok {
+				tc.Error(st.name, fmt.Sprintf("cannot redefine '%s'.", name))
+				continue
 			}
-			typeChecker.referenceGraph.AddUndefinedNode(fn)
-			typeChecker.scopes.Define(name, fn)
+			tc.structs[name] = st.atype
 		}
 	}
 
 	for _, stmt := range ast {
-		typeChecker.VisitStatementNode(stmt)
+		if st, ok := stmt.(*StructStatement); ok && tc.structs[st.name.String()] == st.atype {
+			tc.DeclareStruct(st)
+		}
 	}
 
-	if typeChecker.errorReporter.HadError() {
-		return errorReporter
+	// define global functions
+	for _, stmt := range ast {
+		fn, ok := stmt.(*FunctionStatement)
+		if ok {
+			tc.ResolveFunctionType(fn.atype, fn.name)
+			name := fn.name.String()
+			if !tc.DefineFunction(name, fn.atype) {
+				tc.Error(fn.name, fmt.Sprintf("cannot redefine '%s'.", name))
+			}
+			tc.referenceGraph.AddUndefinedNode(fn)
+			tc.scopes.Define(name, fn)
+		}
 	}
-
-	return nil
 }
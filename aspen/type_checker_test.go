@@ -137,3 +137,28 @@ func TestTypeChecker(t *testing.T) {
 		tc.Run(t)
 	}
 }
+
+func TestTypeCheckerInfersLetType(t *testing.T) {
+	Initialize()
+	source := []rune("let x = 1.5; let f = fn() i64[] { return i64[]{}; }; let y u64;")
+
+	tokens, err := ScanTokens(source, NewErrorReporter(source))
+	if err != nil {
+		t.Fatalf("failed to scan tokens\n %v", err)
+	}
+
+	ast, err := Parse(tokens, NewErrorReporter(source))
+	if err != nil {
+		t.Fatalf("failed to parse source code\n %v", err)
+	}
+
+	err = TypeCheck(ast, NewErrorReporter(source))
+	if err != nil {
+		t.Fatalf("failed to type check source code\n %v", err)
+	}
+
+	expect := "((let x (inferred double) 1.50) (let f (inferred fn()i64[]) (fn (return i64[]) (return (slice i64[])))) (let y u64 (cast u64 0)))"
+	if ast.String() != expect {
+		t.Errorf("expected ast to be %s, got %s", expect, ast.String())
+	}
+}
@@ -14,7 +14,12 @@ const (
 	TYPE_STRING
 	TYPE_DOUBLE
 	TYPE_SLICE
+	TYPE_MAP
+	TYPE_TUPLE
 	TYPE_FUNCTION
+	TYPE_STRUCT
+	TYPE_OPTIONAL
+	TYPE_NIL
 	TYPE_VOID
 )
 
@@ -26,6 +31,11 @@ func (t TypeEnum) IsIntegral() bool {
 	return t == TYPE_I64 || t == TYPE_U64
 }
 
+// IsHashable reports whether values of the type can be used as map keys
+func (t TypeEnum) IsHashable() bool {
+	return t == TYPE_I64 || t == TYPE_U64 || t == TYPE_BOOL || t == TYPE_STRING || t == TYPE_DOUBLE
+}
+
 func (t TypeEnum) String() string {
 	switch t {
 	case TYPE_I64:
@@ -40,8 +50,18 @@ func (t TypeEnum) String() string {
 		return "double"
 	case TYPE_SLICE:
 		return "slice"
+	case TYPE_MAP:
+		return "map"
+	case TYPE_TUPLE:
+		return "tuple"
 	case TYPE_FUNCTION:
 		return "function"
+	case TYPE_STRUCT:
+		return "struct"
+	case TYPE_OPTIONAL:
+		return "optional"
+	case TYPE_NIL:
+		return "nil"
 	case TYPE_VOID:
 		return "void"
 	}
@@ -54,6 +74,19 @@ type SliceType struct {
 	of *Type
 }
 
+type MapType struct {
+	key   *Type
+	value *Type
+}
+
+type TupleType struct {
+	elements []*Type
+}
+
+type OptionalType struct {
+	of *Type
+}
+
 type FunctionType struct {
 	parameters []*Type
 	returnType *Type
@@ -63,22 +96,47 @@ func (t *FunctionType) Arity() int {
 	return len(t.parameters)
 }
 
+type StructField struct {
+	name  string
+	atype *Type
+}
+
+type StructType struct {
+	name    string
+	fields  []StructField
+	methods map[string]*FunctionStatement
+}
+
+// FieldIndex returns the index of the field called `name`, or -1 if there is no such field
+func (t *StructType) FieldIndex(name string) int {
+	for i := range t.fields {
+		if t.fields[i].name == name {
+			return i
+		}
+	}
+	return -1
+}
+
 type Type struct {
 	kind  TypeEnum
-	other interface{} // is either nil, or contains a `SliceType` or `FunctionType`
+	other interface{} // is either nil, or contains a `SliceType`, `MapType`, `TupleType`, `OptionalType`, `FunctionType` or `*StructType`
 }
 
 func (t Type) IsVoid() bool {
 	return t.kind == TYPE_VOID
 }
 
+func (t Type) IsOptional() bool {
+	return t.kind == TYPE_OPTIONAL
+}
+
 func (t Type) String() string {
 	switch t.kind {
-	case TYPE_I64, TYPE_U64, TYPE_BOOL, TYPE_STRING, TYPE_DOUBLE, TYPE_VOID:
+	case TYPE_I64, TYPE_U64, TYPE_BOOL, TYPE_STRING, TYPE_DOUBLE, TYPE_NIL, TYPE_VOID:
 		return t.kind.String()
 	case TYPE_SLICE:
 		other := t.other.(SliceType)
-		if other.of.kind == TYPE_FUNCTION {
+		if other.of.kind == TYPE_FUNCTION || other.of.kind == TYPE_MAP {
 			/**
 			 * if the `of` is a function, wrap the type in parentheses to resolve ambiguity
 			 * e.g. `fn()int[]` is a function that returns an `int[]` while `(fn()int)[]` is a slice of functions
@@ -87,6 +145,31 @@ func (t Type) String() string {
 		} else {
 			return fmt.Sprintf("%v[]", other.of)
 		}
+	case TYPE_OPTIONAL:
+		other := t.other.(OptionalType)
+		if other.of.kind == TYPE_FUNCTION || other.of.kind == TYPE_MAP {
+			// same as slices, `fn()int?` is a function that returns an `int?`
+			return fmt.Sprintf("(%v)?", other.of)
+		} else {
+			return fmt.Sprintf("%v?", other.of)
+		}
+	case TYPE_MAP:
+		other := t.other.(MapType)
+		return fmt.Sprintf("map[%v]%v", other.key, other.value)
+	case TYPE_TUPLE:
+		other := t.other.(TupleType)
+		builder := strings.Builder{}
+
+		builder.WriteRune('(')
+		for i, element := range other.elements {
+			fmt.Fprintf(&builder, "%v", element)
+			if i != len(other.elements)-1 {
+				builder.WriteString(", ")
+			}
+		}
+		builder.WriteRune(')')
+
+		return builder.String()
 	case TYPE_FUNCTION:
 		other := t.other.(FunctionType)
 		builder := strings.Builder{}
@@ -102,6 +185,8 @@ func (t Type) String() string {
 		fmt.Fprintf(&builder, ")%v", other.returnType)
 
 		return builder.String()
+	case TYPE_STRUCT:
+		return t.other.(*StructType).name
 	}
 
 	Unreachable("Type::String")
@@ -114,12 +199,35 @@ func TypesEqual(t1, t2 *Type) bool {
 	}
 
 	switch t1.kind {
-	case TYPE_I64, TYPE_U64, TYPE_BOOL, TYPE_STRING, TYPE_DOUBLE, TYPE_VOID:
+	case TYPE_I64, TYPE_U64, TYPE_BOOL, TYPE_STRING, TYPE_DOUBLE, TYPE_NIL, TYPE_VOID:
 		return true
 	case TYPE_SLICE:
 		other1 := t1.other.(SliceType)
 		other2 := t2.other.(SliceType)
 		return TypesEqual(other1.of, other2.of)
+	case TYPE_MAP:
+		other1 := t1.other.(MapType)
+		other2 := t2.other.(MapType)
+		return TypesEqual(other1.key, other2.key) && TypesEqual(other1.value, other2.value)
+	case TYPE_TUPLE:
+		other1 := t1.other.(TupleType)
+		other2 := t2.other.(TupleType)
+
+		if len(other1.elements) != len(other2.elements) {
+			return false
+		}
+
+		for i := range other1.elements {
+			if !TypesEqual(other1.elements[i], other2.elements[i]) {
+				return false
+			}
+		}
+
+		return true
+	case TYPE_OPTIONAL:
+		other1 := t1.other.(OptionalType)
+		other2 := t2.other.(OptionalType)
+		return TypesEqual(other1.of, other2.of)
 	case TYPE_FUNCTION:
 		other1 := t1.other.(FunctionType)
 		other2 := t2.other.(FunctionType)
@@ -135,12 +243,55 @@ func TypesEqual(t1, t2 *Type) bool {
 		}
 
 		return TypesEqual(other1.returnType, other2.returnType)
+	case TYPE_STRUCT:
+		// structs can only be declared at the top level, so their names are unique
+		return t1.other.(*StructType).name == t2.other.(*StructType).name
 	}
 
 	Unreachable("types.go: TypesEqual()")
 	return false
 }
 
+// IsAssignable reports whether a value of type `from` can be stored in a variable of type `to`.
+// TupleArity returns the number of elements of a tuple type, or 1 for any other type
+func TupleArity(t *Type) int {
+	if t.kind == TYPE_TUPLE {
+		return len(t.other.(TupleType).elements)
+	}
+	return 1
+}
+
+// On top of equal types, an optional `T?` accepts both `nil` and values of type `T`, and a tuple accepts
+// tuples whose elements are assignable to its own.
+func IsAssignable(to, from *Type) bool {
+	if TypesEqual(to, from) {
+		return true
+	}
+
+	if to.kind == TYPE_TUPLE && from.kind == TYPE_TUPLE {
+		toElements := to.other.(TupleType).elements
+		fromElements := from.other.(TupleType).elements
+
+		if len(toElements) != len(fromElements) {
+			return false
+		}
+
+		for i := range toElements {
+			if !IsAssignable(toElements[i], fromElements[i]) {
+				return false
+			}
+		}
+
+		return true
+	}
+
+	if to.kind == TYPE_OPTIONAL {
+		return from.kind == TYPE_NIL || TypesEqual(to.other.(OptionalType).of, from)
+	}
+
+	return false
+}
+
 func SimpleType(typeEnum TypeEnum) *Type {
 	return &Type{kind: typeEnum}
 }
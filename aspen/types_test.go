@@ -28,6 +28,9 @@ func TestTypesStringFunction(t *testing.T) {
 
 	noReturn := Type{kind: TYPE_FUNCTION, other: FunctionType{parameters: []*Type{}, returnType: SimpleType(TYPE_VOID)}}
 	expect(noReturn.String(), "fn()void")
+
+	tuple := Type{kind: TYPE_TUPLE, other: TupleType{elements: []*Type{SimpleType(TYPE_I64), SimpleType(TYPE_BOOL)}}}
+	expect(tuple.String(), "(i64, bool)")
 }
 
 func TestTypesEqual(t *testing.T) {
@@ -24,6 +24,12 @@ func UnescapeString(s string) (string, error) {
 				switch s[i] {
 				case 'n':
 					builder.WriteByte('\n')
+				case 't':
+					builder.WriteByte('\t')
+				case '0':
+					builder.WriteByte(0)
+				case '"', '\\':
+					builder.WriteByte(s[i])
 				default:
 					return "", errors.New("bad escape sequence")
 				}
@@ -0,0 +1,531 @@
+package main
+
+import "fmt"
+
+// CallFrame is a call to a closure in progress, the locals of the call start at `base` on the stack
+type CallFrame struct {
+	closure  *Closure
+	ip       int
+	base     int
+	callSite Token
+}
+
+type VM struct {
+	program      *CompiledProgram
+	stack        []interface{}
+	frames       []CallFrame
+	globals      []interface{}
+	openUpvalues *Upvalue
+	options      RuntimeOptions
+	budget       Budget
+
+	// the offset of the instruction being executed in the current frame
+	instruction int
+}
+
+// ConstantKey identifies a constant of a chunk, for Budget.AllocateConstant
+type ConstantKey struct {
+	chunk *Chunk
+	index int
+}
+
+func NewVM(program *CompiledProgram, options RuntimeOptions) *VM {
+	vm := &VM{
+		program: program,
+		options: options,
+		budget:  NewBudget(options),
+		stack:   make([]interface{}, 0, 256),
+		globals: make([]interface{}, len(program.globals)),
+	}
+
+	for slot, name := range program.globals {
+		if native, ok := NativeFunctions[name]; ok {
+			vm.globals[slot] = native
+		} else if builtin, ok := BuiltinFunctions[name]; ok {
+			vm.globals[slot] = builtin
+		}
+	}
+
+	return vm
+}
+
+func (vm *VM) RuntimeError(message string) {
+	frame := &vm.frames[len(vm.frames)-1]
+	position := frame.closure.chunk.positions[vm.instruction]
+	panic(&AspenRuntimeError{line: position.line, col: position.col, message: message, stack: vm.StackTrace()})
+}
+
+// LimitError stops the program because it exceeded one of its limits
+func (vm *VM) LimitError(err error) {
+	frame := &vm.frames[len(vm.frames)-1]
+	position := frame.closure.chunk.positions[vm.instruction]
+	panic(&AspenRuntimeError{
+		line:    position.line,
+		col:     position.col,
+		message: vm.budget.Message(err),
+		stack:   vm.StackTrace(),
+		cause:   err,
+	})
+}
+
+func (vm *VM) Step() {
+	if err := vm.budget.Step(); err != nil {
+		vm.LimitError(err)
+	}
+}
+
+func (vm *VM) Allocate(bytes int64) {
+	if err := vm.budget.Allocate(bytes); err != nil {
+		vm.LimitError(err)
+	}
+}
+
+// StackTrace returns the calls in progress, innermost call first. Top level code is not a call.
+func (vm *VM) StackTrace() []StackFrame {
+	trace := []StackFrame{}
+	for i := len(vm.frames) - 1; i > 0; i-- {
+		frame := &vm.frames[i]
+		trace = append(trace, StackFrame{function: frame.closure.chunk.Name(), callSite: frame.callSite})
+	}
+	return trace
+}
+
+func (vm *VM) Push(value interface{}) {
+	vm.stack = append(vm.stack, value)
+}
+
+func (vm *VM) Pop() interface{} {
+	value := vm.stack[len(vm.stack)-1]
+	vm.stack = vm.stack[:len(vm.stack)-1]
+	return value
+}
+
+func (vm *VM) Peek(distance int) interface{} {
+	return vm.stack[len(vm.stack)-1-distance]
+}
+
+// PopN removes the top `n` values of the stack and returns a copy of them, bottom first
+func (vm *VM) PopN(n int) []interface{} {
+	values := make([]interface{}, n)
+	copy(values, vm.stack[len(vm.stack)-n:])
+	vm.stack = vm.stack[:len(vm.stack)-n]
+	return values
+}
+
+// CaptureUpvalue returns the open upvalue for the stack slot `slot`, so that closures capturing the same
+// variable share it
+func (vm *VM) CaptureUpvalue(slot int) *Upvalue {
+	var previous *Upvalue
+	upvalue := vm.openUpvalues
+	for upvalue != nil && upvalue.slot > slot {
+		previous = upvalue
+		upvalue = upvalue.next
+	}
+
+	if upvalue != nil && upvalue.slot == slot {
+		return upvalue
+	}
+
+	created := &Upvalue{slot: slot, open: true, next: upvalue}
+	if previous == nil {
+		vm.openUpvalues = created
+	} else {
+		previous.next = created
+	}
+	return created
+}
+
+// CloseUpvalues moves the variables at or above the stack slot `last` into the upvalues that captured them
+func (vm *VM) CloseUpvalues(last int) {
+	for vm.openUpvalues != nil && vm.openUpvalues.slot >= last {
+		upvalue := vm.openUpvalues
+		upvalue.closed = vm.stack[upvalue.slot]
+		upvalue.open = false
+		vm.openUpvalues = upvalue.next
+	}
+}
+
+func (vm *VM) GetUpvalue(upvalue *Upvalue) interface{} {
+	if upvalue.open {
+		return vm.stack[upvalue.slot]
+	}
+	return upvalue.closed
+}
+
+func (vm *VM) SetUpvalue(upvalue *Upvalue, value interface{}) {
+	if upvalue.open {
+		vm.stack[upvalue.slot] = value
+	} else {
+		upvalue.closed = value
+	}
+}
+
+// ResolveField fills in the cache of a field access the first time it is executed on a struct type
+func (vm *VM) ResolveField(access *FieldAccess, atype *StructType) {
+	if access.atype == atype {
+		return
+	}
+
+	access.atype = atype
+	access.index = atype.FieldIndex(access.name)
+	if access.index == -1 {
+		access.method = vm.program.methods[atype.methods[access.name]]
+	}
+}
+
+// BindMethod creates a closure of a method whose `self` is `receiver`
+func (vm *VM) BindMethod(method *Chunk, receiver *StructValue) *Closure {
+	self := &Upvalue{closed: receiver}
+	closure := &Closure{chunk: method, upvalues: make([]*Upvalue, len(method.upvalues))}
+	for i := range closure.upvalues {
+		// methods are compiled in a scope whose only variable is `self`
+		closure.upvalues[i] = self
+	}
+	return closure
+}
+
+func (vm *VM) Call(closure *Closure, argc int, callSite Token) {
+	// the frame of top level code is not a call
+	if len(vm.frames) > vm.options.maxCallDepth {
+		vm.RuntimeError(StackOverflowMessage(vm.options.maxCallDepth))
+	}
+
+	vm.frames = append(vm.frames, CallFrame{
+		closure:  closure,
+		base:     len(vm.stack) - argc - 1,
+		callSite: callSite,
+	})
+}
+
+// CallNative calls the native or builtin function below the top `argc` values of the stack and counts the
+// memory allocated for its result
+func (vm *VM) CallNative(argc int) {
+	var result interface{}
+	var arguments []interface{}
+	switch callee := vm.Peek(argc).(type) {
+	case *NativeFunction:
+		arguments = vm.PopN(argc)
+		result = callee.impl(arguments)
+	case *BuiltinFunction:
+		arguments = vm.PopN(argc)
+		result = callee.impl(arguments)
+	default:
+		vm.RuntimeError("cannot call a nil function.")
+	}
+	vm.Allocate(ResultSize(vm.Peek(0), arguments, result))
+	vm.stack[len(vm.stack)-1] = result
+}
+
+func (vm *VM) Run() {
+	frame := &vm.frames[len(vm.frames)-1]
+	chunk := frame.closure.chunk
+	code := chunk.code
+
+	readOperand := func() int {
+		operand := int(code[frame.ip])<<8 | int(code[frame.ip+1])
+		frame.ip += 2
+		return operand
+	}
+
+	for {
+		vm.instruction = frame.ip
+		op := OpCode(code[frame.ip])
+		frame.ip++
+
+		switch op {
+		case OP_CONSTANT:
+			index := readOperand()
+			constant := chunk.constants[index]
+			if _, ok := constant.([]rune); ok {
+				if err := vm.budget.AllocateConstant(ConstantKey{chunk, index}, constant); err != nil {
+					vm.LimitError(err)
+				}
+			}
+			vm.Push(constant)
+		case OP_NIL:
+			vm.Push(nil)
+		case OP_TRUE:
+			vm.Push(true)
+		case OP_FALSE:
+			vm.Push(false)
+		case OP_POP:
+			vm.stack = vm.stack[:len(vm.stack)-1]
+
+		case OP_DEFINE_GLOBAL:
+			vm.globals[readOperand()] = vm.Pop()
+		case OP_GET_GLOBAL:
+			vm.Push(vm.globals[readOperand()])
+		case OP_SET_GLOBAL:
+			vm.globals[readOperand()] = vm.Peek(0)
+		case OP_GET_LOCAL:
+			vm.Push(vm.stack[frame.base+readOperand()])
+		case OP_SET_LOCAL:
+			vm.stack[frame.base+readOperand()] = vm.Peek(0)
+		case OP_GET_UPVALUE:
+			vm.Push(vm.GetUpvalue(frame.closure.upvalues[readOperand()]))
+		case OP_SET_UPVALUE:
+			vm.SetUpvalue(frame.closure.upvalues[readOperand()], vm.Peek(0))
+		case OP_CLOSE_UPVALUE:
+			vm.CloseUpvalues(len(vm.stack) - 1)
+			vm.Pop()
+		case OP_CHECK_NIL:
+			name := chunk.constants[readOperand()].(string)
+			if vm.Peek(0) == nil {
+				vm.RuntimeError(fmt.Sprintf("'%s' is nil.", name))
+			}
+
+		case OP_EQUAL:
+			rhs := vm.Pop()
+			vm.stack[len(vm.stack)-1] = ValuesEqual(vm.Peek(0), rhs)
+		case OP_NOT_EQUAL:
+			rhs := vm.Pop()
+			vm.stack[len(vm.stack)-1] = !ValuesEqual(vm.Peek(0), rhs)
+		case OP_GREATER:
+			rhs := vm.Pop()
+			vm.stack[len(vm.stack)-1] = OperatorGreater(vm.Peek(0), rhs)
+		case OP_GREATER_EQUAL:
+			rhs := vm.Pop()
+			vm.stack[len(vm.stack)-1] = OperatorGreaterEqual(vm.Peek(0), rhs)
+		case OP_LESS:
+			rhs := vm.Pop()
+			vm.stack[len(vm.stack)-1] = OperatorLess(vm.Peek(0), rhs)
+		case OP_LESS_EQUAL:
+			rhs := vm.Pop()
+			vm.stack[len(vm.stack)-1] = OperatorLessEqual(vm.Peek(0), rhs)
+		case OP_BIT_OR:
+			rhs := vm.Pop()
+			vm.stack[len(vm.stack)-1] = OperatorPipe(vm.Peek(0), rhs)
+		case OP_BIT_XOR:
+			rhs := vm.Pop()
+			vm.stack[len(vm.stack)-1] = OperatorCaret(vm.Peek(0), rhs)
+		case OP_BIT_AND:
+			rhs := vm.Pop()
+			vm.stack[len(vm.stack)-1] = OperatorAmp(vm.Peek(0), rhs)
+		case OP_ADD:
+			rhs := vm.Pop()
+			if lhs, ok := vm.Peek(0).([]rune); ok {
+				vm.Allocate(int64(len(lhs)+len(rhs.([]rune))) * STRING_CHAR_SIZE)
+				vm.stack[len(vm.stack)-1] = AddString(lhs, rhs.([]rune))
+			} else {
+				vm.stack[len(vm.stack)-1] = OperatorPlus(vm.Peek(0), rhs)
+			}
+		case OP_SUBTRACT:
+			rhs := vm.Pop()
+			vm.stack[len(vm.stack)-1] = OperatorMinus(vm.Peek(0), rhs)
+		case OP_MULTIPLY:
+			rhs := vm.Pop()
+			vm.stack[len(vm.stack)-1] = OperatorStar(vm.Peek(0), rhs)
+		case OP_DIVIDE:
+			rhs := vm.Pop()
+			if IsIntegerZero(rhs) {
+				vm.RuntimeError("integer division by zero.")
+			}
+			vm.stack[len(vm.stack)-1] = OperatorSlash(vm.Peek(0), rhs)
+		case OP_MODULUS:
+			rhs := vm.Pop()
+			if IsIntegerZero(rhs) {
+				vm.RuntimeError("integer division by zero.")
+			}
+			vm.stack[len(vm.stack)-1] = OperatorModulus(vm.Peek(0), rhs)
+		case OP_NOT:
+			vm.stack[len(vm.stack)-1] = !vm.Peek(0).(bool)
+		case OP_NEGATE:
+			switch v := vm.Peek(0).(type) {
+			case int64:
+				vm.stack[len(vm.stack)-1] = -v
+			case uint64:
+				vm.stack[len(vm.stack)-1] = -v
+			case float64:
+				vm.stack[len(vm.stack)-1] = -v
+			default:
+				Unreachable("VM::Run: OP_NEGATE")
+			}
+		case OP_CAST:
+			handler := chunk.constants[readOperand()].(func(interface{}) interface{})
+			vm.stack[len(vm.stack)-1] = handler(vm.Peek(0))
+
+		case OP_JUMP:
+			offset := readOperand()
+			frame.ip += offset
+		case OP_JUMP_IF_FALSE:
+			offset := readOperand()
+			if !vm.Peek(0).(bool) {
+				frame.ip += offset
+			}
+		case OP_LOOP:
+			offset := readOperand()
+			frame.ip -= offset
+			vm.Step()
+		case OP_CALL:
+			argc := readOperand()
+			vm.Step()
+			if callee, ok := vm.Peek(argc).(*Closure); ok {
+				vm.Call(callee, argc, chunk.positions[vm.instruction])
+				frame = &vm.frames[len(vm.frames)-1]
+				chunk = frame.closure.chunk
+				code = chunk.code
+			} else {
+				vm.CallNative(argc)
+			}
+		case OP_TAIL_CALL:
+			argc := readOperand()
+			vm.Step()
+			callee, ok := vm.Peek(argc).(*Closure)
+			if !ok {
+				// the OP_RETURN following the call returns the result of a native function
+				vm.CallNative(argc)
+				break
+			}
+
+			// replace the current call with the tail call
+			vm.CloseUpvalues(frame.base)
+			top := len(vm.stack) - argc - 1
+			copy(vm.stack[frame.base:], vm.stack[top:])
+			vm.stack = vm.stack[:frame.base+argc+1]
+
+			frame.closure = callee
+			frame.ip = 0
+			frame.callSite = chunk.positions[vm.instruction]
+			chunk = callee.chunk
+			code = chunk.code
+		case OP_CLOSURE:
+			function := chunk.constants[readOperand()].(*Chunk)
+			closure := &Closure{chunk: function, upvalues: make([]*Upvalue, len(function.upvalues))}
+			for i := range closure.upvalues {
+				isLocal := readOperand()
+				index := readOperand()
+				if isLocal == 1 {
+					closure.upvalues[i] = vm.CaptureUpvalue(frame.base + index)
+				} else {
+					closure.upvalues[i] = frame.closure.upvalues[index]
+				}
+			}
+			vm.Push(closure)
+		case OP_RETURN:
+			result := vm.Pop()
+			vm.CloseUpvalues(frame.base)
+
+			vm.frames = vm.frames[:len(vm.frames)-1]
+			if len(vm.frames) == 0 {
+				return
+			}
+
+			vm.stack = vm.stack[:frame.base]
+			vm.Push(result)
+
+			frame = &vm.frames[len(vm.frames)-1]
+			chunk = frame.closure.chunk
+			code = chunk.code
+		case OP_PRINT:
+			PrintValue(vm.Pop())
+
+		case OP_SLICE:
+			n := readOperand()
+			vm.Allocate(int64(n) * SLICE_ELEMENT_SIZE)
+			vm.Push(vm.PopN(n))
+		case OP_MAP:
+			n := readOperand()
+			vm.Allocate(int64(n) * MAP_ENTRY_SIZE)
+			entries := vm.PopN(2 * n)
+			m := NewMapValue()
+			for i := 0; i < len(entries); i += 2 {
+				m.Set(entries[i], entries[i+1])
+			}
+			vm.Push(m)
+		case OP_TUPLE:
+			vm.Push(TupleValue(vm.PopN(readOperand())))
+		case OP_STRUCT:
+			layout := chunk.constants[readOperand()].(*StructLayout)
+			value := &StructValue{atype: layout.atype, fields: make([]interface{}, len(layout.atype.fields))}
+			values := vm.PopN(len(layout.indices))
+			for i, index := range layout.indices {
+				value.fields[index] = values[i]
+			}
+			vm.Push(value)
+		case OP_INDEX:
+			index := vm.Pop()
+			switch object := vm.Peek(0).(type) {
+			case *MapValue:
+				value, ok := object.Get(index)
+				if !ok {
+					vm.RuntimeError(KeyNotFoundMessage(index))
+				}
+				vm.stack[len(vm.stack)-1] = value
+			case []interface{}:
+				i, inRange := SliceIndex(index, len(object))
+				if !inRange {
+					vm.RuntimeError(IndexOutOfRangeMessage(index, len(object)))
+				}
+				vm.stack[len(vm.stack)-1] = object[i]
+			default:
+				Unreachable("VM::Run: OP_INDEX")
+			}
+		case OP_CHECK_INDEX:
+			if slice, ok := vm.Peek(1).([]interface{}); ok {
+				index := vm.Peek(0)
+				if _, inRange := SliceIndex(index, len(slice)); !inRange {
+					vm.RuntimeError(IndexOutOfRangeMessage(index, len(slice)))
+				}
+			}
+		case OP_SET_INDEX:
+			value := vm.Pop()
+			index := vm.Pop()
+			switch object := vm.Peek(0).(type) {
+			case *MapValue:
+				if object.Set(index, value) {
+					vm.Allocate(MAP_ENTRY_SIZE)
+				}
+			case []interface{}:
+				i, _ := SliceIndex(index, len(object))
+				object[i] = value
+			default:
+				Unreachable("VM::Run: OP_SET_INDEX")
+			}
+			vm.stack[len(vm.stack)-1] = value
+		case OP_GET_FIELD:
+			access := chunk.constants[readOperand()].(*FieldAccess)
+			object := vm.Peek(0).(*StructValue)
+			vm.ResolveField(access, object.atype)
+			if access.index >= 0 {
+				vm.stack[len(vm.stack)-1] = object.fields[access.index]
+			} else {
+				vm.stack[len(vm.stack)-1] = vm.BindMethod(access.method, object)
+			}
+		case OP_SET_FIELD:
+			access := chunk.constants[readOperand()].(*FieldAccess)
+			value := vm.Pop()
+			object := vm.Peek(0).(*StructValue)
+			vm.ResolveField(access, object.atype)
+			object.fields[access.index] = value
+			vm.stack[len(vm.stack)-1] = value
+		case OP_DESTRUCTURE:
+			n := readOperand()
+			tuple := vm.Pop().(TupleValue)
+			vm.stack = append(vm.stack, tuple[:n]...)
+
+		default:
+			Unreachable(fmt.Sprintf("VM::Run: unknown instruction %d", op))
+		}
+	}
+}
+
+// RunBytecode executes a program compiled to bytecode, a runtime error stops the program and is returned as
+// an `*AspenRuntimeError`. Any other panic is a bug in the vm and is raised again.
+func RunBytecode(program *CompiledProgram, source []rune, options RuntimeOptions) (err error) {
+	vm := NewVM(program, options)
+
+	defer func() {
+		if r := recover(); r != nil {
+			runtimeError, ok := r.(*AspenRuntimeError)
+			if !ok {
+				panic(r)
+			}
+			runtimeError.source = source
+			err = runtimeError
+		}
+	}()
+
+	vm.frames = append(vm.frames, CallFrame{closure: &Closure{chunk: program.script}})
+	vm.Run()
+	return nil
+}
@@ -15,16 +15,43 @@ import (
 )
 
 var TimeoutError = errors.New("Timed out running program.")
+var StepLimitError = errors.New("Program exceeded the step limit.")
+var AllocationLimitError = errors.New("Program exceeded the memory limit.")
+
 var TimeoutDuration time.Duration
 
+// the limits passed on to aspen, empty if the program is not limited
+var MaxSteps string
+var MaxAllocation string
+
+// the exit codes aspen reports exceeded limits with
+const (
+	exitStepLimit       = 3
+	exitTimeLimit       = 4
+	exitAllocationLimit = 5
+)
+
+// killGracePeriod is how long aspen is given to stop by itself after its timeout before it is killed
+const killGracePeriod = 1000 * time.Millisecond
+
 func execute(source []byte) (string, error) {
-	// create a context with a timeout
-	ctx, cancel := context.WithTimeout(context.Background(), TimeoutDuration)
+	// create a context with a timeout, aspen enforces the timeout itself
+	// so the context only matters if aspen fails to stop in time
+	ctx, cancel := context.WithTimeout(context.Background(), TimeoutDuration+killGracePeriod)
 	defer cancel()
 
+	args := []string{"--timeout", TimeoutDuration.String()}
+	if MaxSteps != "" {
+		args = append(args, "--max-steps", MaxSteps)
+	}
+	if MaxAllocation != "" {
+		args = append(args, "--max-allocation", MaxAllocation)
+	}
+	args = append(args, "--stdin")
+
 	// create a command within the context. If the context is done
 	// before the command completes, the process will be killed
-	cmd := exec.CommandContext(ctx, aspenPath, "--stdin")
+	cmd := exec.CommandContext(ctx, aspenPath, args...)
 
 	// hook up stdout, stdin and stderr
 	var stdout bytes.Buffer
@@ -41,9 +68,13 @@ func execute(source []byte) (string, error) {
 		var e *exec.ExitError
 		if errors.As(err, &e) {
 			switch e.ProcessState.ExitCode() {
-			case -1:
-				// process was terminated by a kill signal because it timed out
+			case -1, exitTimeLimit:
+				// process timed out, or was terminated by a kill signal because it did not stop in time
 				return "", TimeoutError
+			case exitStepLimit:
+				return "", StepLimitError
+			case exitAllocationLimit:
+				return "", AllocationLimitError
 			case 1:
 				// program exited with error code 1, return stderr
 				return stderr.String(), nil
@@ -77,8 +108,8 @@ func run(w http.ResponseWriter, req *http.Request) {
 	output, err := execute(body)
 
 	if err != nil {
-		if errors.Is(err, TimeoutError) {
-			http.Error(w, TimeoutError.Error(), http.StatusUnprocessableEntity)
+		if errors.Is(err, TimeoutError) || errors.Is(err, StepLimitError) || errors.Is(err, AllocationLimitError) {
+			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
 			return
 		} else {
 			log.Print(err)
@@ -109,6 +140,9 @@ func main() {
 		TimeoutDuration = duration
 	}
 
+	MaxSteps = os.Getenv("PLAYGROUND_MAX_STEPS")
+	MaxAllocation = os.Getenv("PLAYGROUND_MAX_ALLOCATION")
+
 	aspenPath, ok = os.LookupEnv("PLAYGROUND_ASPEN_PATH")
 	if !ok {
 		log.Fatal("environment variable PLAYGROUND_ASPEN_PATH is not set")